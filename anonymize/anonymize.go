@@ -0,0 +1,208 @@
+// Package anonymize replaces IP addresses in parsed records with pseudonyms.
+//
+// Three modes are supported:
+//
+//   - cryptopan: prefix-preserving anonymization using Crypto-PAn, so two
+//     addresses sharing a prefix still share a prefix of the same length
+//     after anonymization.
+//   - truncate: zero out the host part of the address, keeping a /24 for
+//     IPv4 and a /48 for IPv6.
+//   - hmac: replace the address with one derived from a keyed HMAC-SHA256 of
+//     the original.
+//
+// The keyed modes are deterministic, so the same address always maps to the
+// same pseudonym across runs that share a key.
+package anonymize
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/netip"
+	"os"
+
+	"github.com/chazlever/rickybobby/iohandlers"
+	"github.com/miekg/dns"
+)
+
+// Modes lists the supported anonymization modes.
+var Modes = []string{"cryptopan", "truncate", "hmac"}
+
+const (
+	truncateIPv4Bits = 24
+	truncateIPv6Bits = 48
+
+	// Limit on the number of addresses remembered between records
+	maxCacheSize = 1 << 16
+)
+
+// An Anonymizer maps real addresses to pseudonyms of the same family.
+type Anonymizer struct {
+	anonymize func(netip.Addr) netip.Addr
+	cache     map[netip.Addr]netip.Addr
+
+	// AnonymizeRdata enables anonymization of the addresses in A and AAAA
+	// records in addition to the packet and ECS addresses.
+	AnonymizeRdata bool
+}
+
+// New creates an Anonymizer for the given mode. The cryptopan and hmac modes
+// require a key, which is ignored by truncate.
+func New(mode string, key []byte) (*Anonymizer, error) {
+	a := &Anonymizer{cache: make(map[netip.Addr]netip.Addr)}
+
+	switch mode {
+	case "cryptopan":
+		c, err := newCryptoPan(key)
+		if err != nil {
+			return nil, err
+		}
+		a.anonymize = c.anonymize
+	case "truncate":
+		a.anonymize = truncate
+	case "hmac":
+		if len(key) == 0 {
+			return nil, fmt.Errorf("hmac anonymization requires a key")
+		}
+		a.anonymize = func(addr netip.Addr) netip.Addr {
+			return keyedHash(key, addr)
+		}
+	default:
+		return nil, fmt.Errorf("unknown anonymization mode %q", mode)
+	}
+
+	return a, nil
+}
+
+// ReadKey loads a key from a file. Files containing only hexadecimal digits
+// are decoded, otherwise the raw contents are used.
+func ReadKey(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	trimmed := bytes.TrimSpace(data)
+	if key, err := hex.DecodeString(string(trimmed)); err == nil && len(key) > 0 {
+		return key, nil
+	}
+	return data, nil
+}
+
+// Addr returns the pseudonym for an address.
+func (a *Anonymizer) Addr(addr netip.Addr) netip.Addr {
+	addr = addr.Unmap()
+	if anon, ok := a.cache[addr]; ok {
+		return anon
+	}
+
+	anon := a.anonymize(addr)
+	if len(a.cache) >= maxCacheSize {
+		a.cache = make(map[netip.Addr]netip.Addr)
+	}
+	a.cache[addr] = anon
+	return anon
+}
+
+// String returns the pseudonym for a textual address. Values that are not
+// IP addresses are returned unchanged.
+func (a *Anonymizer) String(s string) string {
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return s
+	}
+	return a.Addr(addr).String()
+}
+
+// Apply anonymizes the addresses in a record. It always returns true so it
+// can be used as a stage in iohandlers.
+func (a *Anonymizer) Apply(d *iohandlers.DnsSchema) bool {
+	d.SourceAddress = a.String(d.SourceAddress)
+	d.DestinationAddress = a.String(d.DestinationAddress)
+
+	if d.EcsClient != nil {
+		ecsClient := a.String(*d.EcsClient)
+		d.EcsClient = &ecsClient
+	}
+
+	if a.AnonymizeRdata && d.Rdata != nil && d.Rtype != nil &&
+		(*d.Rtype == dns.TypeA || *d.Rtype == dns.TypeAAAA) {
+		rdata := a.String(*d.Rdata)
+		d.Rdata = &rdata
+	}
+
+	return true
+}
+
+func truncate(addr netip.Addr) netip.Addr {
+	bits := truncateIPv6Bits
+	if addr.Is4() {
+		bits = truncateIPv4Bits
+	}
+	prefix, _ := addr.Prefix(bits)
+	return prefix.Addr()
+}
+
+func keyedHash(key []byte, addr netip.Addr) netip.Addr {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(addr.AsSlice())
+	sum := mac.Sum(nil)
+
+	if addr.Is4() {
+		return netip.AddrFrom4([4]byte(sum[:4]))
+	}
+	return netip.AddrFrom16([16]byte(sum[:16]))
+}
+
+// cryptoPan implements the Crypto-PAn prefix-preserving scheme of Xu et al.
+// The first 16 bytes of the key are the AES key and the last 16 bytes are
+// encrypted to produce the padding.
+type cryptoPan struct {
+	block cipher.Block
+	pad   [aes.BlockSize]byte
+}
+
+func newCryptoPan(key []byte) (*cryptoPan, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("cryptopan anonymization requires a 32 byte key, got %d bytes", len(key))
+	}
+
+	block, err := aes.NewCipher(key[:16])
+	if err != nil {
+		return nil, err
+	}
+
+	c := &cryptoPan{block: block}
+	block.Encrypt(c.pad[:], key[16:])
+	return c, nil
+}
+
+func (c *cryptoPan) anonymize(addr netip.Addr) netip.Addr {
+	orig := addr.AsSlice()
+	result := make([]byte, len(orig))
+
+	var in, out [aes.BlockSize]byte
+	for i := 0; i < len(orig)*8; i++ {
+		// The input is the first i bits of the address followed by the pad
+		in = c.pad
+		copy(in[:i/8], orig)
+		if rem := i % 8; rem != 0 {
+			mask := byte(0xff << (8 - rem))
+			in[i/8] = orig[i/8]&mask | c.pad[i/8]&^mask
+		}
+
+		c.block.Encrypt(out[:], in[:])
+		result[i/8] |= (out[0] >> 7) << (7 - i%8)
+	}
+
+	for i := range result {
+		result[i] ^= orig[i]
+	}
+
+	anon, _ := netip.AddrFromSlice(result)
+	return anon
+}
@@ -0,0 +1,268 @@
+// Package cdns reads and writes C-DNS files, the compacted DNS traffic format
+// of RFC 8618. A file is a sequence of blocks, each holding up to a fixed
+// number of query/response items together with tables of the names,
+// addresses, RRs and other values they refer to, so repeated values are only
+// stored once per block.
+package cdns
+
+import (
+	"net/netip"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// A QueryResponse is a query together with its response. Either message may
+// be nil when only one of them was seen.
+type QueryResponse struct {
+	// Time is when the query was seen, or the response if there is no query.
+	Time time.Time
+	// ResponseDelay is the time from the query to the response.
+	ResponseDelay time.Duration
+
+	ClientAddress netip.Addr
+	ClientPort    uint16
+	ServerAddress netip.Addr
+	ServerPort    uint16
+	Tcp           bool
+
+	Query    *dns.Msg
+	Response *dns.Msg
+}
+
+const (
+	fileTypeId         = "C-DNS"
+	majorFormatVersion = 1
+	minorFormatVersion = 0
+
+	// Times are stored in microseconds
+	ticksPerSecond = 1000000
+)
+
+// A cmap is one of the maps of the format whose values are all unsigned
+// integers, keyed by the constants below.
+type cmap map[uint]uint64
+
+func (m cmap) get(key uint) (uint64, bool) {
+	v, ok := m[key]
+	return v, ok
+}
+
+// Keys of StorageHints
+const (
+	hintsQueryResponse = iota
+	hintsQueryResponseSignature
+	hintsRr
+	hintsOtherData
+)
+
+// Bits of the query-response-hints
+const (
+	qrHintTimeOffset = 1 << iota
+	qrHintClientAddressIndex
+	qrHintClientPort
+	qrHintTransactionId
+	qrHintQrSignatureIndex
+	qrHintClientHoplimit
+	qrHintResponseDelay
+	qrHintQueryNameIndex
+	qrHintQuerySize
+	qrHintResponseSize
+	qrHintResponseProcessingData
+	qrHintQueryQuestionSections
+	qrHintQueryAnswerSections
+	qrHintQueryAuthoritySections
+	qrHintQueryAdditionalSections
+	qrHintResponseAnswerSections
+	qrHintResponseAuthoritySections
+	qrHintResponseAdditionalSections
+)
+
+// Bits of the query-response-signature-hints
+const (
+	sigHintServerAddress = 1 << iota
+	sigHintServerPort
+	sigHintQrTransportFlags
+	sigHintQrType
+	sigHintQrSigFlags
+	sigHintQueryOpcode
+	sigHintQrDnsFlags
+	sigHintQueryRcode
+	sigHintQueryClassType
+	sigHintQueryQdcount
+	sigHintQueryAncount
+	sigHintQueryNscount
+	sigHintQueryArcount
+	sigHintQueryEdnsVersion
+	sigHintQueryUdpSize
+	sigHintQueryOptRdata
+	sigHintResponseRcode
+)
+
+// Bits of the rr-hints
+const (
+	rrHintTtl = 1 << iota
+	rrHintRdataIndex
+)
+
+// Bits of the storage-flags
+const (
+	storageFlagAnonymizedData = 1 << iota
+	storageFlagSampledData
+	storageFlagNormalizedNames
+)
+
+// Keys of BlockStatistics
+const (
+	statProcessedMessages = iota
+	statQrDataItems
+	statUnmatchedQueries
+	statUnmatchedResponses
+)
+
+// Keys of ClassType
+const (
+	classTypeType = iota
+	classTypeClass
+)
+
+// Keys of QueryResponseSignature
+const (
+	sigServerAddressIndex = iota
+	sigServerPort
+	sigQrTransportFlags
+	sigQrType
+	sigQrSigFlags
+	sigQueryOpcode
+	sigQrDnsFlags
+	sigQueryRcode
+	sigQueryClasstypeIndex
+	sigQueryQdcount
+	sigQueryAncount
+	sigQueryNscount
+	sigQueryArcount
+	sigQueryEdnsVersion
+	sigQueryUdpSize
+	sigQueryOptRdataIndex
+	sigResponseRcode
+)
+
+// Bits of the qr-transport-flags. The transport is held in bits 1 to 4.
+const (
+	transportIpv6         = 1
+	transportShift        = 1
+	transportUdp          = 0
+	transportTcp          = 1
+	transportValueMask    = 0xf
+	transportTrailingData = 1 << 5
+)
+
+// Bits of the qr-sig-flags
+const (
+	sigFlagHasQuery = 1 << iota
+	sigFlagHasResponse
+	sigFlagQueryHasQuestion
+	sigFlagQueryHasOpt
+	sigFlagResponseHasOpt
+	sigFlagResponseHasNoQuestion
+)
+
+// Bits of the qr-dns-flags. The flags of the response are shifted by 8.
+const (
+	dnsFlagCD = 1 << iota
+	dnsFlagAD
+	dnsFlagZ
+	dnsFlagRA
+	dnsFlagRD
+	dnsFlagTC
+	dnsFlagAA
+	dnsFlagQueryDO
+
+	responseFlagShift = 8
+)
+
+// Keys of Question
+const (
+	questionNameIndex = iota
+	questionClasstypeIndex
+)
+
+// Keys of RR
+const (
+	rrNameIndex = iota
+	rrClasstypeIndex
+	rrTtl
+	rrRdataIndex
+)
+
+// Keys of QueryResponseExtended
+const (
+	extendedQuestionIndex = iota
+	extendedAnswerIndex
+	extendedAuthorityIndex
+	extendedAdditionalIndex
+)
+
+type filePreamble struct {
+	MajorFormatVersion uint              `cbor:"0,keyasint"`
+	MinorFormatVersion uint              `cbor:"1,keyasint"`
+	PrivateVersion     *uint             `cbor:"2,keyasint,omitempty"`
+	BlockParameters    []blockParameters `cbor:"3,keyasint"`
+}
+
+type blockParameters struct {
+	StorageParameters    storageParameters     `cbor:"0,keyasint"`
+	CollectionParameters *collectionParameters `cbor:"1,keyasint,omitempty"`
+}
+
+type storageParameters struct {
+	TicksPerSecond      uint64   `cbor:"0,keyasint"`
+	MaxBlockItems       uint64   `cbor:"1,keyasint"`
+	StorageHints        cmap     `cbor:"2,keyasint"`
+	Opcodes             []uint64 `cbor:"3,keyasint"`
+	RrTypes             []uint64 `cbor:"4,keyasint"`
+	StorageFlags        uint64   `cbor:"5,keyasint,omitempty"`
+	AnonymizationMethod string   `cbor:"11,keyasint,omitempty"`
+}
+
+type collectionParameters struct {
+	QueryTimeout uint64 `cbor:"0,keyasint,omitempty"`
+	GeneratorId  string `cbor:"8,keyasint,omitempty"`
+	HostId       string `cbor:"9,keyasint,omitempty"`
+}
+
+type block struct {
+	Preamble       blockPreamble   `cbor:"0,keyasint"`
+	Statistics     cmap            `cbor:"1,keyasint,omitempty"`
+	Tables         *blockTables    `cbor:"2,keyasint,omitempty"`
+	QueryResponses []queryResponse `cbor:"3,keyasint,omitempty"`
+}
+
+type blockPreamble struct {
+	// EarliestTime holds the seconds and ticks of the earliest item
+	EarliestTime         []uint64 `cbor:"0,keyasint,omitempty"`
+	BlockParametersIndex uint64   `cbor:"1,keyasint,omitempty"`
+}
+
+type blockTables struct {
+	IpAddress [][]byte `cbor:"0,keyasint,omitempty"`
+	Classtype []cmap   `cbor:"1,keyasint,omitempty"`
+	NameRdata [][]byte `cbor:"2,keyasint,omitempty"`
+	QrSig     []cmap   `cbor:"3,keyasint,omitempty"`
+	Qlist     [][]uint `cbor:"4,keyasint,omitempty"`
+	Qrr       []cmap   `cbor:"5,keyasint,omitempty"`
+	Rrlist    [][]uint `cbor:"6,keyasint,omitempty"`
+	Rr        []cmap   `cbor:"7,keyasint,omitempty"`
+}
+
+type queryResponse struct {
+	TimeOffset         *uint64 `cbor:"0,keyasint,omitempty"`
+	ClientAddressIndex *uint64 `cbor:"1,keyasint,omitempty"`
+	ClientPort         *uint64 `cbor:"2,keyasint,omitempty"`
+	TransactionId      *uint64 `cbor:"3,keyasint,omitempty"`
+	QrSignatureIndex   *uint64 `cbor:"4,keyasint,omitempty"`
+	ResponseDelay      *int64  `cbor:"6,keyasint,omitempty"`
+	QueryNameIndex     *uint64 `cbor:"7,keyasint,omitempty"`
+	QueryExtended      cmap    `cbor:"11,keyasint,omitempty"`
+	ResponseExtended   cmap    `cbor:"12,keyasint,omitempty"`
+}
@@ -0,0 +1,205 @@
+package cdns
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func mustRR(t *testing.T, s string) dns.RR {
+	t.Helper()
+	rr, err := dns.NewRR(s)
+	if err != nil {
+		t.Fatalf("dns.NewRR(%q): %v", s, err)
+	}
+	return rr
+}
+
+// testItems returns items of every kind: with both messages, with EDNS in
+// the query and the response, with only a response, with only a query,
+// over IPv6 and TCP, and with several questions.
+func testItems(t *testing.T) []*QueryResponse {
+	start := time.Date(2024, 5, 1, 12, 0, 0, 123456000, time.UTC)
+
+	query := new(dns.Msg)
+	query.SetQuestion("www.example.com.", dns.TypeA)
+	query.Id = 0x1234
+	query.SetEdns0(1232, true)
+	query.IsEdns0().Option = append(query.IsEdns0().Option, &dns.EDNS0_SUBNET{
+		Code:          dns.EDNS0SUBNET,
+		Family:        1,
+		SourceNetmask: 24,
+		Address:       net.ParseIP("192.0.2.0").To4(),
+	})
+
+	response := new(dns.Msg)
+	response.SetReply(query)
+	response.Authoritative = true
+	response.Answer = []dns.RR{
+		mustRR(t, "www.example.com. 300 IN CNAME web.example.com."),
+		mustRR(t, "web.example.com. 300 IN A 192.0.2.10"),
+		mustRR(t, "web.example.com. 300 IN A 192.0.2.11"),
+	}
+	response.Ns = []dns.RR{mustRR(t, "example.com. 86400 IN NS ns1.example.com.")}
+	response.Extra = []dns.RR{mustRR(t, "ns1.example.com. 86400 IN A 192.0.2.53")}
+	response.SetEdns0(4096, false)
+
+	nxdomain := new(dns.Msg)
+	nxdomain.SetQuestion("missing.example.org.", dns.TypeAAAA)
+	nxdomain.Id = 0xbeef
+	nxdomain.Response = true
+	nxdomain.RecursionDesired = true
+	nxdomain.RecursionAvailable = true
+	nxdomain.Rcode = dns.RcodeNameError
+	nxdomain.Ns = []dns.RR{mustRR(t, "example.org. 3600 IN SOA ns.example.org. admin.example.org. 2024050101 7200 3600 1209600 3600")}
+
+	unanswered := new(dns.Msg)
+	unanswered.SetQuestion("slow.example.net.", dns.TypeTXT)
+	unanswered.Id = 7
+
+	multi := new(dns.Msg)
+	multi.Id = 99
+	multi.Question = []dns.Question{
+		{Name: "a.example.", Qtype: dns.TypeA, Qclass: dns.ClassINET},
+		{Name: "b.example.", Qtype: dns.TypeMX, Qclass: dns.ClassCHAOS},
+	}
+
+	return []*QueryResponse{
+		{
+			Time:          start,
+			ResponseDelay: 1500 * time.Microsecond,
+			ClientAddress: netip.MustParseAddr("198.51.100.7"),
+			ClientPort:    53124,
+			ServerAddress: netip.MustParseAddr("192.0.2.53"),
+			ServerPort:    53,
+			Query:         query,
+			Response:      response,
+		},
+		{
+			Time:          start.Add(time.Second),
+			ClientAddress: netip.MustParseAddr("2001:db8::7"),
+			ClientPort:    40000,
+			ServerAddress: netip.MustParseAddr("2001:db8::53"),
+			ServerPort:    53,
+			Tcp:           true,
+			Response:      nxdomain,
+		},
+		{
+			Time:          start.Add(2 * time.Second),
+			ClientAddress: netip.MustParseAddr("198.51.100.8"),
+			ClientPort:    1000,
+			ServerAddress: netip.MustParseAddr("192.0.2.53"),
+			ServerPort:    53,
+			Query:         unanswered,
+		},
+		{
+			Time:          start.Add(3 * time.Second),
+			ClientAddress: netip.MustParseAddr("198.51.100.7"),
+			ClientPort:    53125,
+			ServerAddress: netip.MustParseAddr("192.0.2.53"),
+			ServerPort:    53,
+			Query:         multi,
+		},
+	}
+}
+
+func msgString(m *dns.Msg) string {
+	if m == nil {
+		return "<nil>"
+	}
+	return m.String()
+}
+
+func roundTrip(t *testing.T, items []*QueryResponse, blockSize int) []*QueryResponse {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, Options{BlockSize: blockSize, GeneratorId: "test", HostId: "sensor1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, qr := range items {
+		if err := w.Write(qr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := r.HostId(); got != "sensor1" {
+		t.Errorf("HostId() = %q, want %q", got, "sensor1")
+	}
+	var read []*QueryResponse
+	for {
+		qr, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		read = append(read, qr)
+	}
+	return read
+}
+
+func TestRoundTrip(t *testing.T) {
+	items := testItems(t)
+
+	// Blocks of one, of several and of all items
+	for _, blockSize := range []int{1, 3, 100} {
+		read := roundTrip(t, items, blockSize)
+		if len(read) != len(items) {
+			t.Fatalf("block size %d: read %d items, want %d", blockSize, len(read), len(items))
+		}
+
+		for i, want := range items {
+			got := read[i]
+			if !got.Time.Equal(want.Time) {
+				t.Errorf("block size %d, item %d: time %v, want %v", blockSize, i, got.Time, want.Time)
+			}
+			if got.ResponseDelay != want.ResponseDelay {
+				t.Errorf("block size %d, item %d: response delay %v, want %v", blockSize, i, got.ResponseDelay, want.ResponseDelay)
+			}
+			if got.ClientAddress != want.ClientAddress || got.ClientPort != want.ClientPort ||
+				got.ServerAddress != want.ServerAddress || got.ServerPort != want.ServerPort ||
+				got.Tcp != want.Tcp {
+				t.Errorf("block size %d, item %d: transport %v:%d -> %v:%d tcp=%v, want %v:%d -> %v:%d tcp=%v",
+					blockSize, i,
+					got.ClientAddress, got.ClientPort, got.ServerAddress, got.ServerPort, got.Tcp,
+					want.ClientAddress, want.ClientPort, want.ServerAddress, want.ServerPort, want.Tcp)
+			}
+			if g, w := msgString(got.Query), msgString(want.Query); g != w {
+				t.Errorf("block size %d, item %d: query\n%s\nwant\n%s", blockSize, i, g, w)
+			}
+			if g, w := msgString(got.Response), msgString(want.Response); g != w {
+				t.Errorf("block size %d, item %d: response\n%s\nwant\n%s", blockSize, i, g, w)
+			}
+		}
+	}
+}
+
+func TestWriteEmptyItem(t *testing.T) {
+	w, err := NewWriter(io.Discard, Options{BlockSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Write(&QueryResponse{}); err == nil {
+		t.Error("writing an item without a message succeeded")
+	}
+}
+
+func TestReadNotCdns(t *testing.T) {
+	if _, err := NewReader(bytes.NewReader([]byte("not a C-DNS file"))); err == nil {
+		t.Error("reading a file that isn't C-DNS succeeded")
+	}
+}
@@ -0,0 +1,573 @@
+package cdns
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"net/netip"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/miekg/dns"
+)
+
+// Blocks may hold more items than allowed by default
+var decMode, _ = cbor.DecOptions{
+	MaxArrayElements: math.MaxInt32,
+	MaxMapPairs:      math.MaxInt32,
+}.DecMode()
+
+// An ItemError is returned for an item that can't be converted back into
+// messages. Reading can go on with the next item.
+type ItemError struct {
+	Err error
+}
+
+func (e *ItemError) Error() string {
+	return "invalid query/response item: " + e.Err.Error()
+}
+
+func (e *ItemError) Unwrap() error {
+	return e.Err
+}
+
+// A Reader reads the query/response items of a C-DNS file one at a time,
+// decoding a single block at once.
+type Reader struct {
+	r        *bufio.Reader
+	preamble filePreamble
+
+	// The number of blocks left, or -1 if the blocks are an
+	// indefinite-length array
+	blocks int64
+	buf    []byte
+
+	block  block
+	params *storageParameters
+	next   int
+
+	// err is kept once the file can't be read any further
+	err error
+}
+
+// NewReader reads the header of a file and returns a Reader for its items.
+func NewReader(r io.Reader) (*Reader, error) {
+	cr := &Reader{r: bufio.NewReader(r)}
+
+	major, n, err := cr.readHead()
+	if err != nil {
+		return nil, err
+	}
+	if major != 4 || (n != 3 && n != -1) {
+		return nil, fmt.Errorf("not a C-DNS file")
+	}
+
+	var fileType string
+	if err := cr.decodeItem(&fileType); err != nil || fileType != fileTypeId {
+		return nil, fmt.Errorf("not a C-DNS file")
+	}
+	if err := cr.decodeItem(&cr.preamble); err != nil {
+		return nil, fmt.Errorf("invalid file preamble: %v", err)
+	}
+	if cr.preamble.MajorFormatVersion != majorFormatVersion {
+		return nil, fmt.Errorf("unsupported C-DNS version %d.%d",
+			cr.preamble.MajorFormatVersion, cr.preamble.MinorFormatVersion)
+	}
+	if len(cr.preamble.BlockParameters) == 0 {
+		return nil, fmt.Errorf("no block parameters in file preamble")
+	}
+
+	if major, cr.blocks, err = cr.readHead(); err != nil {
+		return nil, err
+	} else if major != 4 {
+		return nil, fmt.Errorf("invalid file blocks")
+	}
+	return cr, nil
+}
+
+// HostId returns the name of the host the traffic was collected on, if the
+// file records one.
+func (cr *Reader) HostId() string {
+	if c := cr.preamble.BlockParameters[0].CollectionParameters; c != nil {
+		return c.HostId
+	}
+	return ""
+}
+
+// Read returns the next item, or io.EOF at the end of the file. Errors other
+// than an ItemError end the file.
+func (cr *Reader) Read() (*QueryResponse, error) {
+	for cr.err == nil && cr.next >= len(cr.block.QueryResponses) {
+		cr.err = cr.readBlock()
+	}
+	if cr.err != nil {
+		return nil, cr.err
+	}
+
+	item := &cr.block.QueryResponses[cr.next]
+	cr.next++
+	qr, err := cr.convert(item)
+	if err != nil {
+		return nil, &ItemError{err}
+	}
+	return qr, nil
+}
+
+func (cr *Reader) readBlock() error {
+	switch cr.blocks {
+	case 0:
+		return io.EOF
+	case -1:
+		if b, err := cr.r.Peek(1); err != nil {
+			return io.ErrUnexpectedEOF
+		} else if b[0] == 0xff {
+			cr.blocks = 0
+			return io.EOF
+		}
+	default:
+		cr.blocks--
+	}
+
+	cr.block = block{}
+	cr.next = 0
+	if err := cr.decodeItem(&cr.block); err != nil {
+		return fmt.Errorf("invalid block: %v", err)
+	}
+	if cr.block.Tables == nil {
+		cr.block.Tables = &blockTables{}
+	}
+
+	i := cr.block.Preamble.BlockParametersIndex
+	if i >= uint64(len(cr.preamble.BlockParameters)) {
+		return fmt.Errorf("invalid block parameters index %d", i)
+	}
+	cr.params = &cr.preamble.BlockParameters[i].StorageParameters
+	if cr.params.TicksPerSecond == 0 {
+		return fmt.Errorf("invalid ticks per second")
+	}
+	return nil
+}
+
+// readHead reads the initial byte and argument of a data item. The argument
+// is -1 for indefinite-length items.
+func (cr *Reader) readHead() (byte, int64, error) {
+	cr.buf = cr.buf[:0]
+	if err := cr.copyHead(); err != nil {
+		return 0, 0, err
+	}
+	major, arg, indefinite := parseHead(cr.buf)
+	if indefinite {
+		return major, -1, nil
+	}
+	return major, int64(arg), nil
+}
+
+// decodeItem reads a data item and decodes it into v.
+func (cr *Reader) decodeItem(v interface{}) error {
+	cr.buf = cr.buf[:0]
+	if err := cr.copyItem(); err != nil {
+		return err
+	}
+	return decMode.Unmarshal(cr.buf, v)
+}
+
+// parseHead returns the major type and argument of the head at the start
+// of data, which must be complete.
+func parseHead(data []byte) (major byte, arg uint64, indefinite bool) {
+	major, info := data[0]>>5, data[0]&0x1f
+	switch {
+	case info < 24:
+		arg = uint64(info)
+	case info == 31:
+		indefinite = true
+	default:
+		for _, b := range data[1:] {
+			arg = arg<<8 | uint64(b)
+		}
+	}
+	return major, arg, indefinite
+}
+
+// copyHead appends the head of the next data item to the buffer.
+func (cr *Reader) copyHead() error {
+	ib, err := cr.r.ReadByte()
+	if err != nil {
+		return err
+	}
+	cr.buf = append(cr.buf, ib)
+
+	switch info := ib & 0x1f; {
+	case info < 24, info == 31:
+		return nil
+	case info <= 27:
+		start := len(cr.buf)
+		cr.buf = append(cr.buf, make([]byte, 1<<(info-24))...)
+		_, err := io.ReadFull(cr.r, cr.buf[start:])
+		return unexpectedEOF(err)
+	}
+	return fmt.Errorf("malformed CBOR")
+}
+
+// copyItem appends the encoding of the next data item to the buffer,
+// without decoding it.
+func (cr *Reader) copyItem() error {
+	start := len(cr.buf)
+	if err := cr.copyHead(); err != nil {
+		return err
+	}
+	major, arg, indefinite := parseHead(cr.buf[start:])
+
+	if indefinite {
+		if major < 2 || major == 6 {
+			return fmt.Errorf("malformed CBOR")
+		}
+		if major == 7 {
+			return fmt.Errorf("unexpected break in CBOR")
+		}
+		// Items until the break code
+		for {
+			b, err := cr.r.Peek(1)
+			if err != nil {
+				return unexpectedEOF(err)
+			}
+			if b[0] == 0xff {
+				cr.r.ReadByte()
+				cr.buf = append(cr.buf, 0xff)
+				return nil
+			}
+			if err := cr.copyItem(); err != nil {
+				return unexpectedEOF(err)
+			}
+		}
+	}
+
+	var items uint64
+	switch major {
+	case 2, 3:
+		n, err := io.CopyN(&bufferWriter{cr}, cr.r, int64(arg))
+		if err != nil || uint64(n) != arg {
+			return io.ErrUnexpectedEOF
+		}
+	case 4:
+		items = arg
+	case 5:
+		items = 2 * arg
+	case 6:
+		items = 1
+	}
+	for ; items > 0; items-- {
+		if err := cr.copyItem(); err != nil {
+			return unexpectedEOF(err)
+		}
+	}
+	return nil
+}
+
+// bufferWriter appends to the buffer of a Reader.
+type bufferWriter struct {
+	cr *Reader
+}
+
+func (w *bufferWriter) Write(p []byte) (int, error) {
+	w.cr.buf = append(w.cr.buf, p...)
+	return len(p), nil
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// convert turns an item of the current block back into DNS messages.
+func (cr *Reader) convert(item *queryResponse) (*QueryResponse, error) {
+	t := cr.block.Tables
+	qr := &QueryResponse{}
+
+	// The signature is required to know which messages the item holds
+	if item.QrSignatureIndex == nil || *item.QrSignatureIndex >= uint64(len(t.QrSig)) {
+		return nil, fmt.Errorf("item without a valid signature")
+	}
+	sig := t.QrSig[*item.QrSignatureIndex]
+	flags, _ := sig.get(sigQrSigFlags)
+	dnsFlags, _ := sig.get(sigQrDnsFlags)
+	transport, _ := sig.get(sigQrTransportFlags)
+	ipv6 := transport&transportIpv6 != 0
+	qr.Tcp = (transport>>transportShift)&transportValueMask == transportTcp
+
+	var err error
+	if len(cr.block.Preamble.EarliestTime) == 2 {
+		et := cr.block.Preamble.EarliestTime
+		qr.Time = time.Unix(int64(et[0]), 0).Add(cr.duration(int64(et[1])))
+	}
+	if item.TimeOffset != nil {
+		qr.Time = qr.Time.Add(cr.duration(int64(*item.TimeOffset)))
+	}
+	if item.ResponseDelay != nil {
+		qr.ResponseDelay = cr.duration(*item.ResponseDelay)
+	}
+
+	if item.ClientAddressIndex != nil {
+		if qr.ClientAddress, err = cr.address(*item.ClientAddressIndex, ipv6); err != nil {
+			return nil, err
+		}
+	}
+	if item.ClientPort != nil {
+		qr.ClientPort = uint16(*item.ClientPort)
+	}
+	if i, ok := sig.get(sigServerAddressIndex); ok {
+		if qr.ServerAddress, err = cr.address(i, ipv6); err != nil {
+			return nil, err
+		}
+	}
+	if port, ok := sig.get(sigServerPort); ok {
+		qr.ServerPort = uint16(port)
+	}
+
+	// The first question is shared by the query and the response
+	var question *dns.Question
+	if item.QueryNameIndex != nil {
+		name, err := cr.name(*item.QueryNameIndex)
+		if err != nil {
+			return nil, err
+		}
+		question = &dns.Question{Name: name, Qtype: dns.TypeA, Qclass: dns.ClassINET}
+		if i, ok := sig.get(sigQueryClasstypeIndex); ok {
+			if question.Qtype, question.Qclass, err = cr.classType(i); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	opcode, _ := sig.get(sigQueryOpcode)
+	var id uint16
+	if item.TransactionId != nil {
+		id = uint16(*item.TransactionId)
+	}
+
+	if flags&sigFlagHasQuery != 0 {
+		m := &dns.Msg{}
+		m.Id = id
+		m.Opcode = int(opcode)
+		rcode, _ := sig.get(sigQueryRcode)
+		m.Rcode = int(rcode)
+		setHeaderFlags(&m.MsgHdr, dnsFlags)
+		if question != nil && flags&sigFlagQueryHasQuestion != 0 {
+			m.Question = append(m.Question, *question)
+		}
+		if err := cr.sections(m, item.QueryExtended); err != nil {
+			return nil, err
+		}
+
+		if flags&sigFlagQueryHasOpt != 0 {
+			opt, err := cr.queryOpt(sig, dnsFlags, m.Rcode)
+			if err != nil {
+				return nil, err
+			}
+			m.Extra = append(m.Extra, opt)
+		}
+		qr.Query = m
+	}
+
+	if flags&sigFlagHasResponse != 0 {
+		m := &dns.Msg{}
+		m.Id = id
+		m.Response = true
+		m.Opcode = int(opcode)
+		rcode, _ := sig.get(sigResponseRcode)
+		m.Rcode = int(rcode)
+		setHeaderFlags(&m.MsgHdr, dnsFlags>>responseFlagShift)
+		if question != nil && flags&sigFlagResponseHasNoQuestion == 0 {
+			m.Question = append(m.Question, *question)
+		}
+		if err := cr.sections(m, item.ResponseExtended); err != nil {
+			return nil, err
+		}
+		qr.Response = m
+	}
+
+	if qr.Query == nil && qr.Response == nil {
+		return nil, fmt.Errorf("item without a query or response")
+	}
+	return qr, nil
+}
+
+// duration converts ticks to a duration.
+func (cr *Reader) duration(ticks int64) time.Duration {
+	tps := int64(cr.params.TicksPerSecond)
+	return time.Duration(ticks/tps)*time.Second + time.Duration(ticks%tps)*time.Second/time.Duration(tps)
+}
+
+// setHeaderFlags sets the header flags from the qr-dns-flags of a message.
+func setHeaderFlags(h *dns.MsgHdr, flags uint64) {
+	h.CheckingDisabled = flags&dnsFlagCD != 0
+	h.AuthenticatedData = flags&dnsFlagAD != 0
+	h.Zero = flags&dnsFlagZ != 0
+	h.RecursionAvailable = flags&dnsFlagRA != 0
+	h.RecursionDesired = flags&dnsFlagRD != 0
+	h.Truncated = flags&dnsFlagTC != 0
+	h.Authoritative = flags&dnsFlagAA != 0
+}
+
+// address returns an address from the table. Addresses may have been stored
+// truncated to a prefix, in which case the rest is filled with zeros.
+func (cr *Reader) address(i uint64, ipv6 bool) (netip.Addr, error) {
+	t := cr.block.Tables.IpAddress
+	if i >= uint64(len(t)) {
+		return netip.Addr{}, fmt.Errorf("invalid address index %d", i)
+	}
+
+	size := 4
+	if ipv6 || len(t[i]) > 4 {
+		size = 16
+	}
+	if len(t[i]) > size {
+		return netip.Addr{}, fmt.Errorf("invalid address of %d bytes", len(t[i]))
+	}
+	addr := make([]byte, size)
+	copy(addr, t[i])
+	a, _ := netip.AddrFromSlice(addr)
+	return a, nil
+}
+
+func (cr *Reader) nameRdata(i uint64) ([]byte, error) {
+	t := cr.block.Tables.NameRdata
+	if i >= uint64(len(t)) {
+		return nil, fmt.Errorf("invalid name or RDATA index %d", i)
+	}
+	return t[i], nil
+}
+
+func (cr *Reader) name(i uint64) (string, error) {
+	wire, err := cr.nameRdata(i)
+	if err != nil {
+		return "", err
+	}
+	name, _, err := dns.UnpackDomainName(wire, 0)
+	return name, err
+}
+
+func (cr *Reader) classType(i uint64) (uint16, uint16, error) {
+	t := cr.block.Tables.Classtype
+	if i >= uint64(len(t)) {
+		return 0, 0, fmt.Errorf("invalid class and type index %d", i)
+	}
+	rrtype, _ := t[i].get(classTypeType)
+	class, _ := t[i].get(classTypeClass)
+	return uint16(rrtype), uint16(class), nil
+}
+
+// unpackRR puts an RR back together from its parts in wire format.
+func unpackRR(name []byte, rrtype, class uint16, ttl uint32, rdata []byte) (dns.RR, error) {
+	wire := make([]byte, 0, len(name)+10+len(rdata))
+	wire = append(wire, name...)
+	wire = binary.BigEndian.AppendUint16(wire, rrtype)
+	wire = binary.BigEndian.AppendUint16(wire, class)
+	wire = binary.BigEndian.AppendUint32(wire, ttl)
+	wire = binary.BigEndian.AppendUint16(wire, uint16(len(rdata)))
+	wire = append(wire, rdata...)
+	rr, _, err := dns.UnpackRR(wire, 0)
+	return rr, err
+}
+
+func (cr *Reader) rr(i uint64) (dns.RR, error) {
+	t := cr.block.Tables.Rr
+	if i >= uint64(len(t)) {
+		return nil, fmt.Errorf("invalid RR index %d", i)
+	}
+	entry := t[i]
+
+	nameIndex, _ := entry.get(rrNameIndex)
+	name, err := cr.nameRdata(nameIndex)
+	if err != nil {
+		return nil, err
+	}
+	classTypeIndex, _ := entry.get(rrClasstypeIndex)
+	rrtype, class, err := cr.classType(classTypeIndex)
+	if err != nil {
+		return nil, err
+	}
+	ttl, _ := entry.get(rrTtl)
+
+	var rdata []byte
+	if rdataIndex, ok := entry.get(rrRdataIndex); ok {
+		if rdata, err = cr.nameRdata(rdataIndex); err != nil {
+			return nil, err
+		}
+	}
+	return unpackRR(name, rrtype, class, uint32(ttl), rdata)
+}
+
+// sections adds the questions after the first and the RRs of each section
+// to a message.
+func (cr *Reader) sections(m *dns.Msg, ext cmap) error {
+	t := cr.block.Tables
+	if i, ok := ext.get(extendedQuestionIndex); ok {
+		if i >= uint64(len(t.Qlist)) {
+			return fmt.Errorf("invalid question list index %d", i)
+		}
+		for _, qi := range t.Qlist[i] {
+			if uint64(qi) >= uint64(len(t.Qrr)) {
+				return fmt.Errorf("invalid question index %d", qi)
+			}
+			nameIndex, _ := t.Qrr[qi].get(questionNameIndex)
+			name, err := cr.name(nameIndex)
+			if err != nil {
+				return err
+			}
+			classTypeIndex, _ := t.Qrr[qi].get(questionClasstypeIndex)
+			qtype, qclass, err := cr.classType(classTypeIndex)
+			if err != nil {
+				return err
+			}
+			m.Question = append(m.Question, dns.Question{Name: name, Qtype: qtype, Qclass: qclass})
+		}
+	}
+
+	for _, section := range []struct {
+		key uint
+		rrs *[]dns.RR
+	}{
+		{extendedAnswerIndex, &m.Answer},
+		{extendedAuthorityIndex, &m.Ns},
+		{extendedAdditionalIndex, &m.Extra},
+	} {
+		i, ok := ext.get(section.key)
+		if !ok {
+			continue
+		}
+		if i >= uint64(len(t.Rrlist)) {
+			return fmt.Errorf("invalid RR list index %d", i)
+		}
+		for _, ri := range t.Rrlist[i] {
+			rr, err := cr.rr(uint64(ri))
+			if err != nil {
+				return err
+			}
+			*section.rrs = append(*section.rrs, rr)
+		}
+	}
+	return nil
+}
+
+// queryOpt puts the OPT RR of a query back together from the signature.
+func (cr *Reader) queryOpt(sig cmap, dnsFlags uint64, rcode int) (dns.RR, error) {
+	udpSize, _ := sig.get(sigQueryUdpSize)
+	version, _ := sig.get(sigQueryEdnsVersion)
+	ttl := uint32(rcode>>4)<<24 | uint32(version)<<16
+	if dnsFlags&dnsFlagQueryDO != 0 {
+		ttl |= 1 << 15
+	}
+
+	var rdata []byte
+	if i, ok := sig.get(sigQueryOptRdataIndex); ok {
+		var err error
+		if rdata, err = cr.nameRdata(i); err != nil {
+			return nil, err
+		}
+	}
+	return unpackRR([]byte{0}, dns.TypeOPT, uint16(udpSize), ttl, rdata)
+}
@@ -0,0 +1,454 @@
+package cdns
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/miekg/dns"
+)
+
+// Options configures a Writer.
+type Options struct {
+	// BlockSize is the maximum number of query/response items in a block.
+	BlockSize int
+	// QueryTimeout is how long queries waited for a response before they
+	// were written without one.
+	QueryTimeout time.Duration
+	// GeneratorId names the program that wrote the file.
+	GeneratorId string
+	// HostId names the host the traffic was collected on.
+	HostId string
+	// AnonymizationMethod describes how addresses were anonymized, if they
+	// were.
+	AnonymizationMethod string
+}
+
+// A table collects the distinct values of a block table, so each one is only
+// stored once.
+type table[T any] struct {
+	items []T
+	index map[string]uint64
+}
+
+// add returns the index of a value, adding it if it isn't in the table yet.
+// The key identifies the value.
+func (t *table[T]) add(key string, item T) uint64 {
+	if i, ok := t.index[key]; ok {
+		return i
+	}
+	if t.index == nil {
+		t.index = make(map[string]uint64)
+	}
+	i := uint64(len(t.items))
+	t.items = append(t.items, item)
+	t.index[key] = i
+	return i
+}
+
+// A blockBuilder holds the contents of the block being written.
+type blockBuilder struct {
+	ipAddress table[[]byte]
+	classtype table[cmap]
+	nameRdata table[[]byte]
+	qrSig     table[cmap]
+	qlist     table[[]uint]
+	qrr       table[cmap]
+	rrlist    table[[]uint]
+	rr        table[cmap]
+
+	items []queryResponse
+	times []time.Time
+	stats cmap
+}
+
+func newBlockBuilder() *blockBuilder {
+	return &blockBuilder{stats: cmap{}}
+}
+
+// A Writer writes query/response items to a C-DNS file. The file is written
+// as it goes, one block at a time.
+type Writer struct {
+	w         *bufio.Writer
+	enc       cbor.EncMode
+	blockSize int
+	block     *blockBuilder
+}
+
+// NewWriter writes the header of a file and returns a Writer for its items.
+func NewWriter(w io.Writer, opts Options) (*Writer, error) {
+	if opts.BlockSize < 1 {
+		return nil, fmt.Errorf("invalid block size %d", opts.BlockSize)
+	}
+
+	enc, err := cbor.CanonicalEncOptions().EncMode()
+	if err != nil {
+		return nil, err
+	}
+
+	storage := storageParameters{
+		TicksPerSecond: ticksPerSecond,
+		MaxBlockItems:  uint64(opts.BlockSize),
+		StorageHints: cmap{
+			// Message sizes, hop limits and response processing data
+			// aren't known once messages have been decoded
+			hintsQueryResponse: (qrHintResponseAdditionalSections<<1 - 1) &^
+				(qrHintClientHoplimit | qrHintQuerySize | qrHintResponseSize | qrHintResponseProcessingData),
+			hintsQueryResponseSignature: (sigHintResponseRcode<<1 - 1) &^ sigHintQrType,
+			hintsRr:                     rrHintTtl | rrHintRdataIndex,
+			hintsOtherData:              0,
+		},
+	}
+	for opcode := range dns.OpcodeToString {
+		storage.Opcodes = append(storage.Opcodes, uint64(opcode))
+	}
+	for rrtype := range dns.TypeToString {
+		storage.RrTypes = append(storage.RrTypes, uint64(rrtype))
+	}
+	sort.Slice(storage.Opcodes, func(i, j int) bool { return storage.Opcodes[i] < storage.Opcodes[j] })
+	sort.Slice(storage.RrTypes, func(i, j int) bool { return storage.RrTypes[i] < storage.RrTypes[j] })
+	if opts.AnonymizationMethod != "" {
+		storage.StorageFlags |= storageFlagAnonymizedData
+		storage.AnonymizationMethod = opts.AnonymizationMethod
+	}
+
+	preamble := filePreamble{
+		MajorFormatVersion: majorFormatVersion,
+		MinorFormatVersion: minorFormatVersion,
+		BlockParameters: []blockParameters{{
+			StorageParameters: storage,
+			CollectionParameters: &collectionParameters{
+				QueryTimeout: uint64(opts.QueryTimeout.Milliseconds()),
+				GeneratorId:  opts.GeneratorId,
+				HostId:       opts.HostId,
+			},
+		}},
+	}
+
+	cw := &Writer{
+		w:         bufio.NewWriter(w),
+		enc:       enc,
+		blockSize: opts.BlockSize,
+		block:     newBlockBuilder(),
+	}
+
+	// The file is an array of the file type, the preamble and the blocks.
+	// The blocks are an indefinite-length array so they can be streamed.
+	cw.w.WriteByte(0x83)
+	for _, v := range []interface{}{fileTypeId, preamble} {
+		data, err := enc.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		cw.w.Write(data)
+	}
+	if err := cw.w.WriteByte(0x9f); err != nil {
+		return nil, err
+	}
+	return cw, nil
+}
+
+// Write adds an item to the current block, writing the block once it is
+// full.
+func (cw *Writer) Write(qr *QueryResponse) error {
+	if qr.Query == nil && qr.Response == nil {
+		return fmt.Errorf("query/response item without a message")
+	}
+
+	b := cw.block
+	item := queryResponse{}
+	sig := cmap{}
+
+	client := b.address(qr.ClientAddress.AsSlice())
+	item.ClientAddressIndex = &client
+	clientPort := uint64(qr.ClientPort)
+	item.ClientPort = &clientPort
+	sig[sigServerAddressIndex] = b.address(qr.ServerAddress.AsSlice())
+	sig[sigServerPort] = uint64(qr.ServerPort)
+
+	var transport uint64 = transportUdp
+	if qr.Tcp {
+		transport = transportTcp
+	}
+	transport <<= transportShift
+	if qr.ClientAddress.Is6() && !qr.ClientAddress.Is4In6() {
+		transport |= transportIpv6
+	}
+	sig[sigQrTransportFlags] = transport
+
+	// The first question is part of the signature and the item, while the
+	// others are stored with the sections of the message
+	var flags, dnsFlags uint64
+	first := qr.Query
+	if first == nil {
+		first = qr.Response
+	}
+	if len(first.Question) > 0 {
+		q := first.Question[0]
+		name := b.name(q.Name)
+		item.QueryNameIndex = &name
+		sig[sigQueryClasstypeIndex] = b.classType(q.Qtype, q.Qclass)
+	}
+	sig[sigQueryOpcode] = uint64(first.Opcode)
+
+	if m := qr.Query; m != nil {
+		flags |= sigFlagHasQuery
+		if len(m.Question) > 0 {
+			flags |= sigFlagQueryHasQuestion
+		}
+		dnsFlags |= headerFlags(&m.MsgHdr)
+		sig[sigQueryRcode] = uint64(m.Rcode)
+		sig[sigQueryQdcount] = uint64(len(m.Question))
+		sig[sigQueryAncount] = uint64(len(m.Answer))
+		sig[sigQueryNscount] = uint64(len(m.Ns))
+		sig[sigQueryArcount] = uint64(len(m.Extra))
+
+		if opt := m.IsEdns0(); opt != nil {
+			flags |= sigFlagQueryHasOpt
+			if opt.Do() {
+				dnsFlags |= dnsFlagQueryDO
+			}
+			sig[sigQueryEdnsVersion] = uint64(opt.Version())
+			sig[sigQueryUdpSize] = uint64(opt.UDPSize())
+			rdata, err := packRdata(opt)
+			if err != nil {
+				return err
+			}
+			sig[sigQueryOptRdataIndex] = b.nameRdata.add(string(rdata), rdata)
+		}
+
+		id := uint64(m.Id)
+		item.TransactionId = &id
+		item.QueryExtended = b.sections(m, true)
+	}
+
+	if m := qr.Response; m != nil {
+		flags |= sigFlagHasResponse
+		if len(m.Question) == 0 {
+			flags |= sigFlagResponseHasNoQuestion
+		}
+		if m.IsEdns0() != nil {
+			flags |= sigFlagResponseHasOpt
+		}
+		dnsFlags |= headerFlags(&m.MsgHdr) << responseFlagShift
+		sig[sigResponseRcode] = uint64(m.Rcode)
+
+		if qr.Query == nil {
+			id := uint64(m.Id)
+			item.TransactionId = &id
+		} else {
+			delay := ticks(qr.ResponseDelay)
+			item.ResponseDelay = &delay
+		}
+		item.ResponseExtended = b.sections(m, false)
+	}
+
+	sig[sigQrSigFlags] = flags
+	sig[sigQrDnsFlags] = dnsFlags
+	sigIndex := b.qrSig.add(fmt.Sprint(sig), sig)
+	item.QrSignatureIndex = &sigIndex
+
+	b.items = append(b.items, item)
+	b.times = append(b.times, qr.Time)
+	if qr.Query != nil {
+		b.stats[statProcessedMessages]++
+	}
+	if qr.Response != nil {
+		b.stats[statProcessedMessages]++
+	}
+	switch {
+	case qr.Response == nil:
+		b.stats[statUnmatchedQueries]++
+	case qr.Query == nil:
+		b.stats[statUnmatchedResponses]++
+	}
+
+	if len(b.items) >= cw.blockSize {
+		return cw.flush()
+	}
+	return nil
+}
+
+// ticks converts a duration to ticks.
+func ticks(d time.Duration) int64 {
+	return d.Nanoseconds() / (int64(time.Second) / ticksPerSecond)
+}
+
+// headerFlags returns the qr-dns-flags of the header of a message.
+func headerFlags(h *dns.MsgHdr) uint64 {
+	var flags uint64
+	for _, f := range []struct {
+		set  bool
+		flag uint64
+	}{
+		{h.CheckingDisabled, dnsFlagCD},
+		{h.AuthenticatedData, dnsFlagAD},
+		{h.Zero, dnsFlagZ},
+		{h.RecursionAvailable, dnsFlagRA},
+		{h.RecursionDesired, dnsFlagRD},
+		{h.Truncated, dnsFlagTC},
+		{h.Authoritative, dnsFlagAA},
+	} {
+		if f.set {
+			flags |= f.flag
+		}
+	}
+	return flags
+}
+
+// packRdata returns the RDATA of an RR in wire format.
+func packRdata(rr dns.RR) ([]byte, error) {
+	buf := make([]byte, dns.Len(rr)+1)
+	end, err := dns.PackRR(rr, buf, 0, nil, false)
+	if err != nil {
+		return nil, err
+	}
+	// The RDATA follows the owner name and the fixed length header fields
+	start, err := dns.PackDomainName(dns.Fqdn(rr.Header().Name), buf, 0, nil, false)
+	if err != nil {
+		return nil, err
+	}
+	return buf[start+10 : end], nil
+}
+
+func (b *blockBuilder) address(addr []byte) uint64 {
+	return b.ipAddress.add(string(addr), addr)
+}
+
+func (b *blockBuilder) name(name string) uint64 {
+	buf := make([]byte, 256)
+	end, err := dns.PackDomainName(dns.Fqdn(name), buf, 0, nil, false)
+	if err != nil {
+		// Names that were unpacked can be packed again, so this should
+		// only happen for names that were changed
+		end, _ = dns.PackDomainName(".", buf, 0, nil, false)
+	}
+	return b.nameRdata.add(string(buf[:end]), buf[:end])
+}
+
+func (b *blockBuilder) classType(rrtype, class uint16) uint64 {
+	ct := cmap{classTypeType: uint64(rrtype), classTypeClass: uint64(class)}
+	return b.classtype.add(fmt.Sprint(ct), ct)
+}
+
+func (b *blockBuilder) rrIndex(rr dns.RR) uint64 {
+	h := rr.Header()
+	entry := cmap{
+		rrNameIndex:      b.name(h.Name),
+		rrClasstypeIndex: b.classType(h.Rrtype, h.Class),
+		rrTtl:            uint64(h.Ttl),
+	}
+	if rdata, err := packRdata(rr); err == nil {
+		entry[rrRdataIndex] = b.nameRdata.add(string(rdata), rdata)
+	}
+	return b.rr.add(fmt.Sprint(entry), entry)
+}
+
+// sections returns the QueryResponseExtended of a message, which holds the
+// questions after the first and the RRs of each section. The OPT RR of a
+// query is part of the signature instead.
+func (b *blockBuilder) sections(m *dns.Msg, query bool) cmap {
+	ext := cmap{}
+	if len(m.Question) > 1 {
+		list := make([]uint, 0, len(m.Question)-1)
+		for _, q := range m.Question[1:] {
+			entry := cmap{
+				questionNameIndex:      b.name(q.Name),
+				questionClasstypeIndex: b.classType(q.Qtype, q.Qclass),
+			}
+			list = append(list, uint(b.qrr.add(fmt.Sprint(entry), entry)))
+		}
+		ext[extendedQuestionIndex] = b.qlist.add(fmt.Sprint(list), list)
+	}
+
+	for _, section := range []struct {
+		key uint
+		rrs []dns.RR
+	}{
+		{extendedAnswerIndex, m.Answer},
+		{extendedAuthorityIndex, m.Ns},
+		{extendedAdditionalIndex, m.Extra},
+	} {
+		list := make([]uint, 0, len(section.rrs))
+		for _, rr := range section.rrs {
+			if query && rr.Header().Rrtype == dns.TypeOPT {
+				continue
+			}
+			list = append(list, uint(b.rrIndex(rr)))
+		}
+		if len(list) > 0 {
+			ext[section.key] = b.rrlist.add(fmt.Sprint(list), list)
+		}
+	}
+
+	if len(ext) == 0 {
+		return nil
+	}
+	return ext
+}
+
+// flush writes the current block and starts a new one.
+func (cw *Writer) flush() error {
+	b := cw.block
+	if len(b.items) == 0 {
+		return nil
+	}
+	cw.block = newBlockBuilder()
+
+	// Items are not necessarily in order, since queries are written when
+	// their response arrives, so the times are relative to the earliest
+	earliest := b.times[0]
+	for _, t := range b.times {
+		if t.Before(earliest) {
+			earliest = t
+		}
+	}
+	for i := range b.items {
+		offset := uint64(ticks(b.times[i].Sub(earliest)))
+		b.items[i].TimeOffset = &offset
+	}
+	b.stats[statQrDataItems] = uint64(len(b.items))
+
+	blk := block{
+		Preamble: blockPreamble{
+			EarliestTime: []uint64{
+				uint64(earliest.Unix()),
+				uint64(ticks(time.Duration(earliest.Nanosecond()))),
+			},
+		},
+		Statistics: b.stats,
+		Tables: &blockTables{
+			IpAddress: b.ipAddress.items,
+			Classtype: b.classtype.items,
+			NameRdata: b.nameRdata.items,
+			QrSig:     b.qrSig.items,
+			Qlist:     b.qlist.items,
+			Qrr:       b.qrr.items,
+			Rrlist:    b.rrlist.items,
+			Rr:        b.rr.items,
+		},
+		QueryResponses: b.items,
+	}
+
+	data, err := cw.enc.Marshal(&blk)
+	if err != nil {
+		return err
+	}
+	_, err = cw.w.Write(data)
+	return err
+}
+
+// Close writes the last block and ends the file. It doesn't close the
+// underlying writer.
+func (cw *Writer) Close() error {
+	if err := cw.flush(); err != nil {
+		return err
+	}
+	if err := cw.w.WriteByte(0xff); err != nil {
+		return err
+	}
+	return cw.w.Flush()
+}
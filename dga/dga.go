@@ -0,0 +1,149 @@
+// Package dga scores how much a domain label looks algorithmically generated,
+// as by the domain generation algorithms (DGAs) of malware.
+//
+// The score combines the character entropy of the label, its likelihood under
+// a model of English letter bigrams, its longest run of consonants and its
+// share of digits. It looks at nothing but the label, so it is cheap enough
+// to compute for every record, but names made of abbreviations or of words
+// from other languages score higher than English ones.
+package dga
+
+import (
+	"bufio"
+	_ "embed"
+	"math"
+	"strconv"
+	"strings"
+)
+
+//go:embed bigrams.txt
+var bigramCounts string
+
+// minLength is the length below which labels are too short to tell apart,
+// and score lower the shorter they are.
+const minLength = 8
+
+// logProbs holds the natural logarithm of the probability of each letter
+// following another. Index 0 stands for the start of a word as the first
+// letter and for its end as the second, and 1 to 26 for a to z.
+var logProbs = parse()
+
+func index(c byte) int {
+	switch {
+	case c == '^' || c == '$':
+		return 0
+	case 'a' <= c && c <= 'z':
+		return int(c-'a') + 1
+	}
+	return -1
+}
+
+func parse() (probs [27][27]float64) {
+	var counts [27][27]float64
+	scanner := bufio.NewScanner(strings.NewReader(bigramCounts))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") || len(line) < 4 {
+			continue
+		}
+		n, err := strconv.Atoi(line[3:])
+		if err != nil {
+			panic("dga: invalid bigram count " + line)
+		}
+		counts[index(line[0])][index(line[1])] = float64(n)
+	}
+
+	// Bigrams never seen are given a count of one half
+	for i := range counts {
+		total := 0.0
+		for j := range counts[i] {
+			total += counts[i][j] + 0.5
+		}
+		for j := range counts[i] {
+			probs[i][j] = math.Log((counts[i][j] + 0.5) / total)
+		}
+	}
+	return probs
+}
+
+func isConsonant(c byte) bool {
+	return 'a' <= c && c <= 'z' && !strings.ContainsRune("aeiou", rune(c))
+}
+
+// clamp limits a value to between 0 and 1.
+func clamp(x float64) float64 {
+	return math.Max(0, math.Min(1, x))
+}
+
+// Score returns how much a label looks generated, from 0 to 100, and false
+// for labels that aren't scored: empty, too long or punycode labels. Labels
+// are compared case-insensitively.
+func Score(label string) (uint8, bool) {
+	if label == "" || len(label) > 63 || strings.HasPrefix(strings.ToLower(label), "xn--") {
+		return 0, false
+	}
+
+	var counts [256]uint8
+	digits, run, longestRun := 0, 0, 0
+	// The bigram likelihood is summed over the runs of letters, which are
+	// scored as words
+	logProb, transitions := 0.0, 0
+	prev := 0
+	for i := 0; i < len(label); i++ {
+		c := label[i]
+		if 'A' <= c && c <= 'Z' {
+			c += 'a' - 'A'
+		}
+		counts[c]++
+
+		if '0' <= c && c <= '9' {
+			digits++
+		}
+		if isConsonant(c) {
+			run++
+			longestRun = max(longestRun, run)
+		} else {
+			run = 0
+		}
+
+		if j := index(c); j > 0 {
+			logProb += logProbs[prev][j]
+			transitions++
+			prev = j
+		} else if prev > 0 {
+			logProb += logProbs[prev][0]
+			transitions++
+			prev = 0
+		}
+	}
+	if prev > 0 {
+		logProb += logProbs[prev][0]
+		transitions++
+	}
+
+	n := float64(len(label))
+	entropy := 0.0
+	for _, count := range counts {
+		if count > 0 {
+			p := float64(count) / n
+			entropy -= p * math.Log2(p)
+		}
+	}
+	// Relative to the highest entropy a label of its length can have
+	entropyScore := 0.0
+	if len(label) > 1 {
+		entropyScore = clamp((entropy/math.Log2(math.Min(n, 36)) - 0.8) / 0.2)
+	}
+
+	// English names average about -2.9 per bigram, and random letters -4
+	bigramScore := 0.0
+	if transitions > 0 {
+		bigramScore = clamp((-logProb/float64(transitions) - 3) / 0.9)
+	}
+	runScore := clamp(float64(longestRun-2) / 4)
+	digitScore := clamp(float64(digits) / n * 3)
+
+	score := 0.35*bigramScore + 0.2*entropyScore + 0.2*runScore + 0.25*digitScore
+	score *= math.Min(1, n/minLength)
+	return uint8(math.Round(100 * score)), true
+}
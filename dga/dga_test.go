@@ -0,0 +1,73 @@
+package dga
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestScore(t *testing.T) {
+	tests := []struct {
+		label    string
+		min, max uint8
+	}{
+		// Common names
+		{"google", 0, 24},
+		{"facebook", 0, 24},
+		{"wikipedia", 0, 24},
+		{"microsoft", 0, 24},
+		{"stackoverflow", 0, 24},
+		{"cloudflare", 0, 24},
+		{"netflix", 0, 24},
+		{"nytimes", 0, 24},
+		{"example", 0, 24},
+		{"bbc", 0, 24},
+
+		// Generated from letters, as by many DGA families
+		{"kmhwdulbjsxjy", 51, 100},
+		{"hkvyjxlrmwqpf", 51, 100},
+		{"ydqkbhqpzqyqj", 51, 100},
+		{"vwgqpatqo", 51, 100},
+		{"bxpxccvzqv", 51, 100},
+		{"kq3x9zj2vw8p", 51, 100},
+
+		// Generated from hex digits, which have fewer unlikely bigrams
+		{"5f3a9c1e7b2d8f4a", 25, 100},
+		{"a8f3k2j9x7q1", 25, 100},
+	}
+
+	for _, tt := range tests {
+		score, ok := Score(tt.label)
+		if !ok {
+			t.Errorf("Score(%q) not scored", tt.label)
+			continue
+		}
+		if score < tt.min || score > tt.max {
+			t.Errorf("Score(%q) = %d, want %d to %d", tt.label, score, tt.min, tt.max)
+		}
+	}
+}
+
+func TestScoreLabels(t *testing.T) {
+	// Labels are compared case-insensitively
+	want, _ := Score("kmhwdulbjsxjy")
+	if got, _ := Score("KmHwDuLbJsXjY"); got != want {
+		t.Errorf("mixed case scored %d, want %d", got, want)
+	}
+
+	// Short labels score lower
+	short, _ := Score("qxzv")
+	long, _ := Score("qxzvtmklpw")
+	if short >= long {
+		t.Errorf("short label scored %d, long one %d", short, long)
+	}
+
+	// Labels that aren't scored
+	for _, label := range []string{"", "xn--bcher-kva", "XN--55QX5D", strings.Repeat("a", 64)} {
+		if score, ok := Score(label); ok || score != 0 {
+			t.Errorf("Score(%q) = %d, %v, want not scored", label, score, ok)
+		}
+	}
+	if _, ok := Score(strings.Repeat("a", 63)); !ok {
+		t.Error("label of 63 characters not scored")
+	}
+}
@@ -0,0 +1,123 @@
+// Package domainlist matches domain names against lists of domain suffixes
+// and exact names loaded from files.
+//
+// Each line of a list file holds one domain. A plain entry such as
+// "example.com" matches the domain itself and every name below it, while an
+// entry prefixed with "=" (e.g. "=www.example.com") only matches that exact
+// name. Blank lines and everything following a "#" are ignored. Names are
+// compared case-insensitively and without regard for the trailing dot.
+package domainlist
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"sync/atomic"
+
+	"github.com/chazlever/rickybobby/iohandlers"
+)
+
+// A node is a single label in the suffix trie. Labels are stored from the
+// root of the DNS tree downwards, so "www.example.com" is found by walking
+// com -> example -> www.
+type node struct {
+	children map[string]*node
+	suffix   bool
+	exact    bool
+}
+
+func (n *node) insert(labels []string, exact bool) {
+	for i := len(labels) - 1; i >= 0; i-- {
+		child, ok := n.children[labels[i]]
+		if !ok {
+			child = &node{children: make(map[string]*node)}
+			n.children[labels[i]] = child
+		}
+		n = child
+	}
+	if exact {
+		n.exact = true
+	} else {
+		n.suffix = true
+	}
+}
+
+// A List is a set of domains loaded from a file. It is safe to call Match
+// while the list is being reloaded.
+type List struct {
+	path string
+	root atomic.Pointer[node]
+}
+
+// Load reads the list of domains from the given file.
+func Load(path string) (*List, error) {
+	l := &List{path: path}
+	if err := l.Reload(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Reload re-reads the list from its file. The current list is kept if the
+// file can not be read.
+func (l *List) Reload() error {
+	f, err := os.Open(l.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	root := &node{children: make(map[string]*node)}
+	scanner := bufio.NewScanner(f)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		exact := strings.HasPrefix(line, "=")
+		name := normalize(strings.TrimPrefix(line, "="))
+		if name == "" || strings.ContainsAny(name, " \t") {
+			return fmt.Errorf("%s:%d: invalid domain %q", l.path, lineno, line)
+		}
+		root.insert(strings.Split(name, "."), exact)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("%s: %v", l.path, err)
+	}
+
+	l.root.Store(root)
+	return nil
+}
+
+func normalize(name string) string {
+	return strings.ToLower(strings.TrimSuffix(strings.TrimSpace(name), "."))
+}
+
+// Match reports whether the name is in the list.
+func (l *List) Match(name string) bool {
+	n := l.root.Load()
+	labels := strings.Split(normalize(name), ".")
+	for i := len(labels) - 1; i >= 0; i-- {
+		if n.suffix {
+			return true
+		}
+		child, ok := n.children[labels[i]]
+		if !ok {
+			return false
+		}
+		n = child
+	}
+	return n.suffix || n.exact
+}
+
+// MatchRecord reports whether either the question or the resource record
+// name of a record is in the list.
+func (l *List) MatchRecord(d *iohandlers.DnsSchema) bool {
+	return l.Match(d.Qname) || (d.Rname != nil && l.Match(*d.Rname))
+}
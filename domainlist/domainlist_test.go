@@ -0,0 +1,205 @@
+package domainlist
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/chazlever/rickybobby/iohandlers"
+)
+
+// writeList writes the lines of a list file and returns its path.
+func writeList(t *testing.T, path string, lines ...string) string {
+	t.Helper()
+	if path == "" {
+		path = filepath.Join(t.TempDir(), "domains.txt")
+	}
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func loadList(t *testing.T, lines ...string) *List {
+	t.Helper()
+	l, err := Load(writeList(t, "", lines...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+func TestMatch(t *testing.T) {
+	l := loadList(t,
+		"# Suffixes match names below them",
+		"example.com",
+		"  Example.ORG.  ",
+		"",
+		"=www.example.net # only the name itself",
+		"=EXACT.example.info.",
+		"co.uk",
+		"\t",
+		"#evil.example",
+	)
+
+	tests := []struct {
+		name string
+		want bool
+	}{
+		// Suffix entries
+		{"example.com", true},
+		{"example.com.", true},
+		{"www.example.com.", true},
+		{"a.b.c.example.com", true},
+		{"WWW.Example.COM.", true},
+		{"notexample.com", false},
+		{"example.com.au", false},
+		{"com", false},
+		{"www.example.org.", true},
+		{"example.org", true},
+		{"www.co.uk.", true},
+
+		// Exact entries
+		{"www.example.net", true},
+		{"WWW.EXAMPLE.NET.", true},
+		{"mail.www.example.net", false},
+		{"example.net", false},
+		{"exact.example.info", true},
+		{"a.exact.example.info", false},
+
+		// Comments and blank lines
+		{"evil.example", false},
+		{"only", false},
+		{"", false},
+		{".", false},
+	}
+
+	for _, tt := range tests {
+		if got := l.Match(tt.name); got != tt.want {
+			t.Errorf("Match(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMatchRecord(t *testing.T) {
+	l := loadList(t, "example.com")
+	rname := "cdn.example.com."
+	other := "cdn.example.net."
+
+	tests := []struct {
+		d    iohandlers.DnsSchema
+		want bool
+	}{
+		{iohandlers.DnsSchema{Qname: "www.example.com."}, true},
+		{iohandlers.DnsSchema{Qname: "www.example.net."}, false},
+		{iohandlers.DnsSchema{Qname: "www.example.net.", Rname: &rname}, true},
+		{iohandlers.DnsSchema{Qname: "www.example.net.", Rname: &other}, false},
+	}
+
+	for _, tt := range tests {
+		if got := l.MatchRecord(&tt.d); got != tt.want {
+			t.Errorf("MatchRecord(%+v) = %v, want %v", tt.d, got, tt.want)
+		}
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		lines []string
+		err   string
+	}{
+		{"empty exact entry", []string{"example.com", "="}, ":2: invalid domain"},
+		{"space in name", []string{"www example.com"}, ":1: invalid domain"},
+		{"only a dot", []string{"example.com", "", "."}, ":3: invalid domain"},
+	}
+
+	for _, tt := range tests {
+		_, err := Load(writeList(t, "", tt.lines...))
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: error %v, want %q", tt.name, err, tt.err)
+		}
+	}
+	if _, err := Load(filepath.Join(t.TempDir(), "missing.txt")); err == nil {
+		t.Error("loaded a missing file")
+	}
+}
+
+func TestReload(t *testing.T) {
+	path := writeList(t, "", "example.com")
+	l, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeList(t, path, "example.org")
+	if err := l.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if l.Match("example.com") || !l.Match("www.example.org") {
+		t.Error("reloading didn't replace the list")
+	}
+
+	// A list that fails to load leaves the previous one in place
+	writeList(t, path, "example.net", "bad name")
+	if err := l.Reload(); err == nil {
+		t.Error("reloaded an invalid list")
+	}
+	if l.Match("example.net") || !l.Match("www.example.org") {
+		t.Error("a failed reload changed the list")
+	}
+	os.Remove(path)
+	if err := l.Reload(); err == nil {
+		t.Error("reloaded a missing file")
+	}
+	if !l.Match("www.example.org") {
+		t.Error("a missing file changed the list")
+	}
+}
+
+func TestReloadWhileMatching(t *testing.T) {
+	// The new list is swapped in whole, so names in both the old and the
+	// new list match throughout the reload
+	odd := []string{"shared.example", "=exact.example", "a.example"}
+	even := []string{"=exact.example", "c.example", "shared.example"}
+	path := writeList(t, "", odd...)
+	l, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				if !l.Match("www.shared.example") || !l.Match("exact.example") {
+					t.Error("a name in both lists didn't match during a reload")
+					return
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < 200; i++ {
+		lines := even
+		if i%2 == 1 {
+			lines = odd
+		}
+		writeList(t, path, lines...)
+		if err := l.Reload(); err != nil {
+			t.Error(err)
+		}
+	}
+	close(done)
+	wg.Wait()
+}
@@ -0,0 +1,373 @@
+// Package filter implements a small expression language for selecting
+// records based on the fields of a DnsSchema.
+//
+// An expression is made of comparisons joined with and, or and not, where
+// each comparison names a field by its JSON name:
+//
+//	qname endswith example.com and rcode == NXDOMAIN
+//	qtype in (TXT, NULL)
+//	ecs_client in 10.0.0.0/8 or rdata matches "^v=spf1"
+//
+// Supported operators are ==, !=, <, <=, >, >=, in (a set of values or CIDR
+// prefixes), endswith (domain suffix), contains, matches/=~ and !~ (regular
+// expressions). A boolean field on its own tests whether it is true, and
+// nullable fields can be compared against null.
+package filter
+
+import (
+	"fmt"
+	"net/netip"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/chazlever/rickybobby/iohandlers"
+	"github.com/miekg/dns"
+)
+
+// A Filter is a compiled filter expression.
+type Filter struct {
+	expr  string
+	match func(v reflect.Value) bool
+}
+
+type field struct {
+	name     string
+	index    int
+	kind     reflect.Kind
+	nullable bool
+	// enrichment is the enrichment adding the field, if any
+	enrichment string
+}
+
+var fields = make(map[string]field)
+
+func init() {
+	t := reflect.TypeOf(iohandlers.DnsSchema{})
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+
+		kind := f.Type.Kind()
+		nullable := kind == reflect.Ptr
+		if nullable {
+			kind = f.Type.Elem().Kind()
+		}
+		fields[name] = field{name, i, kind, nullable, f.Tag.Get("enrich")}
+	}
+}
+
+// Compile parses a filter expression. The returned error describes the
+// position of the problem for malformed expressions.
+func Compile(expr string) (*Filter, error) {
+	n, err := parse(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	c := compiler{expr}
+	match, err := c.compile(n)
+	if err != nil {
+		return nil, err
+	}
+	return &Filter{expr, match}, nil
+}
+
+// Match reports whether the record satisfies the filter.
+func (f *Filter) Match(d *iohandlers.DnsSchema) bool {
+	return f.match(reflect.ValueOf(d).Elem())
+}
+
+func (f *Filter) String() string {
+	return f.expr
+}
+
+type compiler struct {
+	expr string
+}
+
+func (c compiler) errorf(t token, format string, args ...interface{}) error {
+	return &SyntaxError{c.expr, t.pos, fmt.Sprintf(format, args...)}
+}
+
+func (c compiler) compile(n *node) (func(reflect.Value) bool, error) {
+	switch n.op {
+	case "and", "or":
+		left, err := c.compile(n.left)
+		if err != nil {
+			return nil, err
+		}
+		right, err := c.compile(n.right)
+		if err != nil {
+			return nil, err
+		}
+		if n.op == "and" {
+			return func(v reflect.Value) bool { return left(v) && right(v) }, nil
+		}
+		return func(v reflect.Value) bool { return left(v) || right(v) }, nil
+	case "not":
+		inner, err := c.compile(n.left)
+		if err != nil {
+			return nil, err
+		}
+		return func(v reflect.Value) bool { return !inner(v) }, nil
+	}
+
+	f, ok := fields[strings.ToLower(n.field.text)]
+	if !ok {
+		return nil, c.errorf(n.field, "unknown field %q", n.field.text)
+	}
+	if f.enrichment != "" && !iohandlers.EnrichmentEnabled(f.enrichment) {
+		return nil, c.errorf(n.field, "field %q requires the %s enrichment", f.name, f.enrichment)
+	}
+
+	if n.op == "field" {
+		if f.kind != reflect.Bool {
+			return nil, c.errorf(n.field, "field %q is not a boolean and needs a comparison", f.name)
+		}
+		return func(v reflect.Value) bool {
+			fv, ok := value(v, f)
+			return ok && fv.Bool()
+		}, nil
+	}
+
+	// Negated operators are compiled as their positive counterpart
+	negate := false
+	switch n.op {
+	case "!=":
+		n.op, negate = "==", true
+	case "!~":
+		n.op, negate = "matches", true
+	case "=~":
+		n.op = "matches"
+	}
+
+	var (
+		match func(reflect.Value) bool
+		err   error
+	)
+	if len(n.values) == 1 && n.values[0].kind == tokWord && strings.EqualFold(n.values[0].text, "null") {
+		match, err = c.compileNull(n, f)
+	} else {
+		switch f.kind {
+		case reflect.String:
+			match, err = c.compileString(n, f)
+		case reflect.Bool:
+			match, err = c.compileBool(n, f)
+		default:
+			match, err = c.compileNumber(n, f)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if negate {
+		return func(v reflect.Value) bool { return !match(v) }, nil
+	}
+	return match, nil
+}
+
+// value returns the (dereferenced) value of a field and whether it is set.
+func value(v reflect.Value, f field) (reflect.Value, bool) {
+	fv := v.Field(f.index)
+	if f.nullable {
+		if fv.IsNil() {
+			return fv, false
+		}
+		fv = fv.Elem()
+	}
+	return fv, true
+}
+
+func (c compiler) compileNull(n *node, f field) (func(reflect.Value) bool, error) {
+	if n.op != "==" {
+		return nil, c.errorf(n.values[0], "null can only be compared with == or !=")
+	}
+	if !f.nullable {
+		return nil, c.errorf(n.values[0], "field %q is never null", f.name)
+	}
+	return func(v reflect.Value) bool {
+		_, ok := value(v, f)
+		return !ok
+	}, nil
+}
+
+// isName reports whether a field holds a domain name, which are compared
+// case-insensitively and without regard for the trailing dot.
+func isName(f field) bool {
+	return f.name == "qname" || f.name == "rname"
+}
+
+func normalizeName(s string) string {
+	return strings.ToLower(strings.TrimSuffix(s, "."))
+}
+
+func (c compiler) compileString(n *node, f field) (func(reflect.Value) bool, error) {
+	normalize := func(s string) string { return s }
+	if isName(f) {
+		normalize = normalizeName
+	}
+
+	switch n.op {
+	case "==":
+		want := normalize(n.values[0].text)
+		return func(v reflect.Value) bool {
+			fv, ok := value(v, f)
+			return ok && normalize(fv.String()) == want
+		}, nil
+	case "contains":
+		want := n.values[0].text
+		return func(v reflect.Value) bool {
+			fv, ok := value(v, f)
+			return ok && strings.Contains(fv.String(), want)
+		}, nil
+	case "endswith":
+		suffix := normalizeName(n.values[0].text)
+		return func(v reflect.Value) bool {
+			fv, ok := value(v, f)
+			if !ok {
+				return false
+			}
+			name := normalizeName(fv.String())
+			return suffix == "" || name == suffix || strings.HasSuffix(name, "."+suffix)
+		}, nil
+	case "matches":
+		re, err := regexp.Compile(n.values[0].text)
+		if err != nil {
+			return nil, c.errorf(n.values[0], "invalid regular expression: %v", err)
+		}
+		return func(v reflect.Value) bool {
+			fv, ok := value(v, f)
+			return ok && re.MatchString(fv.String())
+		}, nil
+	case "in":
+		set := make(map[string]bool)
+		var prefixes []netip.Prefix
+		for _, t := range n.values {
+			if strings.Contains(t.text, "/") {
+				prefix, err := netip.ParsePrefix(t.text)
+				if err != nil {
+					return nil, c.errorf(t, "invalid CIDR prefix %q", t.text)
+				}
+				prefixes = append(prefixes, prefix.Masked())
+			} else {
+				set[normalize(t.text)] = true
+			}
+		}
+		return func(v reflect.Value) bool {
+			fv, ok := value(v, f)
+			if !ok {
+				return false
+			}
+			s := fv.String()
+			if set[normalize(s)] {
+				return true
+			}
+			if len(prefixes) > 0 {
+				if addr, err := netip.ParseAddr(s); err == nil {
+					addr = addr.Unmap()
+					for _, p := range prefixes {
+						if p.Contains(addr) {
+							return true
+						}
+					}
+				}
+			}
+			return false
+		}, nil
+	}
+	return nil, c.errorf(n.field, "operator %q is not supported for string field %q", n.op, f.name)
+}
+
+func (c compiler) compileBool(n *node, f field) (func(reflect.Value) bool, error) {
+	if n.op != "==" {
+		return nil, c.errorf(n.field, "operator %q is not supported for boolean field %q", n.op, f.name)
+	}
+	want, err := strconv.ParseBool(strings.ToLower(n.values[0].text))
+	if err != nil {
+		return nil, c.errorf(n.values[0], "invalid boolean %q", n.values[0].text)
+	}
+	return func(v reflect.Value) bool {
+		fv, ok := value(v, f)
+		return ok && fv.Bool() == want
+	}, nil
+}
+
+// number parses a numeric literal or, for fields holding an rcode or RR
+// type, its mnemonic (e.g. NXDOMAIN or AAAA).
+func (c compiler) number(t token, f field) (int64, error) {
+	if i, err := strconv.ParseInt(t.text, 10, 64); err == nil {
+		return i, nil
+	}
+
+	name := strings.ToUpper(t.text)
+	switch f.name {
+	case "rcode":
+		if rcode, ok := dns.StringToRcode[name]; ok {
+			return int64(rcode), nil
+		}
+	case "qtype", "rtype":
+		if rrtype, ok := dns.StringToType[name]; ok {
+			return int64(rrtype), nil
+		}
+	}
+	return 0, c.errorf(t, "invalid value %q for field %q", t.text, f.name)
+}
+
+func (c compiler) compileNumber(n *node, f field) (func(reflect.Value) bool, error) {
+	get := func(v reflect.Value) (int64, bool) {
+		fv, ok := value(v, f)
+		if !ok {
+			return 0, false
+		}
+		if fv.CanInt() {
+			return fv.Int(), true
+		}
+		return int64(fv.Uint()), true
+	}
+
+	if n.op == "in" {
+		set := make(map[int64]bool)
+		for _, t := range n.values {
+			i, err := c.number(t, f)
+			if err != nil {
+				return nil, err
+			}
+			set[i] = true
+		}
+		return func(v reflect.Value) bool {
+			i, ok := get(v)
+			return ok && set[i]
+		}, nil
+	}
+
+	want, err := c.number(n.values[0], f)
+	if err != nil {
+		return nil, err
+	}
+
+	var cmp func(a, b int64) bool
+	switch n.op {
+	case "==":
+		cmp = func(a, b int64) bool { return a == b }
+	case "<":
+		cmp = func(a, b int64) bool { return a < b }
+	case "<=":
+		cmp = func(a, b int64) bool { return a <= b }
+	case ">":
+		cmp = func(a, b int64) bool { return a > b }
+	case ">=":
+		cmp = func(a, b int64) bool { return a >= b }
+	default:
+		return nil, c.errorf(n.field, "operator %q is not supported for numeric field %q", n.op, f.name)
+	}
+	return func(v reflect.Value) bool {
+		i, ok := get(v)
+		return ok && cmp(i, want)
+	}, nil
+}
@@ -0,0 +1,165 @@
+package filter
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/chazlever/rickybobby/iohandlers"
+)
+
+func testRecord() *iohandlers.DnsSchema {
+	ttl := uint32(300)
+	rname := "WWW.Example.COM."
+	rtype := uint16(16)
+	rdata := `"v=spf1 include:_spf.example.com ~all"`
+	ecs := "10.1.2.0"
+	return &iohandlers.DnsSchema{
+		SourceAddress:      "192.168.1.10",
+		DestinationAddress: "2001:db8::53",
+		Rcode:              3,
+		Response:           true,
+		Qname:              "www.example.com.",
+		Qtype:              16,
+		Ttl:                &ttl,
+		Rname:              &rname,
+		Rtype:              &rtype,
+		Rdata:              &rdata,
+		EcsClient:          &ecs,
+		Source:             "bücher.de",
+	}
+}
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		// Precedence: not binds tighter than and, which binds tighter than or
+		{"response or rcode == 0 and qtype == 1", true},
+		{"(response or rcode == 0) and qtype == 1", false},
+		{"not response and qtype == TXT", false},
+		{"not (response and qtype == A)", true},
+		{"! response || truncated", false},
+		{"response && !truncated", true},
+		{"not not response", true},
+
+		// Comparisons
+		{"rcode == NXDOMAIN", true},
+		{"rcode != 3", false},
+		{"qtype == txt", true},
+		{"ttl > 299 and ttl <= 300", true},
+		{"ttl < 300", false},
+		{"ttl >= 301", false},
+		{"response == false", false},
+		{"qname == WWW.EXAMPLE.COM", true},
+		{"qname == \"www.example.com.\"", true},
+		{"source == bücher.de", true},
+		{"source == \"bücher.de\"", true},
+
+		// in
+		{"qtype in (A, AAAA, TXT)", true},
+		{"qtype in (A, AAAA)", false},
+		{"rcode in (NOERROR, SERVFAIL)", false},
+		{"rname in (www.example.com, mail.example.com)", true},
+		{"source in (\"bücher.de\")", true},
+		{"qtype in 16", true},
+
+		// CIDR matching
+		{"src_address in 192.168.0.0/16", true},
+		{"src_address in (10.0.0.0/8, 172.16.0.0/12)", false},
+		{"src_address in (10.0.0.0/8, 192.168.1.10)", true},
+		{"dst_address in 2001:db8::/32", true},
+		{"dst_address in 2001:db9::/32", false},
+		{"ecs_client in 10.1.2.0/24", true},
+		{"src_address in ::ffff:192.168.0.0/112", false},
+
+		// Names
+		{"qname endswith example.com", true},
+		{"qname endswith EXAMPLE.COM.", true},
+		{"qname ends with ample.com", false},
+		{"rname endswith www.example.com", true},
+
+		// contains and regular expressions
+		{"rdata contains spf1", true},
+		{"rdata contains SPF1", false},
+		{"rdata matches \"^.v=spf1\"", true},
+		{"rdata =~ \"include:_spf\\\\.\"", true},
+		{"rdata !~ spf", false},
+		{"qname matches \"^mail\\\\.\"", false},
+
+		// null
+		{"ecs_client != null", true},
+		{"ecs_scope == null", true},
+		{"ecs_source == 24", false},
+	}
+
+	d := testRecord()
+	for _, tt := range tests {
+		f, err := Compile(tt.expr)
+		if err != nil {
+			t.Errorf("Compile(%q): %v", tt.expr, err)
+			continue
+		}
+		if got := f.Match(d); got != tt.want {
+			t.Errorf("%q matched %v, want %v", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	tests := []struct {
+		expr string
+		// The message and position the error must hold
+		msg string
+		pos int
+	}{
+		{"", "empty filter expression", 0},
+		{"qname ==", "expected value but found end of expression", 8},
+		{"(response", "expected \")\" but found end of expression", 9},
+		{"response)", "unexpected \")\"", 8},
+		{"qname = example.com", "unknown operator \"=\"", 6},
+		{"qname == example.com and", "unexpected end of expression", 24},
+		{"qname == \"example.com", "unterminated string", 9},
+		{"bogus == 1", "unknown field \"bogus\"", 0},
+		{"qname", "field \"qname\" is not a boolean", 0},
+		{"qtype == BOGUS", "invalid value \"BOGUS\"", 9},
+		{"rcode contains 1", "operator \"contains\" is not supported", 0},
+		{"qname < 3", "operator \"<\" is not supported", 0},
+		{"qname matches \"[\"", "invalid regular expression", 14},
+		{"src_address in 300.0.0.0/8", "invalid CIDR prefix", 15},
+		{"qtype in (A, AAAA", "expected \",\" or \")\"", 17},
+		{"qname ends example.com", "expected \"with\" after \"ends\"", 11},
+		{"rcode == null", "field \"rcode\" is never null", 9},
+		{"ttl > null", "null can only be compared with == or !=", 6},
+		{"response == maybe", "invalid boolean \"maybe\"", 12},
+		{"qname == a €", "unexpected character '€'", 11},
+		{"qname_etld == com", "field \"qname_etld\" requires the psl enrichment", 0},
+	}
+
+	for _, tt := range tests {
+		_, err := Compile(tt.expr)
+		if err == nil {
+			t.Errorf("Compile(%q) succeeded, want error %q", tt.expr, tt.msg)
+			continue
+		}
+		se, ok := err.(*SyntaxError)
+		if !ok {
+			t.Errorf("Compile(%q) returned %T, want *SyntaxError", tt.expr, err)
+			continue
+		}
+		if !strings.Contains(se.Msg, tt.msg) || se.Pos != tt.pos {
+			t.Errorf("Compile(%q) = %q at %d, want %q at %d", tt.expr, se.Msg, se.Pos, tt.msg, tt.pos)
+		}
+	}
+}
+
+func TestSyntaxErrorPosition(t *testing.T) {
+	// Positions count characters rather than bytes
+	_, err := Compile("source == bücher € ")
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if !strings.Contains(err.Error(), "at position 18\n") {
+		t.Errorf("unexpected error %q", err)
+	}
+}
@@ -0,0 +1,298 @@
+package filter
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	tokEOF = iota
+	tokWord
+	tokString
+	tokOp
+	tokLParen
+	tokRParen
+	tokComma
+)
+
+type token struct {
+	kind int
+	text string
+	pos  int
+}
+
+func (t token) String() string {
+	if t.kind == tokEOF {
+		return "end of expression"
+	}
+	return strconv.Quote(t.text)
+}
+
+// A SyntaxError describes a malformed filter expression.
+type SyntaxError struct {
+	Expr string
+	Pos  int
+	Msg  string
+}
+
+func (e *SyntaxError) Error() string {
+	// Pos is a byte offset, while the position shown counts characters
+	col := utf8.RuneCountInString(e.Expr[:e.Pos])
+	return fmt.Sprintf("%s at position %d\n\t%s\n\t%s^",
+		e.Msg, col+1, e.Expr, strings.Repeat(" ", col))
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("._-:/*", r)
+}
+
+func lex(expr string) ([]token, error) {
+	var tokens []token
+
+	for i := 0; i < len(expr); {
+		r, size := utf8.DecodeRuneInString(expr[i:])
+		switch {
+		case unicode.IsSpace(r):
+			i += size
+		case r == '(':
+			tokens = append(tokens, token{tokLParen, "(", i})
+			i++
+		case r == ')':
+			tokens = append(tokens, token{tokRParen, ")", i})
+			i++
+		case r == ',':
+			tokens = append(tokens, token{tokComma, ",", i})
+			i++
+		case r == '"':
+			j := i + 1
+			for ; j < len(expr) && expr[j] != '"'; j++ {
+				if expr[j] == '\\' {
+					j++
+				}
+			}
+			if j >= len(expr) {
+				return nil, &SyntaxError{expr, i, "unterminated string"}
+			}
+			s, err := strconv.Unquote(expr[i : j+1])
+			if err != nil {
+				return nil, &SyntaxError{expr, i, "invalid string"}
+			}
+			tokens = append(tokens, token{tokString, s, i})
+			i = j + 1
+		case strings.ContainsRune("=!<>&|~", r):
+			op := expr[i : i+1]
+			if i+1 < len(expr) {
+				switch two := expr[i : i+2]; two {
+				case "==", "!=", "<=", ">=", "=~", "!~", "&&", "||":
+					op = two
+				}
+			}
+			switch op {
+			case "=", "&", "|", "~":
+				return nil, &SyntaxError{expr, i, fmt.Sprintf("unknown operator %q", op)}
+			}
+			tokens = append(tokens, token{tokOp, op, i})
+			i += len(op)
+		case isWordRune(r):
+			j := i
+			for j < len(expr) {
+				r, size := utf8.DecodeRuneInString(expr[j:])
+				if !isWordRune(r) {
+					break
+				}
+				j += size
+			}
+			tokens = append(tokens, token{tokWord, expr[i:j], i})
+			i = j
+		default:
+			return nil, &SyntaxError{expr, i, fmt.Sprintf("unexpected character %q", r)}
+		}
+	}
+
+	return append(tokens, token{tokEOF, "", len(expr)}), nil
+}
+
+// A node is a single element of a parsed filter expression.
+type node struct {
+	op     string // and, or, not, field, or a comparison operator
+	left   *node
+	right  *node
+	field  token
+	values []token
+}
+
+type parser struct {
+	expr   string
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) errorf(t token, format string, args ...interface{}) error {
+	return &SyntaxError{p.expr, t.pos, fmt.Sprintf(format, args...)}
+}
+
+// keyword reports whether the next token is the given (case-insensitive) word
+// or one of the symbolic aliases and consumes it if so.
+func (p *parser) keyword(word string, aliases ...string) bool {
+	t := p.peek()
+	if t.kind == tokWord && strings.EqualFold(t.text, word) {
+		p.next()
+		return true
+	}
+	if t.kind == tokOp {
+		for _, a := range aliases {
+			if t.text == a {
+				p.next()
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func parse(expr string) (*node, error) {
+	tokens, err := lex(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{expr: expr, tokens: tokens}
+
+	if p.peek().kind == tokEOF {
+		return nil, p.errorf(p.peek(), "empty filter expression")
+	}
+	n, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, p.errorf(t, "unexpected %v", t)
+	}
+	return n, nil
+}
+
+func (p *parser) parseOr() (*node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("or", "||") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &node{op: "or", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (*node, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("and", "&&") {
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &node{op: "and", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseNot() (*node, error) {
+	if p.keyword("not", "!") {
+		n, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &node{op: "not", left: n}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (*node, error) {
+	t := p.next()
+	switch t.kind {
+	case tokLParen:
+		n, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if c := p.next(); c.kind != tokRParen {
+			return nil, p.errorf(c, "expected \")\" but found %v", c)
+		}
+		return n, nil
+	case tokWord:
+		return p.parseComparison(t)
+	case tokEOF:
+		return nil, p.errorf(t, "unexpected end of expression")
+	default:
+		return nil, p.errorf(t, "expected field name but found %v", t)
+	}
+}
+
+func (p *parser) parseComparison(field token) (*node, error) {
+	t := p.peek()
+	var op string
+	switch {
+	case t.kind == tokOp && t.text != "&&" && t.text != "||" && t.text != "!":
+		op = p.next().text
+	case t.kind == tokWord:
+		switch strings.ToLower(t.text) {
+		case "in", "matches", "contains", "endswith":
+			op = strings.ToLower(p.next().text)
+		case "ends":
+			p.next()
+			if !p.keyword("with") {
+				return nil, p.errorf(p.peek(), "expected \"with\" after \"ends\"")
+			}
+			op = "endswith"
+		}
+	}
+
+	// A field on its own is a boolean test
+	if op == "" {
+		return &node{op: "field", field: field}, nil
+	}
+
+	n := &node{op: op, field: field}
+	if op == "in" && p.peek().kind == tokLParen {
+		p.next()
+		for {
+			v := p.next()
+			if v.kind != tokWord && v.kind != tokString {
+				return nil, p.errorf(v, "expected value but found %v", v)
+			}
+			n.values = append(n.values, v)
+			if c := p.next(); c.kind == tokRParen {
+				break
+			} else if c.kind != tokComma {
+				return nil, p.errorf(c, "expected \",\" or \")\" but found %v", c)
+			}
+		}
+		return n, nil
+	}
+
+	v := p.next()
+	if v.kind != tokWord && v.kind != tokString {
+		return nil, p.errorf(v, "expected value but found %v", v)
+	}
+	n.values = []token{v}
+	return n, nil
+}
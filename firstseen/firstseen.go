@@ -0,0 +1,236 @@
+// Package firstseen keeps a persistent record of the names seen before, to
+// tell which names are seen for the first time.
+//
+// The time each name was last seen is kept in a bbolt database, so the record
+// survives restarts. Names not seen for longer than the expiry horizon are
+// forgotten, and are new again when they are next seen. Updates are written
+// in batches, and at least every 10 seconds, so those made since the last
+// flush are lost if the process is killed without closing the store.
+package firstseen
+
+import (
+	"encoding/binary"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	// flushInterval and flushSize bound how long and how many updates are
+	// held before they are written.
+	flushInterval = 10 * time.Second
+	flushSize     = 10000
+	// sweepInterval is how often names past the expiry horizon are removed.
+	sweepInterval = time.Hour
+	// maxRefresh is the longest the last-seen time of a name may lag behind
+	// before it is updated.
+	maxRefresh = time.Hour
+)
+
+var (
+	bucket = []byte("names")
+
+	// cacheSize is the number of names whose last-seen time is kept in
+	// memory. The cache is emptied once it is full.
+	cacheSize = 1 << 20
+)
+
+// A Store is a record of the names seen before. It is safe for concurrent
+// use, as updates are also flushed in the background.
+type Store struct {
+	mu sync.Mutex
+	db *bolt.DB
+	// expiry is how long names are remembered after they were last seen, or
+	// forever if it is zero.
+	expiry time.Duration
+	// refresh is how far the last-seen time of a name may lag behind
+	// before it is updated
+	refresh time.Duration
+
+	cache   map[string]int64
+	pending map[string]int64
+	flushed time.Time
+	swept   time.Time
+	// done is closed when the store is closed, to stop the background
+	// flushes.
+	done      chan struct{}
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// Open opens the store in the given file, creating it if needed.
+func Open(path string, expiry time.Duration) (*Store, error) {
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	refresh := maxRefresh
+	if expiry > 0 && expiry/10 < refresh {
+		refresh = expiry / 10
+	}
+	s := &Store{
+		db:      db,
+		expiry:  expiry,
+		refresh: refresh,
+		cache:   make(map[string]int64),
+		pending: make(map[string]int64),
+		done:    make(chan struct{}),
+	}
+	go s.flushPeriodically()
+	return s, nil
+}
+
+// flushPeriodically writes the updates held in memory every flushInterval
+// until the store is closed, so they don't wait for the next name to be
+// seen. Updates that fail to be written stay pending, and the error is
+// reported by the next flush made by Seen.
+func (s *Store) flushPeriodically() {
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.Flush()
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// lastSeen returns when a name was last seen, in nanoseconds since the
+// epoch, and whether it was seen before.
+func (s *Store) lastSeen(name string) (int64, bool) {
+	if last, ok := s.cache[name]; ok {
+		return last, true
+	}
+	// Updates not yet written may have been dropped from the cache
+	if last, ok := s.pending[name]; ok {
+		return last, true
+	}
+
+	var last int64
+	found := false
+	s.db.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket(bucket).Get([]byte(name)); len(v) == 8 {
+			last, found = int64(binary.BigEndian.Uint64(v)), true
+		}
+		return nil
+	})
+	if found {
+		s.remember(name, last)
+	}
+	return last, found
+}
+
+func (s *Store) remember(name string, last int64) {
+	if len(s.cache) >= cacheSize {
+		s.cache = make(map[string]int64)
+	}
+	s.cache[name] = last
+}
+
+// Seen records that a name was seen at the given time, and reports whether
+// it was seen for the first time, or for the first time within the expiry
+// horizon.
+func (s *Store) Seen(name string, t time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := t.UnixNano()
+	last, ok := s.lastSeen(name)
+	first := !ok || (s.expiry > 0 && now-last > int64(s.expiry))
+
+	if first || now-last >= int64(s.refresh) {
+		s.remember(name, now)
+		s.pending[name] = now
+	}
+
+	if len(s.pending) >= flushSize || t.Sub(s.flushed) >= flushInterval {
+		sweep := s.expiry > 0 && t.Sub(s.swept) >= sweepInterval
+		if err := s.flush(t, sweep); err != nil {
+			return first, err
+		}
+	}
+	return first, nil
+}
+
+// Flush writes the updates held in memory.
+func (s *Store) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.flush(s.flushed, false)
+}
+
+// flush writes the updates held in memory at time t, which is that of the
+// names last seen. Names past the expiry horizon at t are removed if sweep is
+// set.
+func (s *Store) flush(t time.Time, sweep bool) error {
+	s.flushed = t
+	if len(s.pending) == 0 && !sweep {
+		return nil
+	}
+
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucket)
+		var v [8]byte
+		for name, last := range s.pending {
+			binary.BigEndian.PutUint64(v[:], uint64(last))
+			if err := b.Put([]byte(name), v[:]); err != nil {
+				return err
+			}
+		}
+		if !sweep {
+			return nil
+		}
+
+		// Forget the names past the expiry horizon
+		horizon := t.Add(-s.expiry).UnixNano()
+		c := b.Cursor()
+		for k, v := c.First(); k != nil; {
+			if len(v) == 8 && int64(binary.BigEndian.Uint64(v)) < horizon {
+				// The key is copied as it may not outlive the deletion
+				key := append([]byte(nil), k...)
+				if err := c.Delete(); err != nil {
+					return err
+				}
+				k, v = c.Seek(key)
+				continue
+			}
+			k, v = c.Next()
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	s.pending = make(map[string]int64)
+	if sweep {
+		s.swept = t
+		s.cache = make(map[string]int64)
+	}
+	return nil
+}
+
+// Close writes the updates held in memory and closes the store. Later calls
+// return the error of the first.
+func (s *Store) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.done)
+		s.closeErr = s.Flush()
+		if err := s.db.Close(); s.closeErr == nil {
+			s.closeErr = err
+		}
+	})
+	return s.closeErr
+}
@@ -0,0 +1,144 @@
+package firstseen
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var start = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+func openTest(t *testing.T, path string, expiry time.Duration) *Store {
+	t.Helper()
+	if path == "" {
+		path = filepath.Join(t.TempDir(), "seen.db")
+	}
+	s, err := Open(path, expiry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// seen calls Seen and fails the test if the result isn't the wanted one.
+func seen(t *testing.T, s *Store, name string, at time.Time, want bool) {
+	t.Helper()
+	first, err := s.Seen(name, at)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != want {
+		t.Errorf("Seen(%q, %v) = %v, want %v", name, at.Sub(start), first, want)
+	}
+}
+
+// stored returns the names written to the database.
+func stored(t *testing.T, s *Store) map[string]bool {
+	t.Helper()
+	names := make(map[string]bool)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).ForEach(func(k, v []byte) error {
+			names[string(k)] = true
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return names
+}
+
+func TestSeen(t *testing.T) {
+	s := openTest(t, "", 0)
+	seen(t, s, "example.com", start, true)
+	seen(t, s, "example.com", start.Add(time.Second), false)
+	seen(t, s, "example.org", start.Add(time.Second), true)
+	// Without an expiry names are remembered forever
+	seen(t, s, "example.com", start.Add(24*365*time.Hour), false)
+}
+
+func TestExpiry(t *testing.T) {
+	s := openTest(t, "", time.Hour)
+	seen(t, s, "example.com", start, true)
+	seen(t, s, "example.com", start.Add(59*time.Minute), false)
+	// Seeing the name again moved its expiry
+	seen(t, s, "example.com", start.Add(119*time.Minute), false)
+	seen(t, s, "example.com", start.Add(3*time.Hour), true)
+}
+
+func TestRefresh(t *testing.T) {
+	// With an expiry of an hour, the last-seen time is refreshed once it
+	// lags behind by 6 minutes
+	s := openTest(t, "", time.Hour)
+	seen(t, s, "example.com", start, true)
+	seen(t, s, "example.com", start.Add(5*time.Minute), false)
+	if last := s.cache["example.com"]; last != start.UnixNano() {
+		t.Errorf("last seen %v after 5 minutes, want %v", time.Unix(0, last).Sub(start), 0)
+	}
+	seen(t, s, "example.com", start.Add(6*time.Minute), false)
+	if last := s.cache["example.com"]; last != start.Add(6*time.Minute).UnixNano() {
+		t.Errorf("last seen %v after 6 minutes, want 6m0s", time.Unix(0, last).Sub(start))
+	}
+
+	// As the time seen 5 minutes in wasn't recorded, the name expires an
+	// hour after it was seen 6 minutes in
+	seen(t, s, "example.com", start.Add(66*time.Minute+time.Second), true)
+}
+
+func TestCacheResetWithPending(t *testing.T) {
+	size := cacheSize
+	cacheSize = 4
+	defer func() { cacheSize = size }()
+
+	s := openTest(t, "", 0)
+	names := []string{"a.example", "b.example", "c.example", "d.example", "e.example", "f.example"}
+	// The first name is written at once, and the others stay pending while
+	// the cache is emptied
+	for _, name := range names {
+		seen(t, s, name, start, true)
+	}
+	if len(s.pending) != len(names)-1 {
+		t.Fatalf("%d updates pending, want %d", len(s.pending), len(names)-1)
+	}
+	for _, name := range names {
+		seen(t, s, name, start.Add(time.Second), false)
+	}
+}
+
+func TestSweep(t *testing.T) {
+	s := openTest(t, "", time.Hour)
+	seen(t, s, "old.example", start, true)
+	seen(t, s, "new.example", start.Add(90*time.Minute), true)
+	// The next flush after the sweep interval removes the expired name
+	seen(t, s, "new.example", start.Add(2*time.Hour), false)
+
+	names := stored(t, s)
+	if names["old.example"] || !names["new.example"] {
+		t.Errorf("stored %v after the sweep, want new.example only", names)
+	}
+}
+
+func TestPersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "seen.db")
+	s, err := Open(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen(t, s, "example.com", start, true)
+	seen(t, s, "example.org", start.Add(time.Second), true)
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// Closing again is harmless
+	if err := s.Close(); err != nil {
+		t.Errorf("second Close: %v", err)
+	}
+
+	s = openTest(t, path, 0)
+	seen(t, s, "example.com", start.Add(time.Minute), false)
+	seen(t, s, "example.org", start.Add(time.Minute), false)
+	seen(t, s, "example.net", start.Add(time.Minute), true)
+}
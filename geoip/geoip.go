@@ -0,0 +1,112 @@
+// Package geoip looks up the country and autonomous system of IP addresses
+// in MaxMind DB (.mmdb) files, such as the GeoLite2 Country, City and ASN
+// databases or compatible ones.
+//
+// Several databases can be used together, each adding the fields it holds.
+// Lookups are made locally and cached, and the files are read into memory so
+// they can be replaced while in use.
+package geoip
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"sync/atomic"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// cacheSize is the number of addresses whose results are kept. The cache is
+// emptied once it is full.
+const cacheSize = 1 << 16
+
+// An Info holds what the databases know about an address. Fields are empty
+// when no database holds them.
+type Info struct {
+	Country      string
+	Asn          uint32
+	Organization string
+}
+
+// record holds the fields read from a database, which are named alike in the
+// country, city and ASN databases.
+type record struct {
+	Country struct {
+		IsoCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	Asn          uint32 `maxminddb:"autonomous_system_number"`
+	Organization string `maxminddb:"autonomous_system_organization"`
+}
+
+type state struct {
+	readers []*maxminddb.Reader
+	cache   map[string]Info
+}
+
+// Databases are a set of databases loaded from files. It is safe to reload
+// them while looking up addresses, but lookups must not be made concurrently.
+type Databases struct {
+	paths []string
+	state atomic.Pointer[state]
+}
+
+// Open reads the databases from the given files.
+func Open(paths ...string) (*Databases, error) {
+	dbs := &Databases{paths: paths}
+	if err := dbs.Reload(); err != nil {
+		return nil, err
+	}
+	return dbs, nil
+}
+
+// Reload re-reads the databases from their files and empties the cache. The
+// current databases are kept if any of the files can not be read.
+func (dbs *Databases) Reload() error {
+	s := &state{cache: make(map[string]Info)}
+	for _, path := range dbs.paths {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		reader, err := maxminddb.FromBytes(b)
+		if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		s.readers = append(s.readers, reader)
+	}
+
+	dbs.state.Store(s)
+	return nil
+}
+
+// Lookup returns what the databases know about an address. Addresses that
+// can't be parsed or aren't in any database give an empty Info.
+func (dbs *Databases) Lookup(address string) Info {
+	s := dbs.state.Load()
+	if info, ok := s.cache[address]; ok {
+		return info
+	}
+
+	var info Info
+	if ip := net.ParseIP(address); ip != nil {
+		for _, reader := range s.readers {
+			var r record
+			if err := reader.Lookup(ip, &r); err != nil {
+				continue
+			}
+			if r.Country.IsoCode != "" {
+				info.Country = r.Country.IsoCode
+			}
+			if r.Asn != 0 {
+				info.Asn = r.Asn
+				info.Organization = r.Organization
+			}
+		}
+	}
+
+	if len(s.cache) >= cacheSize {
+		s.cache = make(map[string]Info)
+	}
+	s.cache[address] = info
+	return info
+}
@@ -0,0 +1,140 @@
+package geoip
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/maxmind/mmdbwriter"
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+)
+
+// writeDatabase writes a database holding the given records by network and
+// returns its path.
+func writeDatabase(t *testing.T, name string, records map[string]mmdbtype.Map) string {
+	t.Helper()
+	tree, err := mmdbwriter.New(mmdbwriter.Options{
+		DatabaseType:            name,
+		IncludeReservedNetworks: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for cidr, r := range records {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := tree.Insert(network, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), name+".mmdb")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := tree.WriteTo(f); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func country(code string) mmdbtype.Map {
+	return mmdbtype.Map{
+		"country": mmdbtype.Map{"iso_code": mmdbtype.String(code)},
+	}
+}
+
+func asn(number uint32, organization string) mmdbtype.Map {
+	return mmdbtype.Map{
+		"autonomous_system_number":       mmdbtype.Uint32(number),
+		"autonomous_system_organization": mmdbtype.String(organization),
+	}
+}
+
+func TestLookup(t *testing.T) {
+	countries := writeDatabase(t, "Test-Country", map[string]mmdbtype.Map{
+		"192.0.2.0/24":  country("US"),
+		"2001:db8::/32": country("DE"),
+	})
+	asns := writeDatabase(t, "Test-ASN", map[string]mmdbtype.Map{
+		"192.0.2.0/25":    asn(64496, "Example One"),
+		"198.51.100.0/24": asn(64497, "Example Two"),
+	})
+	dbs, err := Open(countries, asns)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		address string
+		want    Info
+	}{
+		// Fields are taken from every database holding the address
+		{"192.0.2.1", Info{"US", 64496, "Example One"}},
+		{"192.0.2.200", Info{Country: "US"}},
+		{"198.51.100.7", Info{Asn: 64497, Organization: "Example Two"}},
+		{"2001:db8::1", Info{Country: "DE"}},
+		{"::ffff:192.0.2.1", Info{"US", 64496, "Example One"}},
+		{"203.0.113.1", Info{}},
+		{"not an address", Info{}},
+		{"", Info{}},
+	}
+	for _, tt := range tests {
+		// The second lookup is answered from the cache
+		for i := 0; i < 2; i++ {
+			if got := dbs.Lookup(tt.address); got != tt.want {
+				t.Errorf("Lookup(%q) = %+v, want %+v", tt.address, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestReload(t *testing.T) {
+	path := writeDatabase(t, "Test-Country", map[string]mmdbtype.Map{
+		"192.0.2.0/24": country("US"),
+	})
+	dbs, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := dbs.Lookup("192.0.2.1").Country; got != "US" {
+		t.Fatalf("got country %q, want US", got)
+	}
+
+	// Reloading empties the cache
+	replacement := writeDatabase(t, "Test-Country", map[string]mmdbtype.Map{
+		"192.0.2.0/24": country("FR"),
+	})
+	if err := os.Rename(replacement, path); err != nil {
+		t.Fatal(err)
+	}
+	if err := dbs.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if got := dbs.Lookup("192.0.2.1").Country; got != "FR" {
+		t.Errorf("after reloading: got country %q, want FR", got)
+	}
+
+	// Files that can't be read keep the current databases
+	if err := os.WriteFile(path, []byte("not a database"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := dbs.Reload(); err == nil {
+		t.Error("reloading an invalid database succeeded")
+	}
+	if got := dbs.Lookup("192.0.2.1").Country; got != "FR" {
+		t.Errorf("after a failed reload: got country %q, want FR", got)
+	}
+
+	if _, err := Open(path); err == nil {
+		t.Error("opening an invalid database succeeded")
+	}
+	if _, err := Open(filepath.Join(t.TempDir(), "missing.mmdb")); err == nil {
+		t.Error("opening a missing database succeeded")
+	}
+}
@@ -0,0 +1,110 @@
+package iohandlers
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// An Aggregator summarizes records instead of writing each of them, emitting
+// its own records as it goes.
+type Aggregator interface {
+	// Add accounts for a single record. The record must not be retained.
+	Add(d *DnsSchema)
+	// Close emits whatever has not been emitted yet.
+	Close()
+}
+
+// A ticker is an Aggregator emitting its records at the end of windows of
+// time. Windows end when a later record arrives, or when Tick is called.
+type ticker interface {
+	// Tick emits the records of the windows that have ended at t.
+	Tick(t time.Time)
+}
+
+var (
+	// Aggregators holds the available aggregation modes. Each is created
+	// with the name of the format its records are to be written in.
+	Aggregators = make(map[string]func(format string) (Aggregator, error))
+	// aggregateRecordTypes holds the types of the records of aggregations
+	// that write their records through the formats.
+	aggregateRecordTypes = make(map[string]reflect.Type)
+
+	// MessageFormats are the formats describing whole DNS messages or
+	// security events, which can not hold the records of aggregations.
+	MessageFormats = []string{"zeek", "zeek-json", "cdns", "cef", "leef"}
+
+	aggregateName   = ""
+	aggregateFormat = ""
+	aggregator      Aggregator
+)
+
+// SetAggregation selects the aggregation mode, or none if name is empty.
+// Aggregations may write records with fields of their own, so it must be
+// called before SelectFields.
+func SetAggregation(name string) {
+	aggregateName = name
+	if t, ok := aggregateRecordTypes[name]; ok {
+		useRecordType(t)
+	}
+}
+
+func initializeAggregator(format string) {
+	if aggregateName == "" {
+		aggregator = nil
+		return
+	}
+
+	var err error
+	aggregateFormat = format
+	aggregator, err = Aggregators[aggregateName](format)
+	if err != nil {
+		log.Fatal().Msgf("Error creating %s aggregation: %v", aggregateName, err)
+	}
+}
+
+// checkSummaryFormat returns an error if the records of aggregations can not
+// be written in a format.
+func checkSummaryFormat(format string) error {
+	for _, f := range MessageFormats {
+		if f == format {
+			return fmt.Errorf("the %s format describes DNS messages and can not hold the records of aggregations", format)
+		}
+	}
+	return nil
+}
+
+// Tick ends the windows of the aggregation that are over at t. While reading
+// from a live interface it is called periodically, so the records of a
+// window are written even when no packets arrive after it.
+func Tick(t time.Time) {
+	a, ok := aggregator.(ticker)
+	if !ok {
+		return
+	}
+	a.Tick(t)
+	if flush, ok := Flushers[aggregateFormat]; ok && output == nil {
+		flush()
+	}
+}
+
+// emitRecord writes a record produced by an aggregation in the format.
+// Outputs are given d in place of the records that were summarized, to route
+// the record by.
+func emitRecord(d *DnsSchema, format string) {
+	if output != nil {
+		writeOutput(d, format)
+		return
+	}
+	Marshalers[format](d)
+}
+
+func closeAggregator() {
+	if aggregator == nil {
+		return
+	}
+	aggregator.Close()
+	aggregator = nil
+}
@@ -0,0 +1,65 @@
+package iohandlers
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// An aggregateTest runs an aggregation writing to a temporary file in place
+// of standard output.
+type aggregateTest struct {
+	t      *testing.T
+	format string
+	out    *os.File
+}
+
+// newAggregateTest initializes an aggregation in a format, with the given
+// fields or all of them.
+func newAggregateTest(t *testing.T, name, format string, fields ...string) *aggregateTest {
+	t.Helper()
+	out, err := os.CreateTemp(t.TempDir(), name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stdout, selected, all := os.Stdout, Fields, fieldsSelected
+	t.Cleanup(func() {
+		out.Close()
+		os.Stdout = stdout
+		SetAggregation("")
+		useRecordType(reflect.TypeOf(DnsSchema{}))
+		Fields, fieldsSelected = selected, all
+	})
+	os.Stdout = out
+
+	SetAggregation(name)
+	if len(fields) > 0 {
+		if err := SelectFields(fields); err != nil {
+			t.Fatal(err)
+		}
+	}
+	Initialize(format)
+	return &aggregateTest{t: t, format: format, out: out}
+}
+
+// lines returns the lines written so far.
+func (a *aggregateTest) lines() []string {
+	a.t.Helper()
+	b, err := os.ReadFile(a.out.Name())
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	if len(b) == 0 {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+}
+
+// close closes the aggregation and returns the lines written.
+func (a *aggregateTest) close() []string {
+	a.t.Helper()
+	Close(a.format)
+	return a.lines()
+}
@@ -0,0 +1,85 @@
+package iohandlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// An Alert reports suspicious activity found by an analyzer. Alerts are
+// written apart from the records, as JSON lines to AlertFile or to the log.
+type Alert struct {
+	Timestamp int64  `json:"timestamp"`
+	Type      string `json:"type"`
+	// Domain is the registered domain the alert is about, if any.
+	Domain string `json:"domain,omitempty"`
+	Qname  string `json:"qname,omitempty"`
+	// Score is the score of the analyzer that raised the alert, from 0 to
+	// 100.
+	Score  uint8  `json:"score,omitempty"`
+	Reason string `json:"reason"`
+	Source string `json:"source,omitempty"`
+	Sensor string `json:"sensor,omitempty"`
+	// Details holds the measurements the alert was raised on.
+	Details map[string]interface{} `json:"details,omitempty"`
+}
+
+var (
+	// AlertFile is the file alerts are appended to. Alerts are logged when
+	// it is empty.
+	AlertFile = ""
+
+	alertFile   *os.File
+	alertWriter *bufio.Writer
+)
+
+func initializeAlerts() {
+	if AlertFile == "" {
+		return
+	}
+
+	var err error
+	alertFile, err = os.OpenFile(AlertFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		log.Fatal().Msgf("Error opening alert file: %v", err)
+	}
+	alertWriter = bufio.NewWriter(alertFile)
+}
+
+// emitAlert writes an alert about the activity seen in a record.
+func emitAlert(d *DnsSchema, a *Alert) {
+	a.Timestamp = d.Timestamp
+	a.Source = d.Source
+	a.Sensor = d.Sensor
+
+	record, err := json.Marshal(a)
+	if err != nil {
+		log.Warn().Msgf("Error encoding alert: %v", err)
+		return
+	}
+
+	if alertWriter == nil {
+		log.WithLevel(zerolog.NoLevel).Str("level", "alert").RawJSON("alert", record).Msg(a.Reason)
+		return
+	}
+	alertWriter.Write(record)
+	if err := alertWriter.WriteByte('\n'); err != nil {
+		log.Warn().Msgf("Error writing alert: %v", err)
+	}
+}
+
+func closeAlerts() {
+	if alertFile == nil {
+		return
+	}
+	if err := alertWriter.Flush(); err != nil {
+		log.Warn().Msgf("%v", err)
+	}
+	if err := alertFile.Close(); err != nil {
+		log.Warn().Msgf("%v", err)
+	}
+	alertFile, alertWriter = nil, nil
+}
@@ -0,0 +1,88 @@
+package iohandlers
+
+import (
+	"hash/fnv"
+	"math"
+	"math/bits"
+)
+
+const (
+	// Sketches have 2^hllPrecision registers, for a standard error of
+	// 1.04/sqrt(1024), or about 3%.
+	hllPrecision = 10
+	// distinctExactLimit is the number of values counted exactly before
+	// switching to a sketch, which uses about as much memory.
+	distinctExactLimit = 128
+)
+
+// A distinctCounter estimates the number of distinct values added to it. Few
+// values are counted exactly, while many are estimated with a HyperLogLog
+// sketch of fixed size.
+type distinctCounter struct {
+	exact     map[uint64]struct{}
+	registers []uint8
+}
+
+// distinctHash returns the 64-bit hash of a value, mixed so all of its bits
+// are usable by the sketch.
+func distinctHash(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	x := h.Sum64()
+
+	// The finalizer of SplitMix64
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	return x ^ (x >> 31)
+}
+
+func (c *distinctCounter) add(s string) {
+	h := distinctHash(s)
+	if c.registers != nil {
+		c.addSketch(h)
+		return
+	}
+
+	if c.exact == nil {
+		c.exact = make(map[uint64]struct{})
+	}
+	c.exact[h] = struct{}{}
+	if len(c.exact) > distinctExactLimit {
+		c.registers = make([]uint8, 1<<hllPrecision)
+		for h := range c.exact {
+			c.addSketch(h)
+		}
+		c.exact = nil
+	}
+}
+
+// addSketch records a hash in the register selected by its first bits,
+// which keeps the longest run of leading zeros seen in the remaining bits.
+func (c *distinctCounter) addSketch(h uint64) {
+	rank := uint8(bits.LeadingZeros64(h<<hllPrecision|1<<(hllPrecision-1))) + 1
+	if i := h >> (64 - hllPrecision); rank > c.registers[i] {
+		c.registers[i] = rank
+	}
+}
+
+func (c *distinctCounter) count() uint64 {
+	if c.registers == nil {
+		return uint64(len(c.exact))
+	}
+
+	m := float64(len(c.registers))
+	sum, zeros := 0.0, 0
+	for _, r := range c.registers {
+		sum += math.Ldexp(1, -int(r))
+		if r == 0 {
+			zeros++
+		}
+	}
+	estimate := 0.7213 / (1 + 1.079/m) * m * m / sum
+
+	// Small cardinalities are better estimated by linear counting
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return uint64(estimate + 0.5)
+}
@@ -0,0 +1,178 @@
+package iohandlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	Outputs["elasticsearch"] = newElasticsearchOutput
+}
+
+// An ElasticsearchConfig holds the options for the elasticsearch output,
+// which works with both Elasticsearch and OpenSearch. Batching and retries
+// are configured with the Http options.
+type ElasticsearchConfig struct {
+	// Url is the base URL of the cluster. Credentials can be given as part
+	// of the URL.
+	Url string
+	// Index is the name of the index records are written to. It may
+	// contain the strftime-style directives %Y, %m, %d and %H, which are
+	// replaced with the UTC time of the record.
+	Index string
+}
+
+var Elasticsearch = ElasticsearchConfig{
+	Index: "rickybobby-%Y.%m.%d",
+}
+
+type elasticsearchOutput struct {
+	sender *bulkSender
+	url    string
+	action bytes.Buffer
+
+	// The index name only changes every hour at most
+	indexHour int64
+	index     string
+}
+
+func newElasticsearchOutput(format string) (Output, error) {
+	if Elasticsearch.Url == "" {
+		return nil, fmt.Errorf("no URL given")
+	}
+	if format != "json" {
+		return nil, fmt.Errorf("the %s format can not be indexed, use json", format)
+	}
+
+	o := &elasticsearchOutput{url: strings.TrimSuffix(Elasticsearch.Url, "/") + "/_bulk"}
+	o.sender = newBulkSender(Http, []byte("\n"), o.post)
+	return o, nil
+}
+
+// expandIndex replaces the date directives in an index name pattern.
+func expandIndex(pattern string, timestamp int64) string {
+	if !strings.Contains(pattern, "%") {
+		return pattern
+	}
+
+	t := time.Unix(timestamp, 0).UTC()
+	return strings.NewReplacer(
+		"%Y", fmt.Sprintf("%04d", t.Year()),
+		"%m", fmt.Sprintf("%02d", t.Month()),
+		"%d", fmt.Sprintf("%02d", t.Day()),
+		"%H", fmt.Sprintf("%02d", t.Hour()),
+		"%%", "%",
+	).Replace(pattern)
+}
+
+// documentId identifies a record by its packet, section and position in
+// the section, so replaying a capture overwrites documents instead of
+// duplicating them.
+func documentId(d *DnsSchema) string {
+	switch {
+	case d.Answer:
+		return d.Sha256 + "-answer-" + strconv.Itoa(d.RrIndex)
+	case d.Authority:
+		return d.Sha256 + "-authority-" + strconv.Itoa(d.RrIndex)
+	case d.Additional:
+		return d.Sha256 + "-additional-" + strconv.Itoa(d.RrIndex)
+	}
+	return d.Sha256
+}
+
+// Write queues the index action for a record followed by the record itself.
+func (o *elasticsearchOutput) Write(d *DnsSchema, record []byte) error {
+	action := struct {
+		Index struct {
+			Index string `json:"_index"`
+			Id    string `json:"_id"`
+		} `json:"index"`
+	}{}
+	if hour := d.Timestamp / 3600; hour != o.indexHour || o.index == "" {
+		o.indexHour = hour
+		o.index = expandIndex(Elasticsearch.Index, d.Timestamp)
+	}
+	action.Index.Index = o.index
+	action.Index.Id = documentId(d)
+
+	o.action.Reset()
+	if err := json.NewEncoder(&o.action).Encode(&action); err != nil {
+		return err
+	}
+	o.action.Write(record)
+	o.sender.add(o.action.Bytes())
+	return nil
+}
+
+// bulkResponse holds the parts of a _bulk response needed to find the
+// documents that were rejected.
+type bulkResponse struct {
+	Errors bool `json:"errors"`
+	Items  []map[string]struct {
+		Status int             `json:"status"`
+		Error  json.RawMessage `json:"error"`
+	} `json:"items"`
+}
+
+// post sends a batch to the _bulk endpoint. When only some documents are
+// rejected, the batch is replaced with the ones worth retrying.
+func (o *elasticsearchOutput) post(batch *httpBatch) error {
+	resp, err := HttpClient.Post(o.url, "application/x-ndjson", bytes.NewReader(batch.body.Bytes()))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp); err != nil {
+		return err
+	}
+
+	var result bulkResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return permanentError{fmt.Errorf("could not decode bulk response: %v", err)}
+	}
+	if !result.Errors {
+		return nil
+	}
+	if len(result.Items) != batch.records {
+		return permanentError{fmt.Errorf("bulk response has %d items for %d documents",
+			len(result.Items), batch.records)}
+	}
+
+	retry := &httpBatch{}
+	rejected := 0
+	var lastError json.RawMessage
+	for i, item := range result.Items {
+		for _, status := range item {
+			switch {
+			case status.Status < 300:
+			case status.Status == http.StatusTooManyRequests || status.Status >= 500:
+				retry.body.Write(batch.record(i))
+				retry.records++
+				retry.ends = append(retry.ends, retry.body.Len())
+			default:
+				rejected++
+				lastError = status.Error
+			}
+		}
+	}
+
+	if rejected > 0 {
+		deliveryFailed(rejected, fmt.Errorf("documents rejected: %s", lastError))
+	}
+	if retry.records == 0 {
+		return nil
+	}
+
+	*batch = *retry
+	return fmt.Errorf("%d document(s) temporarily rejected", retry.records)
+}
+
+func (o *elasticsearchOutput) Close() error {
+	o.sender.close()
+	return nil
+}
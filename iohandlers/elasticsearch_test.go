@@ -0,0 +1,195 @@
+package iohandlers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestExpandIndex(t *testing.T) {
+	// 2024-05-01 13:04:05 UTC
+	timestamp := int64(1714568645)
+	tests := []struct {
+		pattern string
+		want    string
+	}{
+		{"rickybobby", "rickybobby"},
+		{"rickybobby-%Y.%m.%d", "rickybobby-2024.05.01"},
+		{"dns-%Y-%m-%d-%H", "dns-2024-05-01-13"},
+		{"dns-%Y%%", "dns-2024%"},
+		{"dns-%%Y", "dns-%Y"},
+		{"dns-%q", "dns-%q"},
+	}
+
+	for _, tt := range tests {
+		if got := expandIndex(tt.pattern, timestamp); got != tt.want {
+			t.Errorf("expandIndex(%q) = %q, want %q", tt.pattern, got, tt.want)
+		}
+	}
+	// The time is in UTC whatever the local time zone
+	if got := expandIndex("%Y.%m.%d.%H", 1714607999); got != "2024.05.01.23" {
+		t.Errorf("expandIndex at the end of the day = %q", got)
+	}
+}
+
+func TestDocumentId(t *testing.T) {
+	tests := []struct {
+		d    DnsSchema
+		want string
+	}{
+		{DnsSchema{Sha256: "abc"}, "abc"},
+		{DnsSchema{Sha256: "abc", Answer: true, RrIndex: 0}, "abc-answer-0"},
+		{DnsSchema{Sha256: "abc", Answer: true, RrIndex: 2}, "abc-answer-2"},
+		{DnsSchema{Sha256: "abc", Authority: true, RrIndex: 1}, "abc-authority-1"},
+		{DnsSchema{Sha256: "abc", Additional: true, RrIndex: 3}, "abc-additional-3"},
+	}
+
+	for _, tt := range tests {
+		if got := documentId(&tt.d); got != tt.want {
+			t.Errorf("documentId(%+v) = %q, want %q", tt.d, got, tt.want)
+		}
+	}
+}
+
+// A bulkRequest is a document received by the mock _bulk endpoint.
+type bulkRequest struct {
+	index, id, source string
+}
+
+// mockElasticsearch answers _bulk requests with the given bodies in turn,
+// and then with a response without errors. It returns the documents of
+// every request.
+func mockElasticsearch(t *testing.T, responses ...string) (*httptest.Server, func() [][]bulkRequest) {
+	t.Helper()
+	var mu sync.Mutex
+	var requests [][]bulkRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/_bulk" {
+			t.Errorf("request for %s", r.URL.Path)
+		}
+		body, _ := io.ReadAll(r.Body)
+		lines := strings.Split(strings.TrimSuffix(string(body), "\n"), "\n")
+		var docs []bulkRequest
+		for i := 0; i+1 < len(lines); i += 2 {
+			var action struct {
+				Index struct {
+					Index string `json:"_index"`
+					Id    string `json:"_id"`
+				} `json:"index"`
+			}
+			if err := json.Unmarshal([]byte(lines[i]), &action); err != nil {
+				t.Errorf("decoding action %q: %v", lines[i], err)
+			}
+			docs = append(docs, bulkRequest{action.Index.Index, action.Index.Id, lines[i+1]})
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		requests = append(requests, docs)
+		if len(responses) > 0 {
+			io.WriteString(w, responses[0])
+			responses = responses[1:]
+			return
+		}
+		io.WriteString(w, `{"errors":false,"items":[]}`)
+	}))
+	t.Cleanup(server.Close)
+	return server, func() [][]bulkRequest {
+		mu.Lock()
+		defer mu.Unlock()
+		return requests
+	}
+}
+
+// writeElasticsearchTest indexes a document for each of the given IDs with
+// an elasticsearch output posting to server.
+func writeElasticsearchTest(t *testing.T, server *httptest.Server, ids ...string) {
+	t.Helper()
+	config, httpConfig := Elasticsearch, Http
+	defer func() { Elasticsearch, Http = config, httpConfig }()
+	Elasticsearch = ElasticsearchConfig{Url: server.URL + "/", Index: "dns-%Y.%m.%d"}
+	Http = HttpConfig{BatchSize: 100, MaxRetries: 2, RetryBackoff: time.Millisecond}
+
+	out, err := newElasticsearchOutput("json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range ids {
+		d := &DnsSchema{Timestamp: 1714568645, Sha256: id}
+		if err := out.Write(d, []byte(`{"sha256":"`+id+`"}`)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	out.Close()
+}
+
+func TestElasticsearchPartialFailure(t *testing.T) {
+	server, requests := mockElasticsearch(t, `{"errors":true,"items":[
+		{"index":{"status":201}},
+		{"index":{"status":429,"error":{"type":"es_rejected_execution_exception"}}},
+		{"index":{"status":400,"error":{"type":"mapper_parsing_exception"}}},
+		{"index":{"status":503,"error":{"type":"unavailable_shards_exception"}}},
+		{"index":{"status":409,"error":{"type":"version_conflict_engine_exception"}}},
+		{"index":{"status":200}}
+	]}`, `{"errors":true,"items":[
+		{"index":{"status":201}},
+		{"index":{"status":429,"error":{"type":"es_rejected_execution_exception"}}}
+	]}`)
+
+	deliveryErrors.Store(0)
+	defer deliveryErrors.Store(0)
+	writeElasticsearchTest(t, server, "a", "b", "c", "d", "e", "f")
+
+	// Only the throttled and unavailable documents are sent again, until
+	// they are all indexed
+	want := [][]string{{"a", "b", "c", "d", "e", "f"}, {"b", "d"}, {"d"}}
+	got := requests()
+	if len(got) != len(want) {
+		t.Fatalf("%d requests, want %d: %v", len(got), len(want), got)
+	}
+	for i, docs := range got {
+		var ids []string
+		for _, doc := range docs {
+			ids = append(ids, doc.id)
+			if doc.index != "dns-2024.05.01" || doc.source != `{"sha256":"`+doc.id+`"}` {
+				t.Errorf("request %d: document %+v", i, doc)
+			}
+		}
+		if strings.Join(ids, ",") != strings.Join(want[i], ",") {
+			t.Errorf("request %d: documents %v, want %v", i, ids, want[i])
+		}
+	}
+	// The mapping error and the version conflict are never retried
+	if DeliveryErrors() != 2 {
+		t.Errorf("%d delivery errors, want 2", DeliveryErrors())
+	}
+}
+
+func TestElasticsearchPermanentErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+	}{
+		{"item count mismatch", `{"errors":true,"items":[{"index":{"status":429}}]}`},
+		{"undecodable response", `<html>`},
+	}
+
+	for _, tt := range tests {
+		server, requests := mockElasticsearch(t, tt.response)
+		deliveryErrors.Store(0)
+		writeElasticsearchTest(t, server, "a", "b", "c")
+
+		if n := len(requests()); n != 1 {
+			t.Errorf("%s: %d requests, want 1", tt.name, n)
+		}
+		if DeliveryErrors() != 3 {
+			t.Errorf("%s: %d delivery errors, want 3", tt.name, DeliveryErrors())
+		}
+	}
+	deliveryErrors.Store(0)
+}
@@ -0,0 +1,167 @@
+package iohandlers
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/chazlever/rickybobby/dga"
+	"github.com/chazlever/rickybobby/psl"
+	"golang.org/x/net/idna"
+)
+
+func init() {
+	Enrichments["psl"] = enrichPsl
+	Enrichments["idn"] = enrichIdn
+	Enrichments["dga"] = enrichDga
+}
+
+var (
+	// Enrichments holds the stages that add fields to records. They are
+	// run before the filters, so the fields they add can be filtered on.
+	Enrichments = make(map[string]func(*DnsSchema) bool)
+
+	// PublicSuffixes is the list names are split into their public suffix
+	// and registered domain with.
+	PublicSuffixes = psl.Default()
+
+	// idnaProfile decodes names as specified by UTS #46, without the
+	// transitional mappings of IDNA2003.
+	idnaProfile = idna.New(idna.MapForLookup(), idna.BidiRule(), idna.Transitional(false))
+
+	// Scripts that may be mixed within a label, as in the highly restrictive
+	// level of UTS #39
+	scriptCombinations = [][]string{
+		{"Latin", "Han", "Hiragana", "Katakana"},
+		{"Latin", "Han", "Bopomofo"},
+		{"Latin", "Han", "Hangul"},
+	}
+)
+
+// enrichPsl adds the public suffix, registered domain and subdomain of the
+// qname.
+func enrichPsl(d *DnsSchema) bool {
+	suffix, domain, subdomain := PublicSuffixes.Split(d.Qname)
+	d.QnameEtld = nonEmpty(suffix)
+	d.QnameRegisteredDomain = nonEmpty(domain)
+	d.QnameSubdomain = nonEmpty(subdomain)
+	return true
+}
+
+// nonEmpty returns a pointer to s, or nil if s is empty.
+func nonEmpty(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+// registeredDomain returns the registered domain of the qname of a record,
+// or its public suffix if the qname is a public suffix itself.
+func registeredDomain(d *DnsSchema) string {
+	if d.QnameRegisteredDomain != nil {
+		return *d.QnameRegisteredDomain
+	}
+	suffix, domain, _ := PublicSuffixes.Split(d.Qname)
+	if domain == "" {
+		return suffix
+	}
+	return domain
+}
+
+// enrichDga adds how much the label of the registered domain of the qname
+// looks algorithmically generated, if it can be scored.
+func enrichDga(d *DnsSchema) bool {
+	_, domain, _ := PublicSuffixes.Split(d.Qname)
+	if i := strings.IndexByte(domain, '.'); i >= 0 {
+		domain = domain[:i]
+	}
+	if score, ok := dga.Score(domain); ok {
+		d.DgaScore = &score
+	}
+	return true
+}
+
+// enrichIdn adds the qname and rname in lowercase and decoded to Unicode, and
+// whether any of their labels mix scripts.
+func enrichIdn(d *DnsSchema) bool {
+	normalized, decoded, mixed := normalizeName(d.Qname)
+	d.QnameNormalized, d.QnameUnicode, d.QnameMixedScript = &normalized, &decoded, &mixed
+	if d.Rname != nil {
+		normalized, decoded, mixed := normalizeName(*d.Rname)
+		d.RnameNormalized, d.RnameUnicode, d.RnameMixedScript = &normalized, &decoded, &mixed
+	}
+	return true
+}
+
+// normalizeName returns a name in lowercase without the trailing dot, the
+// same name with its punycode labels decoded and whether a decoded label
+// mixes scripts. Labels that can't be decoded are kept as they are.
+func normalizeName(name string) (normalized, decoded string, mixed bool) {
+	normalized = strings.ToLower(strings.TrimSuffix(name, "."))
+	if !strings.Contains(normalized, "xn--") {
+		return normalized, normalized, false
+	}
+
+	labels := strings.Split(normalized, ".")
+	for i, label := range labels {
+		if !strings.HasPrefix(label, "xn--") {
+			continue
+		}
+		if u, err := idnaProfile.ToUnicode(label); err == nil {
+			labels[i] = u
+			mixed = mixed || mixedScript(u)
+		}
+	}
+	return normalized, strings.Join(labels, "."), mixed
+}
+
+// scriptOf returns the script of a letter, or an empty string for characters
+// shared by scripts such as digits and the hyphen.
+func scriptOf(r rune) string {
+	if r < 0x80 {
+		if unicode.IsLetter(r) {
+			return "Latin"
+		}
+		return ""
+	}
+	for name, table := range unicode.Scripts {
+		if name != "Common" && name != "Inherited" && unicode.Is(table, r) {
+			return name
+		}
+	}
+	return ""
+}
+
+// mixedScript reports whether a label holds letters of more than one script,
+// other than the combinations used to write Chinese, Japanese and Korean.
+func mixedScript(label string) bool {
+	var scripts []string
+	for _, r := range label {
+		if s := scriptOf(r); s != "" && !contains(scripts, s) {
+			scripts = append(scripts, s)
+		}
+	}
+	if len(scripts) < 2 {
+		return false
+	}
+
+COMBINATIONS:
+	for _, allowed := range scriptCombinations {
+		for _, s := range scripts {
+			if !contains(allowed, s) {
+				continue COMBINATIONS
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
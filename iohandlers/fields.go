@@ -0,0 +1,141 @@
+package iohandlers
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// A schemaField is a single column of the DnsSchema, named after its json tag.
+type schemaField struct {
+	name      string
+	index     int
+	nullable  bool
+	omitEmpty bool
+}
+
+var (
+	// recordType is the type of the records that are output, which is
+	// DnsSchema unless an aggregation writes records of its own.
+	recordType   = reflect.TypeOf(DnsSchema{})
+	schemaFields = fieldsOf(recordType)
+
+	// Fields holds the columns selected for output, or all of them if
+	// nothing was selected.
+	Fields = schemaFields
+
+	// fieldsSelected is set when Fields holds a selection made with
+	// SelectFields rather than every field.
+	fieldsSelected = false
+
+	// enabledEnrichments holds the enrichments whose fields are output.
+	// Fields tagged with another enrichment are left out.
+	enabledEnrichments = make(map[string]bool)
+)
+
+func fieldsOf(t reflect.Type) []schemaField {
+	fields := make([]schemaField, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		tag := strings.Split(t.Field(i).Tag.Get("json"), ",")
+		if tag[0] == "" || tag[0] == "-" {
+			continue
+		}
+		if e := t.Field(i).Tag.Get("enrich"); e != "" && !enabledEnrichments[e] {
+			continue
+		}
+		fields = append(fields, schemaField{
+			name:      tag[0],
+			index:     i,
+			nullable:  t.Field(i).Type.Kind() == reflect.Ptr,
+			omitEmpty: len(tag) > 1 && tag[1] == "omitempty",
+		})
+	}
+	return fields
+}
+
+// useRecordType makes the formats output records of another type, which
+// are handed to them as the Summary of a DnsSchema.
+func useRecordType(t reflect.Type) {
+	recordType = t
+	schemaFields = fieldsOf(t)
+	Fields = schemaFields
+	fieldsSelected = false
+}
+
+// EnableEnrichment adds the fields of an enrichment to those that can be
+// output. It must be called before SelectFields.
+func EnableEnrichment(name string) {
+	enabledEnrichments[name] = true
+	schemaFields = fieldsOf(recordType)
+	if !fieldsSelected {
+		Fields = schemaFields
+	}
+}
+
+// EnrichmentEnabled reports whether the fields of an enrichment are output.
+func EnrichmentEnabled(name string) bool {
+	return enabledEnrichments[name]
+}
+
+// recordValue returns the record that is output for d.
+func recordValue(d *DnsSchema) reflect.Value {
+	if d.Summary != nil {
+		return reflect.ValueOf(d.Summary).Elem()
+	}
+	return reflect.ValueOf(d).Elem()
+}
+
+// FieldNames returns the names of all the fields that can be output.
+func FieldNames() []string {
+	names := make([]string, len(schemaFields))
+	for i, f := range schemaFields {
+		names[i] = f.name
+	}
+	return names
+}
+
+// SelectFields limits output to the named fields in the given order.
+func SelectFields(names []string) error {
+	selected := make([]schemaField, 0, len(names))
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		found := false
+		for _, f := range schemaFields {
+			if f.name == name {
+				selected = append(selected, f)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("unknown field \"%s\" not in %v", name, FieldNames())
+		}
+	}
+	Fields = selected
+	fieldsSelected = true
+	return nil
+}
+
+// formatField returns the textual representation of a field and false if
+// the field is null.
+func formatField(v reflect.Value, f schemaField) (string, bool) {
+	fv := v.Field(f.index)
+	if f.nullable {
+		if fv.IsNil() {
+			return "", false
+		}
+		fv = fv.Elem()
+	}
+
+	switch fv.Kind() {
+	case reflect.String:
+		return fv.String(), true
+	case reflect.Bool:
+		return strconv.FormatBool(fv.Bool()), true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(fv.Int(), 10), true
+	default:
+		return strconv.FormatUint(fv.Uint(), 10), true
+	}
+}
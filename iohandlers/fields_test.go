@@ -0,0 +1,116 @@
+package iohandlers
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// enableTestEnrichment enables an enrichment until the end of the test.
+func enableTestEnrichment(t *testing.T, name string) {
+	t.Helper()
+	EnableEnrichment(name)
+	t.Cleanup(func() {
+		delete(enabledEnrichments, name)
+		schemaFields = fieldsOf(recordType)
+		Fields = schemaFields
+	})
+}
+
+func hasField(name string) bool {
+	for _, f := range FieldNames() {
+		if f == name {
+			return true
+		}
+	}
+	return false
+}
+
+func TestEnrichmentFields(t *testing.T) {
+	tests := []struct {
+		enrichment string
+		fields     []string
+	}{
+		{"psl", []string{"qname_etld", "qname_registered_domain", "qname_subdomain"}},
+		{"idn", []string{"qname_normalized", "qname_unicode", "qname_mixed_script", "rname_normalized", "rname_unicode", "rname_mixed_script"}},
+		{"case", []string{"qname_mixed_case", "qname_case_mismatch"}},
+		{"geoip", []string{"src_country", "src_asn", "src_as_org", "dst_country", "dst_asn", "dst_as_org",
+			"ecs_client_country", "ecs_client_asn", "ecs_client_as_org", "rdata_country", "rdata_asn", "rdata_as_org"}},
+		{"tunnel", []string{"tunnel_score"}},
+		{"dga", []string{"dga_score"}},
+		{"nod", []string{"first_seen_ever"}},
+	}
+
+	for _, tt := range tests {
+		for _, name := range tt.fields {
+			if hasField(name) {
+				t.Errorf("field %q is output without the %s enrichment", name, tt.enrichment)
+			}
+			if schema := AvroSchema(); strings.Contains(schema, `"`+name+`"`) {
+				t.Errorf("Avro schema holds %q without the %s enrichment", name, tt.enrichment)
+			}
+		}
+	}
+
+	for _, tt := range tests {
+		enableTestEnrichment(t, tt.enrichment)
+		for _, name := range tt.fields {
+			if !hasField(name) {
+				t.Errorf("field %q isn't output with the %s enrichment", name, tt.enrichment)
+			}
+			if schema := ClickHouseSchema("dns"); !strings.Contains(schema, "`"+name+"` Nullable(") {
+				t.Errorf("ClickHouse schema lacks nullable %q with the %s enrichment", name, tt.enrichment)
+			}
+		}
+	}
+}
+
+func TestEnrichmentJson(t *testing.T) {
+	d := &DnsSchema{Qname: "www.example.co.uk."}
+	enrichPsl(d)
+	b, err := json.Marshal(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), `"qname_registered_domain":"example.co.uk"`) {
+		t.Errorf("registered domain missing from %s", b)
+	}
+
+	d = &DnsSchema{Qname: "co.uk."}
+	enrichPsl(d)
+	if d.QnameEtld == nil || *d.QnameEtld != "co.uk" || d.QnameRegisteredDomain != nil {
+		t.Errorf("public suffix co.uk split into %v, %v", d.QnameEtld, d.QnameRegisteredDomain)
+	}
+	if b, _ := json.Marshal(&DnsSchema{}); strings.Contains(string(b), "qname_etld") {
+		t.Errorf("record without enrichments holds qname_etld: %s", b)
+	}
+}
+
+func TestEnrichmentZeroScore(t *testing.T) {
+	// A domain without tunneling traffic scores 0, which is output rather
+	// than taken for a score that wasn't computed
+	d := &DnsSchema{Qname: "example.com.", Qtype: dns.TypeA, Time: time.Unix(1714564800, 0)}
+	enrichTunnel(d)
+	if d.TunnelScore == nil {
+		t.Fatal("tunnel score not set")
+	}
+	if b, _ := json.Marshal(d); !strings.Contains(string(b), `"tunnel_score":0`) {
+		t.Errorf("tunnel score of 0 missing from %s", b)
+	}
+
+	// The same goes for DGA scores, while names whose label can't be scored
+	// don't get one at all
+	d = &DnsSchema{Qname: "www.google.com."}
+	enrichDga(d)
+	if d.DgaScore == nil || *d.DgaScore != 0 {
+		t.Errorf("dga score of google.com is %v, want 0", d.DgaScore)
+	}
+	d = &DnsSchema{Qname: "xn--bcher-kva.example."}
+	enrichDga(d)
+	if d.DgaScore != nil {
+		t.Errorf("punycode label scored %d", *d.DgaScore)
+	}
+}
@@ -0,0 +1,52 @@
+package iohandlers
+
+import (
+	"github.com/chazlever/rickybobby/firstseen"
+	"github.com/rs/zerolog/log"
+)
+
+func init() {
+	Enrichments["nod"] = enrichNod
+}
+
+// FirstSeen is the record of registered domains seen before. It must be set
+// before the nod enrichment is used.
+var FirstSeen *firstseen.Store
+
+var (
+	// The message whose records are being enriched, whether its domain was
+	// new and whether that is known
+	nodSha256 string
+	nodFirst  bool
+	nodKnown  bool
+)
+
+// enrichNod adds whether the registered domain of the qname is a newly
+// observed domain, seen for the first time ever or since it expired. The
+// records of a message arrive one after the other, so the domain is only
+// looked up for the first of them.
+func enrichNod(d *DnsSchema) bool {
+	if d.Sha256 != nodSha256 || d.Sha256 == "" {
+		nodSha256 = d.Sha256
+		var err error
+		nodFirst, err = FirstSeen.Seen(registeredDomain(d), d.Time)
+		nodKnown = err == nil
+		if err != nil {
+			log.Warn().Msgf("Error recording seen domains: %v", err)
+		}
+	}
+	if nodKnown {
+		first := nodFirst
+		d.FirstSeenEver = &first
+	}
+	return true
+}
+
+func flushFirstSeen() {
+	if FirstSeen == nil {
+		return
+	}
+	if err := FirstSeen.Flush(); err != nil {
+		log.Warn().Msgf("Error recording seen domains: %v", err)
+	}
+}
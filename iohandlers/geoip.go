@@ -0,0 +1,39 @@
+package iohandlers
+
+import (
+	"github.com/chazlever/rickybobby/geoip"
+	"github.com/miekg/dns"
+)
+
+func init() {
+	Enrichments["geoip"] = enrichGeoip
+}
+
+// GeoIP holds the databases addresses are looked up in. It must be set
+// before the geoip enrichment is used.
+var GeoIP *geoip.Databases
+
+// enrichGeoip adds the country, ASN and AS organization of the source,
+// destination and ECS client addresses, and of the address in A and AAAA
+// records.
+func enrichGeoip(d *DnsSchema) bool {
+	d.SrcCountry, d.SrcAsn, d.SrcAsOrg = geoipFields(GeoIP.Lookup(d.SourceAddress))
+	d.DstCountry, d.DstAsn, d.DstAsOrg = geoipFields(GeoIP.Lookup(d.DestinationAddress))
+
+	if d.EcsClient != nil {
+		d.EcsClientCountry, d.EcsClientAsn, d.EcsClientAsOrg = geoipFields(GeoIP.Lookup(*d.EcsClient))
+	}
+	if d.Rdata != nil && d.Rtype != nil && (*d.Rtype == dns.TypeA || *d.Rtype == dns.TypeAAAA) {
+		d.RdataCountry, d.RdataAsn, d.RdataAsOrg = geoipFields(GeoIP.Lookup(*d.Rdata))
+	}
+	return true
+}
+
+// geoipFields returns the fields for what was found about an address, which
+// are nil when the databases don't know it.
+func geoipFields(info geoip.Info) (country *string, asn *uint32, org *string) {
+	if info.Asn != 0 {
+		asn = &info.Asn
+	}
+	return nonEmpty(info.Country), asn, nonEmpty(info.Organization)
+}
@@ -0,0 +1,259 @@
+package iohandlers
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+func init() {
+	Outputs["http"] = newHttpOutput
+}
+
+// A HttpConfig holds the options for the http output.
+type HttpConfig struct {
+	// Url receives the batches of records as POST requests. For ClickHouse
+	// this includes the INSERT query, e.g.
+	// http://localhost:8123/?query=INSERT+INTO+dns+FORMAT+JSONEachRow
+	Url string
+	// BatchSize is the maximum number of records sent in one request.
+	BatchSize int
+	// BatchBytes is the size a request body grows to before it is sent, or 0
+	// for no limit. A single record larger than this is sent on its own.
+	BatchBytes int
+	// FlushInterval is the longest a record waits before it is sent.
+	FlushInterval time.Duration
+	// MaxPending is the number of full batches kept in memory while
+	// waiting to be sent. Parsing blocks when it is reached.
+	MaxPending int
+	// MaxRetries is how many times a failed request is retried.
+	MaxRetries int
+	// RetryBackoff is the delay before the first retry, which doubles after
+	// every attempt.
+	RetryBackoff time.Duration
+}
+
+var (
+	Http = HttpConfig{
+		BatchSize:     10000,
+		BatchBytes:    10 << 20,
+		FlushInterval: 5 * time.Second,
+		MaxPending:    4,
+		MaxRetries:    5,
+		RetryBackoff:  time.Second,
+	}
+
+	// HttpClient is used for requests made by the http output.
+	HttpClient = http.DefaultClient
+)
+
+// httpBatch is a request body together with the number of records in it.
+type httpBatch struct {
+	body    bytes.Buffer
+	records int
+	ends    []int
+}
+
+// record returns the i-th record of the batch including its separator.
+func (b *httpBatch) record(i int) []byte {
+	start := 0
+	if i > 0 {
+		start = b.ends[i-1]
+	}
+	return b.body.Bytes()[start:b.ends[i]]
+}
+
+// A bulkSender batches encoded records and hands full batches to a single
+// background goroutine for delivery. It is shared by outputs that POST
+// batches of records to an HTTP endpoint.
+type bulkSender struct {
+	config    HttpConfig
+	send      func(*httpBatch) error
+	separator []byte
+
+	mu      sync.Mutex
+	batch   *httpBatch
+	pending chan *httpBatch
+	done    chan struct{}
+	stop    chan struct{}
+}
+
+func newBulkSender(config HttpConfig, separator []byte, send func(*httpBatch) error) *bulkSender {
+	if config.BatchSize < 1 {
+		config.BatchSize = 1
+	}
+	if config.MaxPending < 1 {
+		config.MaxPending = 1
+	}
+
+	b := &bulkSender{
+		config:    config,
+		send:      send,
+		separator: separator,
+		batch:     &httpBatch{},
+		pending:   make(chan *httpBatch, config.MaxPending),
+		done:      make(chan struct{}),
+		stop:      make(chan struct{}),
+	}
+	go b.run()
+	if config.FlushInterval > 0 {
+		go b.tick()
+	}
+	return b
+}
+
+// add appends a record to the current batch. The batch is queued for
+// delivery when it holds BatchSize records or the record would take it past
+// BatchBytes, blocking while too many batches are pending.
+func (b *bulkSender) add(record []byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	size := len(record) + len(b.separator)
+	if b.config.BatchBytes > 0 && b.batch.body.Len()+size > b.config.BatchBytes {
+		b.flushLocked()
+	}
+
+	b.batch.body.Write(record)
+	b.batch.body.Write(b.separator)
+	b.batch.records++
+	b.batch.ends = append(b.batch.ends, b.batch.body.Len())
+	if b.batch.records >= b.config.BatchSize {
+		b.flushLocked()
+	}
+}
+
+func (b *bulkSender) flushLocked() {
+	if b.batch.records == 0 {
+		return
+	}
+	b.pending <- b.batch
+	b.batch = &httpBatch{}
+}
+
+func (b *bulkSender) tick() {
+	ticker := time.NewTicker(b.config.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			b.mu.Lock()
+			b.flushLocked()
+			b.mu.Unlock()
+		case <-b.stop:
+			return
+		}
+	}
+}
+
+func (b *bulkSender) run() {
+	defer close(b.done)
+	for batch := range b.pending {
+		if err := b.retry(batch); err != nil {
+			deliveryFailed(batch.records, err)
+		}
+	}
+}
+
+// retry sends a batch until it succeeds, the error is permanent or the
+// retries run out, backing off exponentially between attempts. The send
+// function may replace the contents of the batch to only retry some of its
+// records.
+func (b *bulkSender) retry(batch *httpBatch) error {
+	backoff := b.config.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		err := b.send(batch)
+		if err == nil {
+			return nil
+		}
+		if _, permanent := err.(permanentError); permanent || attempt >= b.config.MaxRetries {
+			return err
+		}
+
+		log.Warn().Msgf("Error sending %d record(s), retrying in %v: %v", batch.records, backoff, err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// close sends the remaining records and waits for delivery to finish.
+func (b *bulkSender) close() {
+	close(b.stop)
+	b.mu.Lock()
+	b.flushLocked()
+	close(b.pending)
+	b.mu.Unlock()
+	<-b.done
+}
+
+// A permanentError is a failed request that should not be retried.
+type permanentError struct {
+	error
+}
+
+// checkResponse turns an unsuccessful response into an error. Server errors
+// and throttling are retried while other client errors are permanent.
+func checkResponse(resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	err := fmt.Errorf("%s returned %s: %s", resp.Request.URL.Redacted(), resp.Status, bytes.TrimSpace(body))
+	if resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests {
+		return err
+	}
+	return permanentError{err}
+}
+
+type httpOutput struct {
+	sender      *bulkSender
+	contentType string
+}
+
+func newHttpOutput(format string) (Output, error) {
+	if Http.Url == "" {
+		return nil, fmt.Errorf("no URL given")
+	}
+
+	// Records are sent one after the other, so only formats with
+	// self-delimiting records can be used
+	o := &httpOutput{}
+	var separator []byte
+	switch format {
+	case "json":
+		o.contentType = "application/x-ndjson"
+		separator = []byte("\n")
+	case "rowbinary":
+		o.contentType = "application/octet-stream"
+	default:
+		return nil, fmt.Errorf("the %s format can not be sent in bulk, use json or rowbinary", format)
+	}
+
+	o.sender = newBulkSender(Http, separator, o.post)
+	return o, nil
+}
+
+func (o *httpOutput) post(batch *httpBatch) error {
+	resp, err := HttpClient.Post(Http.Url, o.contentType, bytes.NewReader(batch.body.Bytes()))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return checkResponse(resp)
+}
+
+func (o *httpOutput) Write(_ *DnsSchema, record []byte) error {
+	o.sender.add(record)
+	return nil
+}
+
+func (o *httpOutput) Close() error {
+	o.sender.close()
+	return nil
+}
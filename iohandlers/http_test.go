@@ -0,0 +1,366 @@
+package iohandlers
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// sentBatches records the batches handed to the send function of a
+// bulkSender.
+type sentBatches struct {
+	mu      sync.Mutex
+	batches []string
+	sent    chan struct{}
+}
+
+func (s *sentBatches) send(batch *httpBatch) error {
+	s.mu.Lock()
+	s.batches = append(s.batches, batch.body.String())
+	s.mu.Unlock()
+	if s.sent != nil {
+		s.sent <- struct{}{}
+	}
+	return nil
+}
+
+func (s *sentBatches) get() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.batches...)
+}
+
+func TestBulkSenderBatching(t *testing.T) {
+	tests := []struct {
+		name       string
+		batchSize  int
+		batchBytes int
+		records    []string
+		want       []string
+	}{
+		{
+			name:      "count",
+			batchSize: 2,
+			records:   []string{"a", "b", "c", "d", "e"},
+			want:      []string{"a\nb\n", "c\nd\n", "e\n"},
+		},
+		{
+			name:       "size",
+			batchSize:  100,
+			batchBytes: 8,
+			records:    []string{"aaa", "bbb", "cc", "d", "ee"},
+			want:       []string{"aaa\nbbb\n", "cc\nd\nee\n"},
+		},
+		{
+			name:       "record larger than a batch",
+			batchSize:  100,
+			batchBytes: 4,
+			records:    []string{"a", "bbbbbbbb", "c"},
+			want:       []string{"a\n", "bbbbbbbb\n", "c\n"},
+		},
+		{
+			name:       "count before size",
+			batchSize:  2,
+			batchBytes: 100,
+			records:    []string{"a", "b", "c"},
+			want:       []string{"a\nb\n", "c\n"},
+		},
+		{
+			name:      "nothing to send",
+			batchSize: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		s := &sentBatches{}
+		b := newBulkSender(HttpConfig{BatchSize: tt.batchSize, BatchBytes: tt.batchBytes}, []byte("\n"), s.send)
+		for _, r := range tt.records {
+			b.add([]byte(r))
+		}
+		// The last batch is only sent on close
+		b.close()
+		if got := s.get(); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("%s: sent %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestBulkSenderInterval(t *testing.T) {
+	s := &sentBatches{sent: make(chan struct{}, 2)}
+	b := newBulkSender(HttpConfig{BatchSize: 100, FlushInterval: 10 * time.Millisecond}, []byte("\n"), s.send)
+	defer b.close()
+
+	b.add([]byte("a"))
+	select {
+	case <-s.sent:
+	case <-time.After(5 * time.Second):
+		t.Fatal("batch not sent after the flush interval")
+	}
+	b.add([]byte("b"))
+	select {
+	case <-s.sent:
+	case <-time.After(5 * time.Second):
+		t.Fatal("second batch not sent after the flush interval")
+	}
+	if got := s.get(); fmt.Sprint(got) != fmt.Sprint([]string{"a\n", "b\n"}) {
+		t.Errorf("sent %q", got)
+	}
+}
+
+// mockBulkServer answers requests with the given statuses in turn, and then
+// with 200, recording the bodies and the times of the requests.
+type mockBulkServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	statuses []int
+	bodies   []string
+	times    []time.Time
+	types    []string
+}
+
+func newMockBulkServer(t *testing.T, statuses ...int) *mockBulkServer {
+	t.Helper()
+	m := &mockBulkServer{statuses: statuses}
+	m.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		m.bodies = append(m.bodies, string(body))
+		m.times = append(m.times, time.Now())
+		m.types = append(m.types, r.Header.Get("Content-Type"))
+		status := http.StatusOK
+		if len(m.statuses) > 0 {
+			status, m.statuses = m.statuses[0], m.statuses[1:]
+		}
+		w.WriteHeader(status)
+		fmt.Fprintf(w, "status %d", status)
+	}))
+	t.Cleanup(m.Close)
+	return m
+}
+
+// newHttpTest returns an http output posting to a server with the given
+// configuration, which stays in place until the test ends.
+func newHttpTest(t *testing.T, format string, config HttpConfig, server *mockBulkServer) Output {
+	t.Helper()
+	http := Http
+	Http = config
+	Http.Url = server.URL + "/?query=INSERT+INTO+dns+FORMAT+JSONEachRow"
+	t.Cleanup(func() { Http = http })
+
+	out, err := newHttpOutput(format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestHttpRetry(t *testing.T) {
+	backoff := 20 * time.Millisecond
+	tests := []struct {
+		name     string
+		statuses []int
+		requests int
+		failed   uint64
+	}{
+		{"success", nil, 1, 0},
+		{"throttled", []int{http.StatusTooManyRequests}, 2, 0},
+		{"server errors", []int{http.StatusServiceUnavailable, http.StatusInternalServerError}, 3, 0},
+		{"retries exhausted", []int{500, 502, 503, 504}, 3, 2},
+		{"client error", []int{http.StatusBadRequest}, 1, 2},
+		{"client error after retry", []int{http.StatusServiceUnavailable, http.StatusNotFound}, 2, 2},
+	}
+
+	deliveryErrors.Store(0)
+	defer deliveryErrors.Store(0)
+	for _, tt := range tests {
+		server := newMockBulkServer(t, tt.statuses...)
+		out := newHttpTest(t, "json", HttpConfig{BatchSize: 2, MaxRetries: 2, RetryBackoff: backoff}, server)
+		deliveryErrors.Store(0)
+		out.Write(nil, []byte(`{"qname":"a."}`))
+		out.Write(nil, []byte(`{"qname":"b."}`))
+		out.Close()
+
+		if len(server.bodies) != tt.requests {
+			t.Errorf("%s: %d requests, want %d", tt.name, len(server.bodies), tt.requests)
+		}
+		for i, body := range server.bodies {
+			if body != "{\"qname\":\"a.\"}\n{\"qname\":\"b.\"}\n" {
+				t.Errorf("%s: request %d sent %q", tt.name, i, body)
+			}
+			if server.types[i] != "application/x-ndjson" {
+				t.Errorf("%s: request %d has content type %s", tt.name, i, server.types[i])
+			}
+		}
+		// The delay doubles after every attempt
+		for i := 1; i < len(server.times); i++ {
+			if wait, min := server.times[i].Sub(server.times[i-1]), backoff<<(i-1); wait < min {
+				t.Errorf("%s: retry %d after %v, want at least %v", tt.name, i, wait, min)
+			}
+		}
+		if DeliveryErrors() != tt.failed {
+			t.Errorf("%s: %d delivery errors, want %d", tt.name, DeliveryErrors(), tt.failed)
+		}
+	}
+}
+
+func TestCheckResponse(t *testing.T) {
+	for status, permanent := range map[int]bool{
+		200: false,
+		204: false,
+		400: true,
+		401: true,
+		404: true,
+		413: true,
+		429: false,
+		500: false,
+		503: false,
+	} {
+		resp := &http.Response{
+			StatusCode: status,
+			Status:     strconv.Itoa(status) + " " + http.StatusText(status),
+			Body:       io.NopCloser(strings.NewReader("error")),
+			Request:    httptest.NewRequest("POST", "http://localhost/", nil),
+		}
+		err := checkResponse(resp)
+		if (err == nil) != (status < 300) {
+			t.Errorf("status %d: error %v", status, err)
+		}
+		if _, ok := err.(permanentError); ok != permanent {
+			t.Errorf("status %d: permanent %v, want %v", status, ok, permanent)
+		}
+	}
+}
+
+// clickHouseColumn matches a column of the statement from ClickHouseSchema.
+var clickHouseColumn = regexp.MustCompile("^ *`([a-z_0-9]+)` (Nullable\\()?([A-Za-z0-9]+)\\)?,?$")
+
+// decodeRowBinary reads a row from the RowBinary stream by the columns of
+// ClickHouseSchema and returns the value of every column, formatted as by
+// formatField, with ok false for nulls.
+func decodeRowBinary(t *testing.T, r *bytes.Reader) (map[string]string, map[string]bool) {
+	t.Helper()
+	values := make(map[string]string)
+	present := make(map[string]bool)
+	for _, line := range strings.Split(ClickHouseSchema("dns"), "\n") {
+		m := clickHouseColumn.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		name, nullable, typ := m[1], m[2] != "", m[3]
+		if nullable {
+			null, err := r.ReadByte()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if null == 1 {
+				present[name] = false
+				continue
+			}
+		}
+
+		var err error
+		switch typ {
+		case "Bool":
+			var v bool
+			err = binary.Read(r, binary.LittleEndian, &v)
+			values[name] = strconv.FormatBool(v)
+		case "String":
+			var n uint64
+			n, err = binary.ReadUvarint(r)
+			b := make([]byte, n)
+			if err == nil {
+				_, err = io.ReadFull(r, b)
+			}
+			values[name] = string(b)
+		case "Int8", "Int16", "Int32", "Int64":
+			bits, _ := strconv.Atoi(strings.TrimPrefix(typ, "Int"))
+			var v uint64
+			v, err = readLittleEndian(r, bits/8)
+			values[name] = strconv.FormatInt(int64(v<<(64-bits))>>(64-bits), 10)
+		case "UInt8", "UInt16", "UInt32", "UInt64":
+			bits, _ := strconv.Atoi(strings.TrimPrefix(typ, "UInt"))
+			var v uint64
+			v, err = readLittleEndian(r, bits/8)
+			values[name] = strconv.FormatUint(v, 10)
+		default:
+			t.Fatalf("column %s has unknown type %s", name, typ)
+		}
+		if err != nil {
+			t.Fatalf("reading %s: %v", name, err)
+		}
+		present[name] = true
+	}
+	return values, present
+}
+
+func readLittleEndian(r io.Reader, n int) (uint64, error) {
+	b := make([]byte, 8)
+	if _, err := io.ReadFull(r, b[:n]); err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint64(b), nil
+}
+
+func TestRowBinaryLayout(t *testing.T) {
+	records := arrowTestRecords()
+	records[0].Rcode = -2
+	records[0].Timestamp = -1714564800
+
+	var stream []byte
+	for _, d := range records {
+		row, err := rowBinaryRecord(d)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stream = append(stream, row...)
+	}
+
+	r := bytes.NewReader(stream)
+	for i, d := range records {
+		values, present := decodeRowBinary(t, r)
+		if len(present) != len(Fields) {
+			t.Fatalf("row %d: %d columns, want %d", i, len(present), len(Fields))
+		}
+		v := recordValue(d)
+		for _, f := range Fields {
+			want, ok := formatField(v, f)
+			if present[f.name] != ok || values[f.name] != want {
+				t.Errorf("row %d: %s = %q (%v), want %q (%v)", i, f.name, values[f.name], present[f.name], want, ok)
+			}
+		}
+	}
+	if r.Len() != 0 {
+		t.Errorf("%d bytes left after the rows", r.Len())
+	}
+}
+
+func TestHttpRowBinary(t *testing.T) {
+	server := newMockBulkServer(t)
+	out := newHttpTest(t, "rowbinary", HttpConfig{BatchSize: 100}, server)
+
+	var want []byte
+	for _, d := range arrowTestRecords() {
+		row, _ := rowBinaryRecord(d)
+		want = append(want, row...)
+		out.Write(d, row)
+	}
+	out.Close()
+
+	// Rows are sent without separators
+	if len(server.bodies) != 1 || server.bodies[0] != string(want) {
+		t.Errorf("sent %q, want %q", server.bodies, want)
+	}
+	if len(server.types) == 1 && server.types[0] != "application/octet-stream" {
+		t.Errorf("content type %s", server.types[0])
+	}
+}
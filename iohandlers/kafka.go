@@ -0,0 +1,161 @@
+package iohandlers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+func init() {
+	Outputs["kafka"] = newKafkaOutput
+}
+
+// A KafkaConfig holds the options for the kafka output.
+type KafkaConfig struct {
+	// Brokers are the seed brokers used to discover the cluster.
+	Brokers []string
+	// Topic is the topic records are produced to. The placeholders {sensor}
+	// and {source} are replaced with the fields of each record.
+	Topic string
+	// PartitionBy selects the record key used to pick a partition: "client"
+	// for the client IP address, "qname", or "" to spread records evenly.
+	PartitionBy string
+	// BatchBytes is the maximum size of a batch sent to a partition.
+	BatchBytes int
+	// Linger is how long to wait for a batch to fill before sending it.
+	Linger time.Duration
+	// Compression is one of "none", "gzip", "snappy", "lz4" or "zstd".
+	Compression string
+	// Acks is the number of acknowledgements required: "all", "leader" or
+	// "none".
+	Acks string
+}
+
+var (
+	Kafka = KafkaConfig{
+		Topic:       "rickybobby",
+		BatchBytes:  1000012,
+		Compression: "snappy",
+		Acks:        "all",
+	}
+
+	KafkaPartitioners = []string{"client", "qname"}
+	KafkaCompressions = []string{"none", "gzip", "snappy", "lz4", "zstd"}
+	KafkaAcks         = []string{"all", "leader", "none"}
+)
+
+type kafkaOutput struct {
+	client *kgo.Client
+	topic  func(*DnsSchema) string
+	key    func(*DnsSchema) []byte
+}
+
+// kafkaOptions translates the configuration into client options.
+func kafkaOptions(config KafkaConfig) ([]kgo.Opt, error) {
+	if len(config.Brokers) == 0 {
+		return nil, fmt.Errorf("no Kafka brokers given")
+	}
+
+	opts := []kgo.Opt{
+		kgo.SeedBrokers(config.Brokers...),
+		kgo.ProducerBatchMaxBytes(int32(config.BatchBytes)),
+		kgo.ProducerLinger(config.Linger),
+	}
+
+	switch config.Compression {
+	case "none":
+		opts = append(opts, kgo.ProducerBatchCompression(kgo.NoCompression()))
+	case "gzip":
+		opts = append(opts, kgo.ProducerBatchCompression(kgo.GzipCompression()))
+	case "snappy":
+		opts = append(opts, kgo.ProducerBatchCompression(kgo.SnappyCompression()))
+	case "lz4":
+		opts = append(opts, kgo.ProducerBatchCompression(kgo.Lz4Compression()))
+	case "zstd":
+		opts = append(opts, kgo.ProducerBatchCompression(kgo.ZstdCompression()))
+	default:
+		return nil, fmt.Errorf("unknown Kafka compression %q", config.Compression)
+	}
+
+	// Idempotent writes require acknowledgement from all replicas
+	switch config.Acks {
+	case "all":
+		opts = append(opts, kgo.RequiredAcks(kgo.AllISRAcks()))
+	case "leader":
+		opts = append(opts, kgo.RequiredAcks(kgo.LeaderAck()), kgo.DisableIdempotentWrite())
+	case "none":
+		opts = append(opts, kgo.RequiredAcks(kgo.NoAck()), kgo.DisableIdempotentWrite())
+	default:
+		return nil, fmt.Errorf("unknown Kafka acks %q", config.Acks)
+	}
+
+	return opts, nil
+}
+
+// kafkaTopic returns a function expanding the topic template for a record.
+func kafkaTopic(template string) func(*DnsSchema) string {
+	if !strings.Contains(template, "{") {
+		return func(*DnsSchema) string { return template }
+	}
+	return func(d *DnsSchema) string {
+		topic := strings.ReplaceAll(template, "{sensor}", d.Sensor)
+		return strings.ReplaceAll(topic, "{source}", d.Source)
+	}
+}
+
+// kafkaKey returns a function computing the partitioning key of a record.
+func kafkaKey(partitionBy string) (func(*DnsSchema) []byte, error) {
+	switch partitionBy {
+	case "":
+		return func(*DnsSchema) []byte { return nil }, nil
+	case "client":
+		return func(d *DnsSchema) []byte {
+			if d.Response {
+				return []byte(d.DestinationAddress)
+			}
+			return []byte(d.SourceAddress)
+		}, nil
+	case "qname":
+		return func(d *DnsSchema) []byte {
+			return []byte(strings.ToLower(d.Qname))
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown Kafka partitioning %q", partitionBy)
+}
+
+func newKafkaOutput(string) (Output, error) {
+	opts, err := kafkaOptions(Kafka)
+	if err != nil {
+		return nil, err
+	}
+	key, err := kafkaKey(Kafka.PartitionBy)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := kgo.NewClient(opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &kafkaOutput{client: client, topic: kafkaTopic(Kafka.Topic), key: key}, nil
+}
+
+// Write queues a record for delivery. It blocks while the producer buffer
+// is full, which slows down parsing instead of dropping records.
+func (k *kafkaOutput) Write(d *DnsSchema, record []byte) error {
+	r := &kgo.Record{Topic: k.topic(d), Key: k.key(d), Value: record}
+	k.client.Produce(context.Background(), r, func(_ *kgo.Record, err error) {
+		if err != nil {
+			deliveryFailed(1, err)
+		}
+	})
+	return nil
+}
+
+func (k *kafkaOutput) Close() error {
+	defer k.client.Close()
+	return k.client.Flush(context.Background())
+}
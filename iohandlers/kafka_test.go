@@ -0,0 +1,195 @@
+package iohandlers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kerr"
+	"github.com/twmb/franz-go/pkg/kfake"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/twmb/franz-go/pkg/kmsg"
+)
+
+// newKafkaTest starts a fake cluster with the given topics and returns a
+// kafka output producing to it.
+func newKafkaTest(t *testing.T, config KafkaConfig, topics ...string) (*kfake.Cluster, Output) {
+	t.Helper()
+	cluster, err := kfake.NewCluster(kfake.NumBrokers(1), kfake.SeedTopics(2, topics...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(cluster.Close)
+
+	kafka := Kafka
+	Kafka = config
+	Kafka.Brokers = cluster.ListenAddrs()
+	defer func() { Kafka = kafka }()
+
+	out, err := newKafkaOutput("json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cluster, out
+}
+
+// consumeKafka reads a number of records from the topics of a cluster.
+func consumeKafka(t *testing.T, cluster *kfake.Cluster, n int, topics ...string) []*kgo.Record {
+	t.Helper()
+	client, err := kgo.NewClient(
+		kgo.SeedBrokers(cluster.ListenAddrs()...),
+		kgo.ConsumeTopics(topics...),
+		kgo.ConsumeResetOffset(kgo.NewOffset().AtStart()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var records []*kgo.Record
+	for len(records) < n {
+		fetches := client.PollFetches(ctx)
+		if ctx.Err() != nil {
+			t.Fatalf("consumed %d records, want %d", len(records), n)
+		}
+		if errs := fetches.Errors(); len(errs) > 0 {
+			t.Fatalf("consuming: %v", errs[0].Err)
+		}
+		records = append(records, fetches.Records()...)
+	}
+	return records
+}
+
+func TestKafkaTopic(t *testing.T) {
+	tests := []struct {
+		template string
+		d        DnsSchema
+		want     string
+	}{
+		{"rickybobby", DnsSchema{Sensor: "s1", Source: "live"}, "rickybobby"},
+		{"dns.{sensor}", DnsSchema{Sensor: "s1", Source: "live"}, "dns.s1"},
+		{"dns.{sensor}.{source}", DnsSchema{Sensor: "s1", Source: "live"}, "dns.s1.live"},
+		{"{source}-{source}", DnsSchema{Source: "live"}, "live-live"},
+		{"dns.{sensor}", DnsSchema{}, "dns."},
+	}
+
+	for _, tt := range tests {
+		if got := kafkaTopic(tt.template)(&tt.d); got != tt.want {
+			t.Errorf("kafkaTopic(%q) = %q, want %q", tt.template, got, tt.want)
+		}
+	}
+}
+
+func TestKafkaKey(t *testing.T) {
+	query := &DnsSchema{SourceAddress: "192.0.2.1", DestinationAddress: "198.51.100.53", Qname: "WWW.Example.com."}
+	response := &DnsSchema{SourceAddress: "198.51.100.53", DestinationAddress: "192.0.2.1", Response: true, Qname: "www.example.com."}
+
+	tests := []struct {
+		partitionBy string
+		d           *DnsSchema
+		want        string
+	}{
+		{"", query, ""},
+		{"client", query, "192.0.2.1"},
+		{"client", response, "192.0.2.1"},
+		{"qname", query, "www.example.com."},
+		{"qname", response, "www.example.com."},
+	}
+
+	for _, tt := range tests {
+		key, err := kafkaKey(tt.partitionBy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(key(tt.d)); got != tt.want {
+			t.Errorf("kafkaKey(%q) = %q, want %q", tt.partitionBy, got, tt.want)
+		}
+	}
+	if _, err := kafkaKey("server"); err == nil {
+		t.Error("kafkaKey(\"server\") succeeded")
+	}
+}
+
+func TestKafkaOutput(t *testing.T) {
+	topics := []string{"dns.s1", "dns.s2"}
+	// The batches only leave the producer when the output is closed
+	config := Kafka
+	config.Topic = "dns.{sensor}"
+	config.PartitionBy = "client"
+	config.Linger = time.Minute
+	cluster, out := newKafkaTest(t, config, topics...)
+
+	records := []*DnsSchema{
+		{SourceAddress: "192.0.2.1", Qname: "www.example.com.", Sensor: "s1"},
+		{SourceAddress: "198.51.100.53", DestinationAddress: "192.0.2.2", Response: true, Qname: "www.example.com.", Sensor: "s2"},
+		{SourceAddress: "192.0.2.3", Qname: "www.example.org.", Sensor: "s1"},
+	}
+	for _, d := range records {
+		if err := out.Write(d, []byte(d.Qname)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := out.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	got := make(map[string]*kgo.Record)
+	for _, r := range consumeKafka(t, cluster, len(records), topics...) {
+		got[string(r.Key)] = r
+	}
+	for _, tt := range []struct{ key, topic, value string }{
+		{"192.0.2.1", "dns.s1", "www.example.com."},
+		{"192.0.2.2", "dns.s2", "www.example.com."},
+		{"192.0.2.3", "dns.s1", "www.example.org."},
+	} {
+		r, ok := got[tt.key]
+		switch {
+		case !ok:
+			t.Errorf("no record with key %s", tt.key)
+		case r.Topic != tt.topic || string(r.Value) != tt.value:
+			t.Errorf("record with key %s is %q in %s, want %q in %s", tt.key, r.Value, r.Topic, tt.value, tt.topic)
+		}
+	}
+	if DeliveryErrors() != 0 {
+		t.Errorf("%d delivery errors, want 0", DeliveryErrors())
+	}
+}
+
+func TestKafkaDeliveryFailed(t *testing.T) {
+	config := Kafka
+	config.Topic = "rejected"
+	cluster, out := newKafkaTest(t, config, "rejected")
+
+	// The broker refuses every batch
+	cluster.ControlKey(int16(kmsg.Produce), func(req kmsg.Request) (kmsg.Response, error, bool) {
+		cluster.KeepControl()
+		produce := req.(*kmsg.ProduceRequest)
+		resp := produce.ResponseKind().(*kmsg.ProduceResponse)
+		for _, topic := range produce.Topics {
+			rt := kmsg.NewProduceResponseTopic()
+			rt.Topic = topic.Topic
+			for _, partition := range topic.Partitions {
+				rp := kmsg.NewProduceResponseTopicPartition()
+				rp.Partition = partition.Partition
+				rp.ErrorCode = kerr.TopicAuthorizationFailed.Code
+				rt.Partitions = append(rt.Partitions, rp)
+			}
+			resp.Topics = append(resp.Topics, rt)
+		}
+		return resp, nil, true
+	})
+
+	deliveryErrors.Store(0)
+	defer deliveryErrors.Store(0)
+	for i := 0; i < 3; i++ {
+		if err := out.Write(&DnsSchema{}, []byte("record")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	out.Close()
+	if DeliveryErrors() != 3 {
+		t.Errorf("%d delivery errors, want 3", DeliveryErrors())
+	}
+}
@@ -0,0 +1,78 @@
+package iohandlers
+
+import (
+	"sync/atomic"
+
+	"github.com/rs/zerolog/log"
+)
+
+// An Output delivers encoded records to a destination other than standard
+// output, such as a message broker.
+type Output interface {
+	// Write delivers a single encoded record. The record may be retained
+	// until Close returns, so it must not be modified by the caller.
+	Write(d *DnsSchema, record []byte) error
+	// Close delivers any buffered records and releases the output.
+	Close() error
+}
+
+var (
+	// Outputs holds the available outputs in addition to standard output.
+	// Each is created with the name of the format its records are encoded in.
+	Outputs = make(map[string]func(format string) (Output, error))
+	// Encoders holds the formats that can encode a single record on its own,
+	// which are the formats that can be used with Outputs.
+	Encoders = make(map[string]func(*DnsSchema) ([]byte, error))
+	// OutputName selects where records are written.
+	OutputName = "stdout"
+
+	output         Output
+	deliveryErrors atomic.Uint64
+)
+
+// DeliveryErrors returns the number of records that could not be delivered
+// to the output since it was initialized.
+func DeliveryErrors() uint64 {
+	return deliveryErrors.Load()
+}
+
+// deliveryFailed records a number of records that could not be delivered.
+func deliveryFailed(records int, err error) {
+	deliveryErrors.Add(uint64(records))
+	log.Error().Msgf("Error delivering %d record(s): %v", records, err)
+}
+
+func initializeOutput(format string) {
+	deliveryErrors.Store(0)
+	if OutputName == "stdout" {
+		output = nil
+		return
+	}
+
+	var err error
+	output, err = Outputs[OutputName](format)
+	if err != nil {
+		log.Fatal().Msgf("Error creating %s output: %v", OutputName, err)
+	}
+}
+
+func writeOutput(d *DnsSchema, format string) {
+	record, err := Encoders[format](d)
+	if err != nil {
+		log.Warn().Msgf("Error encoding record: %v", err)
+		return
+	}
+	if err := output.Write(d, record); err != nil {
+		deliveryFailed(1, err)
+	}
+}
+
+func closeOutput() {
+	if output == nil {
+		return
+	}
+	if err := output.Close(); err != nil {
+		log.Warn().Msgf("Error closing %s output: %v", OutputName, err)
+	}
+	output = nil
+}
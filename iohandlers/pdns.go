@@ -0,0 +1,239 @@
+package iohandlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/rs/zerolog/log"
+)
+
+func init() {
+	Aggregators["pdns"] = newPdnsAggregator
+	aggregateRecordTypes["pdns"] = reflect.TypeOf(PdnsSchema{})
+}
+
+// The pdns aggregation turns the answers of responses into passive DNS
+// records, counting how often each RR was seen and when it was first and
+// last seen. The records follow the Passive DNS Common Output Format (COF)
+// of draft-dulaunoy-dnsop-passive-dns-cof. The json format writes them as
+// COF, and the other formats write the same fields.
+//
+// The table of RRs is flushed at the end of every window, and early if it
+// grows too large, so an RR may be reported again with the counts of the
+// next window. Consumers merge the records of an RR by taking the earliest
+// time_first, the latest time_last and the sum of the counts.
+
+// A PdnsConfig holds the options for the pdns aggregation.
+type PdnsConfig struct {
+	// Window is the length of the tumbling windows, aligned to the epoch,
+	// at the end of which the table is flushed.
+	Window time.Duration
+	// MaxMemory is the estimated size in bytes the table may grow to before
+	// it is flushed early.
+	MaxMemory int
+}
+
+// pdnsEntryOverhead estimates the memory used by an entry of the table in
+// addition to its strings.
+const pdnsEntryOverhead = 160
+
+var Pdns = PdnsConfig{
+	Window:    time.Hour,
+	MaxMemory: 256 << 20,
+}
+
+type pdnsKey struct {
+	rrname    string
+	rrtype    uint16
+	rdata     string
+	bailiwick string
+	sensor    string
+}
+
+type pdnsEntry struct {
+	first, last int64
+	count       uint64
+}
+
+// A PdnsSchema is a record in the Passive DNS Common Output Format.
+type PdnsSchema struct {
+	Rrname    string `json:"rrname"`
+	Rrtype    string `json:"rrtype"`
+	Rdata     string `json:"rdata"`
+	TimeFirst int64  `json:"time_first"`
+	TimeLast  int64  `json:"time_last"`
+	Count     uint64 `json:"count"`
+	Bailiwick string `json:"bailiwick,omitempty"`
+	SensorId  string `json:"sensor_id,omitempty"`
+}
+
+type pdnsAggregator struct {
+	format  string
+	entries map[pdnsKey]*pdnsEntry
+	memory  int
+	// The start of the current window
+	window time.Time
+}
+
+func newPdnsAggregator(format string) (Aggregator, error) {
+	if err := checkSummaryFormat(format); err != nil {
+		return nil, err
+	}
+	return &pdnsAggregator{format: format, entries: make(map[pdnsKey]*pdnsEntry)}, nil
+}
+
+// pdnsName returns a name as it is written in COF, in lowercase and without
+// the trailing dot.
+func pdnsName(name string) string {
+	if len(name) > 1 {
+		name = strings.TrimSuffix(name, ".")
+	}
+	return strings.ToLower(name)
+}
+
+// pdnsBailiwick returns the zone the server showed itself authoritative for
+// when answering with an RR: the closest enclosing zone of the owner of the
+// RR with an NS or SOA RR in the authority section. It is empty when the
+// response doesn't tell.
+func pdnsBailiwick(msg *dns.Msg, name string) string {
+	if msg == nil {
+		return ""
+	}
+
+	bailiwick, labels := "", -1
+	for _, rr := range msg.Ns {
+		h := rr.Header()
+		if h.Rrtype != dns.TypeNS && h.Rrtype != dns.TypeSOA {
+			continue
+		}
+		if dns.IsSubDomain(h.Name, name) && dns.CountLabel(h.Name) > labels {
+			bailiwick, labels = h.Name, dns.CountLabel(h.Name)
+		}
+	}
+	if labels < 0 {
+		return ""
+	}
+	return pdnsName(bailiwick)
+}
+
+// Add counts the RR of an answer record.
+func (a *pdnsAggregator) Add(d *DnsSchema) {
+	if !d.Response || !d.Answer || d.Rtype == nil {
+		return
+	}
+
+	t := d.Time
+	if t.IsZero() {
+		t = time.Unix(d.Timestamp, 0)
+	}
+	// Packets arriving slightly out of order are counted in the current
+	// window
+	a.Tick(t)
+
+	key := pdnsKey{
+		rrname:    pdnsName(*d.Rname),
+		rrtype:    *d.Rtype,
+		rdata:     *d.Rdata,
+		bailiwick: pdnsBailiwick(d.Msg, *d.Rname),
+		sensor:    d.Sensor,
+	}
+	if len(key.rdata) > 1 {
+		key.rdata = strings.TrimSuffix(key.rdata, ".")
+	}
+
+	if e, ok := a.entries[key]; ok {
+		e.count++
+		if t.Unix() < e.first {
+			e.first = t.Unix()
+		}
+		if t.Unix() > e.last {
+			e.last = t.Unix()
+		}
+		return
+	}
+
+	a.entries[key] = &pdnsEntry{first: t.Unix(), last: t.Unix(), count: 1}
+	a.memory += len(key.rrname) + len(key.rdata) + len(key.bailiwick) + len(key.sensor) + pdnsEntryOverhead
+	if a.memory > Pdns.MaxMemory {
+		log.Debug().Msgf("Flushing %d passive DNS records early", len(a.entries))
+		a.flush()
+	}
+}
+
+// Tick flushes the table once the window has ended at t.
+func (a *pdnsAggregator) Tick(t time.Time) {
+	if Pdns.Window <= 0 {
+		return
+	}
+	if w := t.Truncate(Pdns.Window); w.After(a.window) {
+		a.flush()
+		a.window = w
+	}
+}
+
+// flush emits the records of the table, ordered by when they were first
+// seen, and empties it.
+func (a *pdnsAggregator) flush() {
+	keys := make([]pdnsKey, 0, len(a.entries))
+	for key := range a.entries {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		ki, kj := keys[i], keys[j]
+		if fi, fj := a.entries[ki].first, a.entries[kj].first; fi != fj {
+			return fi < fj
+		}
+		if ki.rrname != kj.rrname {
+			return ki.rrname < kj.rrname
+		}
+		if ki.rrtype != kj.rrtype {
+			return ki.rrtype < kj.rrtype
+		}
+		return ki.rdata < kj.rdata
+	})
+
+	for _, key := range keys {
+		e := a.entries[key]
+		r := &PdnsSchema{
+			Rrname:    key.rrname,
+			Rrtype:    dns.Type(key.rrtype).String(),
+			Rdata:     key.rdata,
+			TimeFirst: e.first,
+			TimeLast:  e.last,
+			Count:     e.count,
+			Bailiwick: key.bailiwick,
+			SensorId:  key.sensor,
+		}
+
+		// Outputs see the record as the RR it counts, identified by the
+		// RR and the start of its counts
+		id := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%d\x00%s\x00%s\x00%s\x00%d",
+			key.rrname, key.rrtype, key.rdata, key.bailiwick, key.sensor, e.first)))
+		rrname, rdata, rrtype := key.rrname, key.rdata, key.rrtype
+		emitRecord(&DnsSchema{
+			Timestamp: e.last,
+			Sha256:    hex.EncodeToString(id[:]),
+			Response:  true,
+			Qname:     rrname,
+			Rname:     &rrname,
+			Rtype:     &rrtype,
+			Rdata:     &rdata,
+			Sensor:    key.sensor,
+			RrIndex:   -1,
+			Summary:   r,
+		}, a.format)
+	}
+
+	a.entries = make(map[pdnsKey]*pdnsEntry)
+	a.memory = 0
+}
+
+func (a *pdnsAggregator) Close() {
+	a.flush()
+}
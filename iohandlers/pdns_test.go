@@ -0,0 +1,162 @@
+package iohandlers
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+var pdnsStart = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+// pdnsAnswer returns the record of an answer RR of a response seen at the
+// given offset from pdnsStart.
+func pdnsAnswer(t *testing.T, rr string, offset time.Duration, msg *dns.Msg) *DnsSchema {
+	t.Helper()
+	r := mustRR(t, rr)
+	rname, rtype := r.Header().Name, r.Header().Rrtype
+	rdata := strings.TrimPrefix(r.String(), r.Header().String())
+	return &DnsSchema{
+		Time:     pdnsStart.Add(offset),
+		Response: true,
+		Answer:   true,
+		Qname:    rname,
+		Rname:    &rname,
+		Rtype:    &rtype,
+		Rdata:    &rdata,
+		Sensor:   "ns1",
+		Msg:      msg,
+	}
+}
+
+func TestPdnsMerge(t *testing.T) {
+	a := newAggregateTest(t, "pdns", "json")
+	for _, offset := range []time.Duration{10 * time.Second, 5 * time.Second, 20 * time.Second} {
+		aggregator.Add(pdnsAnswer(t, "WWW.Example.com. 300 IN A 192.0.2.1", offset, nil))
+	}
+	aggregator.Add(pdnsAnswer(t, "www.example.com. 300 IN A 192.0.2.2", 30*time.Second, nil))
+	aggregator.Add(pdnsAnswer(t, "www.example.com. 300 IN CNAME web.example.com.", 2*time.Second, nil))
+	// Questions and other sections aren't counted
+	query := pdnsAnswer(t, "www.example.com. 300 IN A 192.0.2.1", 0, nil)
+	query.Response = false
+	aggregator.Add(query)
+	additional := pdnsAnswer(t, "www.example.com. 300 IN A 192.0.2.1", 0, nil)
+	additional.Answer, additional.Additional = false, true
+	aggregator.Add(additional)
+
+	// The records are ordered by when they were first seen, and an RR seen
+	// out of order moves time_first back
+	want := []string{
+		`{"rrname":"www.example.com","rrtype":"CNAME","rdata":"web.example.com","time_first":1714564802,"time_last":1714564802,"count":1,"sensor_id":"ns1"}`,
+		`{"rrname":"www.example.com","rrtype":"A","rdata":"192.0.2.1","time_first":1714564805,"time_last":1714564820,"count":3,"sensor_id":"ns1"}`,
+		`{"rrname":"www.example.com","rrtype":"A","rdata":"192.0.2.2","time_first":1714564830,"time_last":1714564830,"count":1,"sensor_id":"ns1"}`,
+	}
+	if got := a.close(); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("wrote\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestPdnsWindowRollover(t *testing.T) {
+	window := Pdns.Window
+	Pdns.Window = time.Hour
+	defer func() { Pdns.Window = window }()
+
+	a := newAggregateTest(t, "pdns", "csv", "rrname", "rdata", "time_first", "time_last", "count")
+	aggregator.Add(pdnsAnswer(t, "www.example.com. 300 IN A 192.0.2.1", time.Minute, nil))
+	aggregator.Add(pdnsAnswer(t, "www.example.com. 300 IN A 192.0.2.1", 59*time.Minute, nil))
+	Flushers["csv"]()
+	if got := a.lines(); len(got) != 1 {
+		t.Errorf("wrote %q before the window ended", got)
+	}
+
+	// A record of the next window ends the first
+	aggregator.Add(pdnsAnswer(t, "www.example.com. 300 IN A 192.0.2.1", 61*time.Minute, nil))
+	Flushers["csv"]()
+	want := []string{
+		"rrname,rdata,time_first,time_last,count",
+		"www.example.com,192.0.2.1,1714564860,1714568340,2",
+	}
+	if got := a.lines(); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("wrote %q at the end of the window, want %q", got, want)
+	}
+	// A late packet is counted in the current window
+	aggregator.Add(pdnsAnswer(t, "www.example.com. 300 IN A 192.0.2.1", 50*time.Minute, nil))
+
+	// The clock ends the second window without a record arriving, and the
+	// records are written out at once
+	Tick(pdnsStart.Add(119 * time.Minute))
+	if got := a.lines(); len(got) != len(want) {
+		t.Errorf("wrote %q before the second window ended", got)
+	}
+	Tick(pdnsStart.Add(2 * time.Hour))
+	want = append(want, "www.example.com,192.0.2.1,1714567800,1714568460,2")
+	if got := a.lines(); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("wrote %q after the tick, want %q", got, want)
+	}
+	if got := a.close(); len(got) != len(want) {
+		t.Errorf("wrote %q, with more records on close", got)
+	}
+}
+
+func TestPdnsFormats(t *testing.T) {
+	for _, format := range MessageFormats {
+		if _, err := newPdnsAggregator(format); err == nil {
+			t.Errorf("pdns aggregation accepted the %s format", format)
+		}
+	}
+	for _, format := range []string{"json", "csv", "avro", "msgpack"} {
+		if _, err := newPdnsAggregator(format); err != nil {
+			t.Errorf("%s format: %v", format, err)
+		}
+	}
+}
+
+func TestPdnsBailiwick(t *testing.T) {
+	tests := []struct {
+		name  string
+		ns    []string
+		owner string
+		want  string
+	}{
+		{"no message", nil, "www.example.com.", ""},
+		{"empty authority", []string{}, "www.example.com.", ""},
+		{"ns", []string{"example.com. 300 IN NS ns1.example.com."}, "www.example.com.", "example.com"},
+		{"soa", []string{"example.com. 300 IN SOA ns1.example.com. hostmaster.example.com. 1 7200 3600 1209600 300"}, "missing.example.com.", "example.com"},
+		{"zone apex", []string{"example.com. 300 IN NS ns1.example.com."}, "example.com.", "example.com"},
+		{"closest zone", []string{
+			"com. 300 IN NS a.gtld-servers.net.",
+			"sub.example.com. 300 IN NS ns1.sub.example.com.",
+			"example.com. 300 IN NS ns1.example.com.",
+		}, "www.sub.example.com.", "sub.example.com"},
+		{"case", []string{"EXAMPLE.com. 300 IN NS ns1.example.com."}, "www.example.COM.", "example.com"},
+		{"unrelated zone", []string{"example.org. 300 IN NS ns1.example.org."}, "www.example.com.", ""},
+		{"not a zone", []string{"example.com. 300 IN A 192.0.2.1"}, "www.example.com.", ""},
+		{"root", []string{". 300 IN NS a.root-servers.net."}, "www.example.com.", "."},
+	}
+
+	for _, tt := range tests {
+		var msg *dns.Msg
+		if tt.ns != nil {
+			msg = new(dns.Msg)
+			for _, rr := range tt.ns {
+				msg.Ns = append(msg.Ns, mustRR(t, rr))
+			}
+		}
+		if got := pdnsBailiwick(msg, tt.owner); got != tt.want {
+			t.Errorf("%s: bailiwick %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	// The bailiwick is part of what identifies an RR
+	a := newAggregateTest(t, "pdns", "csv", "rrname", "count", "bailiwick")
+	com := &dns.Msg{Ns: []dns.RR{mustRR(t, "com. 300 IN NS a.gtld-servers.net.")}}
+	example := &dns.Msg{Ns: []dns.RR{mustRR(t, "example.com. 300 IN NS ns1.example.com.")}}
+	aggregator.Add(pdnsAnswer(t, "www.example.com. 300 IN A 192.0.2.1", 0, example))
+	aggregator.Add(pdnsAnswer(t, "www.example.com. 300 IN A 192.0.2.1", time.Second, com))
+	aggregator.Add(pdnsAnswer(t, "www.example.com. 300 IN A 192.0.2.1", 2*time.Second, example))
+	want := []string{"rrname,count,bailiwick", "www.example.com,2,example.com", "www.example.com,1,com"}
+	if got := a.close(); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("wrote %q, want %q", got, want)
+	}
+}
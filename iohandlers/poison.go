@@ -0,0 +1,305 @@
+package iohandlers
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func init() {
+	Enrichments["poison"] = enrichPoison
+}
+
+// The poison enrichment raises alerts on the signs of cache poisoning and
+// spoofed responses, and adds no fields. It tracks the queries waiting for a
+// response and the responses seen for them, which tells when one query gets
+// several responses with different answers or a response with the wrong
+// transaction ID. Every response is checked for authority and additional
+// records outside the bailiwicks of the server for the names of its answer,
+// and for TTLs exceeding those first seen from the same server for the same
+// RRset.
+
+const (
+	// poisonQueryTimeout is how long a query waits for its responses.
+	poisonQueryTimeout = 10 * time.Second
+	// poisonMaxRRsets is the number of RRsets whose TTL is remembered. They
+	// are forgotten once there are more.
+	poisonMaxRRsets = 1 << 20
+)
+
+// A poisonExchange holds the queries and responses between a client and a
+// server for a name and type, on the same ports.
+type poisonExchange struct {
+	// The transaction IDs of the queries, which are only known when queries
+	// are parsed
+	queryIds []uint16
+	// The answers of the responses by transaction ID
+	answers map[uint16]string
+	time    time.Time
+}
+
+// A poisonRRset holds the TTL of an RRset when it was first seen.
+type poisonRRset struct {
+	ttl  uint32
+	time time.Time
+}
+
+var (
+	poisonExchanges = make(map[string]*poisonExchange)
+	poisonRRsets    = make(map[string]poisonRRset)
+	poisonSwept     time.Time
+	// The message whose records are being enriched
+	poisonSha256 string
+)
+
+// poisonExchangeKey identifies the exchange of a record by the client,
+// server, name and type.
+func poisonExchangeKey(d *DnsSchema) string {
+	client, clientPort := d.SourceAddress, d.SourcePort
+	server, serverPort := d.DestinationAddress, d.DestinationPort
+	if d.Response {
+		client, server = server, client
+		clientPort, serverPort = serverPort, clientPort
+	}
+	return fmt.Sprintf("%s|%d|%s|%d|%s|%d", client, clientPort, server, serverPort, strings.ToLower(d.Qname), d.Qtype)
+}
+
+// poisonAnswers returns the answer section of a message in a form that
+// compares equal for the same answers in any order and with any TTL.
+func poisonAnswers(msg *dns.Msg) string {
+	answers := make([]string, 0, len(msg.Answer))
+	for _, rr := range msg.Answer {
+		h := rr.Header()
+		rdata := strings.TrimPrefix(rr.String(), h.String())
+		answers = append(answers, fmt.Sprintf("%s %d %s", strings.ToLower(h.Name), h.Rrtype, strings.ToLower(rdata)))
+	}
+	sort.Strings(answers)
+	return strings.Join(answers, "\n")
+}
+
+// poisonChain returns the qname of a response and the names the CNAME and
+// DNAME records of its answer lead to from it.
+func poisonChain(d *DnsSchema) []string {
+	names := []string{dns.Fqdn(d.Qname)}
+	for i := 0; i < len(names); i++ {
+		for _, rr := range d.Msg.Answer {
+			var target string
+			switch rr := rr.(type) {
+			case *dns.CNAME:
+				if strings.EqualFold(rr.Hdr.Name, names[i]) {
+					target = rr.Target
+				}
+			case *dns.DNAME:
+				if dns.IsSubDomain(rr.Hdr.Name, names[i]) && !strings.EqualFold(rr.Hdr.Name, names[i]) {
+					target = rr.Target
+				}
+			}
+			if target != "" && !containsName(names, target) {
+				names = append(names, dns.Fqdn(target))
+			}
+		}
+	}
+	return names
+}
+
+func containsName(names []string, name string) bool {
+	for _, n := range names {
+		if strings.EqualFold(n, dns.Fqdn(name)) {
+			return true
+		}
+	}
+	return false
+}
+
+// poisonBailiwick returns the zone a response may hold authority and
+// additional records for on behalf of a name: the closest zone enclosing the
+// name with an NS or SOA record in the authority section, or the parent of
+// that zone for a referral. Without one, it is the registered domain of the
+// name.
+func poisonBailiwick(d *DnsSchema, name string) string {
+	zone, labels, referral := "", -1, false
+	for _, rr := range d.Msg.Ns {
+		h := rr.Header()
+		if h.Rrtype != dns.TypeNS && h.Rrtype != dns.TypeSOA {
+			continue
+		}
+		if dns.IsSubDomain(h.Name, name) && dns.CountLabel(h.Name) > labels {
+			zone, labels = h.Name, dns.CountLabel(h.Name)
+			referral = h.Rrtype == dns.TypeNS && len(d.Msg.Answer) == 0 && !d.Msg.Authoritative
+		}
+	}
+
+	if labels < 0 {
+		suffix, domain, _ := PublicSuffixes.Split(name)
+		if domain == "" {
+			return dns.Fqdn(suffix)
+		}
+		return dns.Fqdn(domain)
+	}
+	if referral {
+		if i, end := dns.NextLabel(zone, 0); !end {
+			return zone[i:]
+		}
+		return "."
+	}
+	return zone
+}
+
+// poisonBailiwicks returns the bailiwicks of a response, those of the qname
+// and of the names its CNAME and DNAME chain leads to, as the chain may pass
+// through zones of other servers.
+func poisonBailiwicks(d *DnsSchema) []string {
+	var bailiwicks []string
+	for _, name := range poisonChain(d) {
+		if b := poisonBailiwick(d, name); !containsName(bailiwicks, b) {
+			bailiwicks = append(bailiwicks, b)
+		}
+	}
+	return bailiwicks
+}
+
+// outOfBailiwick returns the authority and additional records of a response
+// whose owners aren't within any of its bailiwicks.
+func outOfBailiwick(d *DnsSchema, bailiwicks []string) []string {
+	var records []string
+RECORDS:
+	for _, rr := range append(append([]dns.RR(nil), d.Msg.Ns...), d.Msg.Extra...) {
+		h := rr.Header()
+		if h.Rrtype == dns.TypeOPT {
+			continue
+		}
+		for _, bailiwick := range bailiwicks {
+			if dns.IsSubDomain(bailiwick, h.Name) {
+				continue RECORDS
+			}
+		}
+		records = append(records, strings.Join(strings.Fields(rr.String()), " "))
+	}
+	return records
+}
+
+// poisonSweep forgets the exchanges past their timeout and the RRsets past
+// their TTL.
+func poisonSweep(now time.Time) {
+	if now.Sub(poisonSwept) < poisonQueryTimeout {
+		return
+	}
+	for key, e := range poisonExchanges {
+		if now.Sub(e.time) > poisonQueryTimeout {
+			delete(poisonExchanges, key)
+		}
+	}
+	for key, r := range poisonRRsets {
+		if now.Sub(r.time) > time.Duration(r.ttl)*time.Second {
+			delete(poisonRRsets, key)
+		}
+	}
+	poisonSwept = now
+}
+
+// enrichPoison looks for the signs of poisoning in the message of a record.
+// The records of a message arrive one after the other, so only the first of
+// them is looked at.
+func enrichPoison(d *DnsSchema) bool {
+	if d.Msg == nil || (d.Sha256 == poisonSha256 && d.Sha256 != "") {
+		return true
+	}
+	poisonSha256 = d.Sha256
+
+	t := d.Time
+	if t.IsZero() {
+		t = time.Unix(d.Timestamp, 0)
+	}
+	poisonSweep(t)
+
+	key := poisonExchangeKey(d)
+	e, ok := poisonExchanges[key]
+	if !ok {
+		e = &poisonExchange{answers: make(map[uint16]string)}
+		poisonExchanges[key] = e
+	}
+	e.time = t
+
+	if !d.Response {
+		e.queryIds = append(e.queryIds, d.Id)
+		return true
+	}
+
+	server, client := d.SourceAddress, d.DestinationAddress
+	alert := func(reason string, details map[string]interface{}) {
+		details["client"] = client
+		details["server"] = server
+		details["id"] = d.Id
+		emitAlert(d, &Alert{
+			Type:    "poisoning",
+			Domain:  registeredDomain(d),
+			Qname:   d.Qname,
+			Reason:  reason,
+			Details: details,
+		})
+	}
+
+	if len(e.queryIds) > 0 && !containsId(e.queryIds, d.Id) {
+		alert("Response with wrong transaction ID", map[string]interface{}{
+			"query_ids": e.queryIds,
+		})
+	}
+
+	answers := poisonAnswers(d.Msg)
+	if previous, ok := e.answers[d.Id]; ok && previous != answers {
+		alert("Differing responses to one query", map[string]interface{}{
+			"answers":          strings.Split(answers, "\n"),
+			"previous_answers": strings.Split(previous, "\n"),
+		})
+	} else if !ok {
+		e.answers[d.Id] = answers
+	}
+
+	bailiwicks := poisonBailiwicks(d)
+	if records := outOfBailiwick(d, bailiwicks); len(records) > 0 {
+		alert("Records out of bailiwick", map[string]interface{}{
+			"bailiwicks": bailiwicks,
+			"records":    records,
+		})
+	}
+
+	poisonTtls(d, t, alert)
+	return true
+}
+
+// poisonTtls compares the TTLs of the answers of a response to those first
+// seen from the same server, which caches only ever count down.
+func poisonTtls(d *DnsSchema, t time.Time, alert func(string, map[string]interface{})) {
+	for _, rr := range d.Msg.Answer {
+		h := rr.Header()
+		key := fmt.Sprintf("%s|%s|%d", d.SourceAddress, strings.ToLower(h.Name), h.Rrtype)
+		original, ok := poisonRRsets[key]
+		if !ok || t.Sub(original.time) > time.Duration(original.ttl)*time.Second {
+			if len(poisonRRsets) >= poisonMaxRRsets {
+				poisonRRsets = make(map[string]poisonRRset)
+			}
+			poisonRRsets[key] = poisonRRset{ttl: h.Ttl, time: t}
+			continue
+		}
+		if h.Ttl > original.ttl {
+			alert("TTL exceeds the original", map[string]interface{}{
+				"rrname":       h.Name,
+				"rrtype":       dns.TypeToString[h.Rrtype],
+				"ttl":          h.Ttl,
+				"original_ttl": original.ttl,
+			})
+		}
+	}
+}
+
+func containsId(ids []uint16, id uint16) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,111 @@
+package iohandlers
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestOutOfBailiwick(t *testing.T) {
+	tests := []struct {
+		name   string
+		qname  string
+		answer []string
+		ns     []string
+		extra  []string
+		// The owners of the records out of bailiwick
+		want []string
+	}{
+		{
+			name:  "in bailiwick",
+			qname: "www.example.com.",
+			answer: []string{
+				"www.example.com. 300 IN A 192.0.2.1",
+			},
+			ns:    []string{"example.com. 3600 IN NS ns1.example.com."},
+			extra: []string{"ns1.example.com. 3600 IN A 192.0.2.53"},
+		},
+		{
+			name:  "CNAME to another zone",
+			qname: "www.example.com.",
+			answer: []string{
+				"www.example.com. 300 IN CNAME www.example.com.edgekey.net.",
+				"www.example.com.edgekey.net. 300 IN CNAME e1234.a.akamaiedge.net.",
+				"e1234.a.akamaiedge.net. 20 IN A 192.0.2.1",
+			},
+			ns:    []string{"akamaiedge.net. 4000 IN NS n0a.akamaiedge.net."},
+			extra: []string{"n0a.akamaiedge.net. 4000 IN A 192.0.2.53"},
+		},
+		{
+			name:  "DNAME to another zone",
+			qname: "www.example.org.",
+			answer: []string{
+				"example.org. 300 IN DNAME example.net.",
+				"www.example.org. 300 IN CNAME www.example.net.",
+				"www.example.net. 300 IN A 192.0.2.1",
+			},
+			ns: []string{"example.net. 3600 IN NS ns1.example.net."},
+		},
+		{
+			name:  "records of another zone",
+			qname: "www.example.com.",
+			answer: []string{
+				"www.example.com. 300 IN A 192.0.2.1",
+			},
+			ns:    []string{"example.com. 3600 IN NS ns1.example.com.", "bank.example. 3600 IN NS ns.attacker.example."},
+			extra: []string{"ns.attacker.example. 3600 IN A 198.51.100.1"},
+			want:  []string{"bank.example.", "ns.attacker.example."},
+		},
+		{
+			name:  "CNAME outside the chain",
+			qname: "www.example.com.",
+			answer: []string{
+				"www.example.com. 300 IN A 192.0.2.1",
+				"mail.bank.example. 300 IN CNAME www.example.com.",
+			},
+			ns:   []string{"bank.example. 3600 IN NS ns.attacker.example."},
+			want: []string{"bank.example."},
+		},
+		{
+			name:  "referral",
+			qname: "www.example.com.",
+			ns:    []string{"example.com. 172800 IN NS a.iana-servers.net."},
+			extra: []string{"a.iana-servers.net. 172800 IN A 192.0.2.53"},
+			want:  []string{"a.iana-servers.net."},
+		},
+	}
+
+	for _, tt := range tests {
+		msg := new(dns.Msg)
+		msg.SetQuestion(tt.qname, dns.TypeA)
+		msg.Response = true
+		msg.Authoritative = len(tt.answer) > 0
+		for _, sections := range []struct {
+			rrs     []string
+			section *[]dns.RR
+		}{{tt.answer, &msg.Answer}, {tt.ns, &msg.Ns}, {tt.extra, &msg.Extra}} {
+			for _, s := range sections.rrs {
+				*sections.section = append(*sections.section, mustRR(t, s))
+			}
+		}
+
+		d := &DnsSchema{Qname: tt.qname, Response: true, Msg: msg}
+		var got []string
+		for _, record := range outOfBailiwick(d, poisonBailiwicks(d)) {
+			got = append(got, strings.Fields(record)[0])
+		}
+		if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+			t.Errorf("%s: records of %v out of bailiwicks %v, want %v", tt.name, got, poisonBailiwicks(d), tt.want)
+		}
+	}
+}
+
+func mustRR(t *testing.T, s string) dns.RR {
+	t.Helper()
+	rr, err := dns.NewRR(s)
+	if err != nil {
+		t.Fatalf("dns.NewRR(%q): %v", s, err)
+	}
+	return rr
+}
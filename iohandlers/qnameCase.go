@@ -0,0 +1,145 @@
+package iohandlers
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+func init() {
+	Enrichments["case"] = enrichCase
+}
+
+// The case enrichment looks at the case of the qname as it was on the wire.
+// Resolvers using 0x20 encoding randomize the case of the names they query,
+// and expect servers to echo the question unchanged.
+
+const (
+	// caseQueryTimeout is how long a query waits for its response.
+	caseQueryTimeout = 10 * time.Second
+	// caseMinOddLetters is the number of letters out of case a name needs to
+	// be taken for randomized. Randomizing the case of a name of a dozen
+	// letters leaves about half of them out of case, while capitalized or
+	// camel-case words have one or two.
+	caseMinOddLetters = 3
+)
+
+type caseQuery struct {
+	qname string
+	time  time.Time
+}
+
+var (
+	// caseQueries holds the qnames of the queries waiting for a response,
+	// keyed by caseQueryKey.
+	caseQueries = make(map[string]caseQuery)
+	caseSwept   time.Time
+
+	// The message whose records are being enriched, and the fields found
+	// for it
+	caseSha256   string
+	caseMixed    bool
+	caseMismatch bool
+)
+
+// caseQueryKey identifies a query and its response by the client, server,
+// transaction ID and name.
+func caseQueryKey(d *DnsSchema) string {
+	client, clientPort := d.SourceAddress, d.SourcePort
+	server, serverPort := d.DestinationAddress, d.DestinationPort
+	if d.Response {
+		client, server = server, client
+		clientPort, serverPort = serverPort, clientPort
+	}
+	return fmt.Sprintf("%s|%d|%s|%d|%d|%s", client, clientPort, server, serverPort, d.Id, strings.ToLower(d.Qname))
+}
+
+// mixedCase reports whether the case of a name looks randomized. A letter is
+// out of case when its label mostly has letters of the other case, except
+// for the capital first letter of a lowercase label, and it takes
+// caseMinOddLetters of them. Names such as www.Example.com or
+// www.YouTube.com are written that way on purpose, while names too short to
+// have enough letters out of case can't be told apart.
+func mixedCase(name string) bool {
+	odd := 0
+	for _, label := range strings.Split(name, ".") {
+		upper, lower, first := 0, 0, false
+		for i := 0; i < len(label); i++ {
+			switch c := label[i]; {
+			case 'A' <= c && c <= 'Z':
+				upper++
+				first = first || upper+lower == 1
+			case 'a' <= c && c <= 'z':
+				lower++
+			}
+		}
+		if upper > lower {
+			odd += lower
+		} else if first {
+			odd += upper - 1
+		} else {
+			odd += upper
+		}
+	}
+	return odd >= caseMinOddLetters
+}
+
+// enrichCase adds whether the qname has randomized case and, for responses,
+// whether the case of the question differs from that of its query or of the
+// answers for the qname.
+func enrichCase(d *DnsSchema) bool {
+	if d.Sha256 != caseSha256 || d.Sha256 == "" {
+		caseSha256 = d.Sha256
+		caseMixed = mixedCase(d.Qname)
+		caseMismatch = caseMessageMismatch(d)
+	}
+	mixed := caseMixed
+	d.QnameMixedCase = &mixed
+	if d.Response {
+		mismatch := caseMismatch
+		d.QnameCaseMismatch = &mismatch
+	}
+	return true
+}
+
+// caseMessageMismatch remembers the qname of a query, or compares the
+// question of a response to its query and answers. Queries are only seen
+// when they are parsed with --questions, and otherwise the question is only
+// compared to the answers.
+func caseMessageMismatch(d *DnsSchema) bool {
+	caseSweep(d.Time)
+
+	key := caseQueryKey(d)
+	if !d.Response {
+		caseQueries[key] = caseQuery{qname: d.Qname, time: d.Time}
+		return false
+	}
+
+	mismatch := false
+	if q, ok := caseQueries[key]; ok {
+		delete(caseQueries, key)
+		mismatch = q.qname != d.Qname
+	}
+	if d.Msg != nil {
+		for _, rr := range d.Msg.Answer {
+			name := rr.Header().Name
+			if name != d.Qname && strings.EqualFold(name, d.Qname) {
+				mismatch = true
+			}
+		}
+	}
+	return mismatch
+}
+
+// caseSweep forgets the queries that went without a response.
+func caseSweep(now time.Time) {
+	if now.Sub(caseSwept) < caseQueryTimeout {
+		return
+	}
+	for key, q := range caseQueries {
+		if now.Sub(q.time) > caseQueryTimeout {
+			delete(caseQueries, key)
+		}
+	}
+	caseSwept = now
+}
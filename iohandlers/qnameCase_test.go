@@ -0,0 +1,113 @@
+package iohandlers
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestMixedCase(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"www.example.com.", false},
+		{"WWW.EXAMPLE.COM.", false},
+		{"www.Example.com.", false},
+		{"Www.Example.Com.", false},
+		{"WWW.example.com.", false},
+		{"www.YouTube.com.", false},
+		{"www.MyFitnessPal.com.", false},
+		{"iPhone.example.", false},
+		{"_dmarc.Example.com.", false},
+		// Two letters out of case may still be written that way
+		{"www.eXAmple.com.", false},
+		// Randomized by 0x20 encoding
+		{"wWw.eXaMpLe.CoM.", true},
+		{"www.eXAmPle.com.", true},
+		{"WwW.ExAmPLE.com.", true},
+		{"www.examPLE.cOm.", true},
+		{"mAiL.gOoGlE.cOm.", true},
+		// Too short to tell
+		{"wW.eX.", false},
+		{".", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		if got := mixedCase(tt.name); got != tt.want {
+			t.Errorf("mixedCase(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// caseRecord returns a record of a message between a client and a server
+// with the given question and answers.
+func caseRecord(t *testing.T, response bool, qname string, id uint16, answers ...string) *DnsSchema {
+	t.Helper()
+	msg := new(dns.Msg)
+	for _, rr := range answers {
+		msg.Answer = append(msg.Answer, mustRR(t, rr))
+	}
+	d := &DnsSchema{
+		Time:               time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+		Sha256:             fmt.Sprintf("%s %d %v", qname, id, response),
+		SourceAddress:      "192.0.2.1",
+		SourcePort:         40000,
+		DestinationAddress: "198.51.100.53",
+		DestinationPort:    53,
+		Id:                 id,
+		Response:           response,
+		Qname:              qname,
+		Msg:                msg,
+	}
+	if response {
+		d.SourceAddress, d.DestinationAddress = d.DestinationAddress, d.SourceAddress
+		d.SourcePort, d.DestinationPort = d.DestinationPort, d.SourcePort
+	}
+	return d
+}
+
+func TestCaseMismatch(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    string
+		response *DnsSchema
+		want     bool
+	}{
+		// With --questions the response is compared to its query
+		{"echoed", "wWw.eXaMpLe.CoM.", caseRecord(t, true, "wWw.eXaMpLe.CoM.", 1, "wWw.eXaMpLe.CoM. 300 IN A 192.0.2.1"), false},
+		{"lowercased", "wWw.eXaMpLe.CoM.", caseRecord(t, true, "www.example.com.", 2, "www.example.com. 300 IN A 192.0.2.1"), true},
+		{"other transaction", "wWw.eXaMpLe.CoM.", caseRecord(t, true, "www.example.com.", 99), false},
+		// Without it only the answers are compared to the question
+		{"answers match", "", caseRecord(t, true, "wWw.eXaMpLe.CoM.", 3, "wWw.eXaMpLe.CoM. 300 IN A 192.0.2.1"), false},
+		{"answer differs", "", caseRecord(t, true, "wWw.eXaMpLe.CoM.", 4, "www.example.com. 300 IN A 192.0.2.1"), true},
+		{"other owner", "", caseRecord(t, true, "wWw.eXaMpLe.CoM.", 5, "wWw.eXaMpLe.CoM. 300 IN CNAME Web.Example.com.", "web.example.com. 300 IN A 192.0.2.1"), false},
+		{"no answers", "", caseRecord(t, true, "wWw.eXaMpLe.CoM.", 6), false},
+	}
+
+	for _, tt := range tests {
+		caseQueries = make(map[string]caseQuery)
+		if tt.query != "" {
+			query := caseRecord(t, false, tt.query, tt.response.Id)
+			if tt.name == "other transaction" {
+				query.Id = 1
+			}
+			enrichCase(query)
+			if query.QnameCaseMismatch != nil {
+				t.Errorf("%s: query has qname_case_mismatch", tt.name)
+			}
+			if !*query.QnameMixedCase {
+				t.Errorf("%s: query %s isn't mixed case", tt.name, query.Qname)
+			}
+		}
+
+		enrichCase(tt.response)
+		if got := tt.response.QnameCaseMismatch; got == nil || *got != tt.want {
+			t.Errorf("%s: qname_case_mismatch %v, want %v", tt.name, got, tt.want)
+		}
+	}
+	caseQueries = make(map[string]caseQuery)
+}
@@ -0,0 +1,271 @@
+package iohandlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/netip"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+func init() {
+	Aggregators["rollup"] = newRollupAggregator
+	aggregateRecordTypes["rollup"] = reflect.TypeOf(RollupSchema{})
+}
+
+// The rollup aggregation counts messages instead of writing records for
+// them. Messages are grouped by the key fields over tumbling windows, and at
+// the end of every window a RollupSchema record is written for each group in
+// the selected format.
+
+// A RollupSchema holds the counts of a group of messages over a window. Only
+// the key fields the messages were grouped by are set.
+type RollupSchema struct {
+	Timestamp     int64   `json:"timestamp"`
+	Window        int64   `json:"window"`
+	Response      *bool   `json:"response"`
+	Qname         *string `json:"qname"`
+	Domain        *string `json:"domain"`
+	Qtype         *uint16 `json:"qtype"`
+	Rcode         *int    `json:"rcode"`
+	Client        *string `json:"client"`
+	Server        *string `json:"server"`
+	Source        *string `json:"source"`
+	Sensor        *string `json:"sensor"`
+	Count         uint64  `json:"count"`
+	UniqueClients uint64  `json:"unique_clients"`
+	Bytes         uint64  `json:"bytes"`
+}
+
+// A RollupConfig holds the options for the rollup aggregation.
+type RollupConfig struct {
+	// Keys are the fields messages are grouped by, out of RollupKeys.
+	Keys []string
+	// Window is the length of the tumbling windows, aligned to the epoch.
+	Window time.Duration
+}
+
+var (
+	Rollup = RollupConfig{
+		Keys:   []string{"response", "domain", "qtype", "rcode"},
+		Window: time.Minute,
+	}
+
+	// RollupKeys are the fields messages can be grouped by. The client is
+	// grouped by its /24 or /48 network, and the domain is the registered
+	// domain of the qname.
+	RollupKeys = []string{"response", "qname", "domain", "qtype", "rcode", "client", "server", "source", "sensor"}
+)
+
+// RollupFields returns the fields of the records written with the configured
+// keys: the window, the key fields and the counts.
+func RollupFields() []string {
+	fields := append([]string{"timestamp", "window"}, Rollup.Keys...)
+	return append(fields, "count", "unique_clients", "bytes")
+}
+
+// A rollupKey holds the key fields of a group, leaving the others empty.
+type rollupKey struct {
+	response       bool
+	qname, domain  string
+	qtype          uint16
+	rcode          int
+	client, server string
+	source, sensor string
+}
+
+type rollupGroup struct {
+	count   uint64
+	clients distinctCounter
+	bytes   uint64
+}
+
+type rollupAggregator struct {
+	format string
+	keys   map[string]bool
+	groups map[rollupKey]*rollupGroup
+	// The start of the current window
+	window time.Time
+	// The message whose records are being seen
+	sha256 string
+}
+
+func newRollupAggregator(format string) (Aggregator, error) {
+	if err := checkSummaryFormat(format); err != nil {
+		return nil, err
+	}
+
+	a := &rollupAggregator{
+		format: format,
+		keys:   make(map[string]bool),
+		groups: make(map[rollupKey]*rollupGroup),
+	}
+	for _, key := range Rollup.Keys {
+		a.keys[key] = true
+	}
+	return a, nil
+}
+
+// clientNetwork returns the /24 network of an IPv4 address or the /48
+// network of an IPv6 address. IPv4-mapped addresses are IPv4 clients.
+func clientNetwork(address string) string {
+	addr, err := netip.ParseAddr(address)
+	if err != nil {
+		return address
+	}
+	addr = addr.Unmap()
+	bits := 48
+	if addr.Is4() {
+		bits = 24
+	}
+	prefix, _ := addr.Prefix(bits)
+	return prefix.String()
+}
+
+// Add counts the message of a record. The records of a message arrive one
+// after the other, so only the first of them is counted.
+func (a *rollupAggregator) Add(d *DnsSchema) {
+	if d.Sha256 == a.sha256 && d.Sha256 != "" {
+		return
+	}
+	a.sha256 = d.Sha256
+
+	t := d.Time
+	if t.IsZero() {
+		t = time.Unix(d.Timestamp, 0)
+	}
+	// Packets arriving slightly out of order are counted in the current
+	// window
+	a.Tick(t)
+
+	client, server := d.SourceAddress, d.DestinationAddress
+	if d.Response {
+		client, server = server, client
+	}
+
+	var key rollupKey
+	if a.keys["response"] {
+		key.response = d.Response
+	}
+	if a.keys["qname"] {
+		key.qname = strings.ToLower(d.Qname)
+	}
+	if a.keys["domain"] {
+		key.domain = registeredDomain(d)
+	}
+	if a.keys["qtype"] {
+		key.qtype = d.Qtype
+	}
+	if a.keys["rcode"] {
+		key.rcode = d.Rcode
+	}
+	if a.keys["client"] {
+		key.client = clientNetwork(client)
+	}
+	if a.keys["server"] {
+		key.server = server
+	}
+	if a.keys["source"] {
+		key.source = d.Source
+	}
+	if a.keys["sensor"] {
+		key.sensor = d.Sensor
+	}
+
+	g, ok := a.groups[key]
+	if !ok {
+		g = &rollupGroup{}
+		a.groups[key] = g
+	}
+	g.count++
+	g.clients.add(client)
+	g.bytes += uint64(d.Size)
+}
+
+// Tick writes the records of the window once it has ended at t.
+func (a *rollupAggregator) Tick(t time.Time) {
+	if w := t.Truncate(Rollup.Window); w.After(a.window) {
+		a.flush()
+		a.window = w
+	}
+}
+
+// flush writes the records of the groups of the window and empties the
+// table.
+func (a *rollupAggregator) flush() {
+	keys := make([]rollupKey, 0, len(a.groups))
+	names := make(map[rollupKey]string, len(a.groups))
+	for key := range a.groups {
+		keys = append(keys, key)
+		names[key] = fmt.Sprint(key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return names[keys[i]] < names[keys[j]]
+	})
+
+	for _, key := range keys {
+		key := key
+		g := a.groups[key]
+		r := &RollupSchema{
+			Timestamp:     a.window.Unix(),
+			Window:        int64(Rollup.Window / time.Second),
+			Count:         g.count,
+			UniqueClients: g.clients.count(),
+			Bytes:         g.bytes,
+		}
+		if a.keys["response"] {
+			r.Response = &key.response
+		}
+		if a.keys["qname"] {
+			r.Qname = &key.qname
+		}
+		if a.keys["domain"] {
+			r.Domain = &key.domain
+		}
+		if a.keys["qtype"] {
+			r.Qtype = &key.qtype
+		}
+		if a.keys["rcode"] {
+			r.Rcode = &key.rcode
+		}
+		if a.keys["client"] {
+			r.Client = &key.client
+		}
+		if a.keys["server"] {
+			r.Server = &key.server
+		}
+		if a.keys["source"] {
+			r.Source = &key.source
+		}
+		if a.keys["sensor"] {
+			r.Sensor = &key.sensor
+		}
+
+		// Outputs see the record as a query from the client for the name
+		// of the group, identified by the group and its window
+		id := sha256.Sum256([]byte(fmt.Sprintf("%d %s", r.Timestamp, names[key])))
+		qname := key.qname
+		if qname == "" {
+			qname = key.domain
+		}
+		emitRecord(&DnsSchema{
+			Timestamp:     r.Timestamp,
+			Sha256:        hex.EncodeToString(id[:]),
+			SourceAddress: key.client,
+			Qname:         qname,
+			Source:        key.source,
+			Sensor:        key.sensor,
+			RrIndex:       -1,
+			Summary:       r,
+		}, a.format)
+	}
+
+	a.groups = make(map[rollupKey]*rollupGroup)
+}
+
+func (a *rollupAggregator) Close() {
+	a.flush()
+}
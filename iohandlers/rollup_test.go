@@ -0,0 +1,215 @@
+package iohandlers
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rollupMessage returns the record of a message of size bytes from a client
+// to a server, or back for a response, seen at the given offset from
+// pdnsStart.
+func rollupMessage(client, server, qname string, response bool, offset time.Duration, size int) *DnsSchema {
+	d := &DnsSchema{
+		Time:               pdnsStart.Add(offset),
+		Sha256:             fmt.Sprintf("%s %s %s %v %v", client, server, qname, response, offset),
+		SourceAddress:      client,
+		DestinationAddress: server,
+		Response:           response,
+		Qname:              qname,
+		Qtype:              1,
+		Size:               size,
+	}
+	if response {
+		d.SourceAddress, d.DestinationAddress = server, client
+	}
+	return d
+}
+
+// runRollup aggregates records with the given keys and returns the csv
+// output.
+func runRollup(t *testing.T, keys []string, records []*DnsSchema) []string {
+	t.Helper()
+	config := Rollup
+	Rollup.Keys = keys
+	defer func() { Rollup = config }()
+
+	a := newAggregateTest(t, "rollup", "csv", RollupFields()...)
+	for _, d := range records {
+		aggregator.Add(d)
+	}
+	return a.close()
+}
+
+func TestRollupKeys(t *testing.T) {
+	tests := []struct {
+		name    string
+		keys    []string
+		records []*DnsSchema
+		want    []string
+	}{
+		{
+			name: "registered domain",
+			keys: []string{"domain"},
+			records: []*DnsSchema{
+				rollupMessage("192.0.2.1", "198.51.100.53", "www.example.com.", false, 0, 30),
+				rollupMessage("192.0.2.2", "198.51.100.53", "MAIL.Example.com.", false, 0, 31),
+				rollupMessage("192.0.2.1", "198.51.100.53", "www.example.co.uk.", false, 0, 32),
+				rollupMessage("192.0.2.1", "198.51.100.53", "a.b.example.co.uk.", false, 0, 33),
+				rollupMessage("192.0.2.1", "198.51.100.53", "co.uk.", false, 0, 34),
+			},
+			want: []string{
+				"timestamp,window,domain,count,unique_clients,bytes",
+				"1714564800,60,co.uk,1,1,34",
+				"1714564800,60,example.co.uk,2,1,65",
+				"1714564800,60,example.com,2,2,61",
+			},
+		},
+		{
+			name: "client network",
+			keys: []string{"client"},
+			records: []*DnsSchema{
+				rollupMessage("192.0.2.1", "198.51.100.53", "www.example.com.", false, 0, 30),
+				rollupMessage("192.0.2.200", "198.51.100.53", "www.example.com.", false, 0, 30),
+				// The client of a response is its destination
+				rollupMessage("192.0.2.1", "198.51.100.53", "www.example.com.", true, 0, 46),
+				rollupMessage("192.0.3.1", "198.51.100.53", "www.example.com.", false, 0, 30),
+				rollupMessage("2001:db8:1:2::1", "2001:db8:53::53", "www.example.com.", false, 0, 30),
+				rollupMessage("2001:db8:1:ffff::1", "2001:db8:53::53", "www.example.com.", false, 0, 30),
+				rollupMessage("2001:db8:2::1", "2001:db8:53::53", "www.example.com.", false, 0, 30),
+			},
+			want: []string{
+				"timestamp,window,client,count,unique_clients,bytes",
+				"1714564800,60,192.0.2.0/24,3,2,106",
+				"1714564800,60,192.0.3.0/24,1,1,30",
+				"1714564800,60,2001:db8:1::/48,2,2,60",
+				"1714564800,60,2001:db8:2::/48,1,1,30",
+			},
+		},
+		{
+			name: "server",
+			keys: []string{"response", "server"},
+			records: []*DnsSchema{
+				rollupMessage("192.0.2.1", "198.51.100.53", "www.example.com.", false, 0, 30),
+				rollupMessage("192.0.2.2", "198.51.100.53", "www.example.com.", false, 0, 30),
+				// The server of a response is its source
+				rollupMessage("192.0.2.1", "198.51.100.53", "www.example.com.", true, 0, 46),
+				rollupMessage("192.0.2.1", "203.0.113.53", "www.example.com.", false, 0, 30),
+			},
+			want: []string{
+				"timestamp,window,response,server,count,unique_clients,bytes",
+				"1714564800,60,false,198.51.100.53,2,2,60",
+				"1714564800,60,false,203.0.113.53,1,1,30",
+				"1714564800,60,true,198.51.100.53,1,1,46",
+			},
+		},
+		{
+			name: "windows",
+			keys: []string{"domain"},
+			records: []*DnsSchema{
+				rollupMessage("192.0.2.1", "198.51.100.53", "www.example.com.", false, 10*time.Second, 30),
+				rollupMessage("192.0.2.1", "198.51.100.53", "www.example.com.", false, 70*time.Second, 30),
+				// Late packets are counted in the current window
+				rollupMessage("192.0.2.2", "198.51.100.53", "www.example.com.", false, 50*time.Second, 30),
+			},
+			want: []string{
+				"timestamp,window,domain,count,unique_clients,bytes",
+				"1714564800,60,example.com,1,1,30",
+				"1714564860,60,example.com,2,2,60",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		got := runRollup(t, tt.keys, tt.records)
+		if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+			t.Errorf("%s: wrote\n%s\nwant\n%s", tt.name, strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+		}
+	}
+}
+
+func TestRollupMessageCountedOnce(t *testing.T) {
+	// The records of the answers of a message share its hash
+	d := rollupMessage("192.0.2.1", "198.51.100.53", "www.example.com.", true, 0, 100)
+	got := runRollup(t, []string{"domain"}, []*DnsSchema{d, d, d})
+	want := "1714564800,60,example.com,1,1,100"
+	if len(got) != 2 || got[1] != want {
+		t.Errorf("wrote %q, want %q", got, want)
+	}
+}
+
+func TestRollupTick(t *testing.T) {
+	config := Rollup
+	Rollup.Keys = []string{"domain"}
+	defer func() { Rollup = config }()
+
+	a := newAggregateTest(t, "rollup", "json", RollupFields()...)
+	aggregator.Add(rollupMessage("192.0.2.1", "198.51.100.53", "www.example.com.", false, time.Second, 30))
+	Tick(pdnsStart.Add(59 * time.Second))
+	if got := a.lines(); len(got) != 0 {
+		t.Errorf("wrote %q before the window ended", got)
+	}
+	Tick(pdnsStart.Add(time.Minute))
+	want := `{"timestamp":1714564800,"window":60,"domain":"example.com","count":1,"unique_clients":1,"bytes":30}`
+	if got := a.lines(); len(got) != 1 || got[0] != want {
+		t.Errorf("wrote %q after the window ended, want %q", got, want)
+	}
+	if got := a.close(); len(got) != 1 {
+		t.Errorf("wrote %q, with more records on close", got)
+	}
+}
+
+func TestRollupFormats(t *testing.T) {
+	for _, format := range MessageFormats {
+		if _, err := newRollupAggregator(format); err == nil {
+			t.Errorf("rollup aggregation accepted the %s format", format)
+		}
+	}
+}
+
+func TestClientNetwork(t *testing.T) {
+	tests := map[string]string{
+		"192.0.2.1":            "192.0.2.0/24",
+		"192.0.2.255":          "192.0.2.0/24",
+		"10.1.2.3":             "10.1.2.0/24",
+		"2001:db8:1:2::1":      "2001:db8:1::/48",
+		"2001:db8:1:ffff:1::1": "2001:db8:1::/48",
+		"::ffff:192.0.2.1":     "192.0.2.0/24",
+		"not an address":       "not an address",
+		"":                     "",
+	}
+	for address, want := range tests {
+		if got := clientNetwork(address); got != want {
+			t.Errorf("clientNetwork(%q) = %q, want %q", address, got, want)
+		}
+	}
+}
+
+func TestDistinctCounter(t *testing.T) {
+	// Three standard errors of the sketch
+	tolerance := 3 * 1.04 / math.Sqrt(1<<hllPrecision)
+
+	for _, n := range []int{0, 1, 10, distinctExactLimit, distinctExactLimit + 1, 200, 1000, 3000, 10000, 100000, 1000000} {
+		var c distinctCounter
+		for i := 0; i < n; i++ {
+			// Values seen again don't count
+			c.add(fmt.Sprintf("192.0.%d.%d", i>>8, i&0xff))
+			if i%3 == 0 {
+				c.add(fmt.Sprintf("192.0.%d.%d", i>>8, i&0xff))
+			}
+		}
+
+		got := c.count()
+		if n <= distinctExactLimit {
+			if got != uint64(n) {
+				t.Errorf("%d values counted as %d", n, got)
+			}
+			continue
+		}
+		if err := math.Abs(float64(got)-float64(n)) / float64(n); err > tolerance {
+			t.Errorf("%d values estimated as %d, off by %.1f%%", n, got, 100*err)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package iohandlers
 
 import (
 	"strings"
+	"time"
 
 	"github.com/miekg/dns"
 )
@@ -43,21 +44,83 @@ type DnsSchema struct {
 	EcsScope           *uint8  `json:"ecs_scope"`
 	Source             string  `json:"source,omitempty"`
 	Sensor             string  `json:"sensor,omitempty"`
+
+	// Fields added by enrichments, tagged with the enrichment. They are only
+	// output when it is enabled, and are nil when it isn't or when it had
+	// nothing to add.
+	QnameEtld             *string `json:"qname_etld,omitempty" enrich:"psl"`
+	QnameRegisteredDomain *string `json:"qname_registered_domain,omitempty" enrich:"psl"`
+	QnameSubdomain        *string `json:"qname_subdomain,omitempty" enrich:"psl"`
+	QnameNormalized       *string `json:"qname_normalized,omitempty" enrich:"idn"`
+	QnameUnicode          *string `json:"qname_unicode,omitempty" enrich:"idn"`
+	QnameMixedScript      *bool   `json:"qname_mixed_script,omitempty" enrich:"idn"`
+	RnameNormalized       *string `json:"rname_normalized,omitempty" enrich:"idn"`
+	RnameUnicode          *string `json:"rname_unicode,omitempty" enrich:"idn"`
+	RnameMixedScript      *bool   `json:"rname_mixed_script,omitempty" enrich:"idn"`
+	QnameMixedCase        *bool   `json:"qname_mixed_case,omitempty" enrich:"case"`
+	QnameCaseMismatch     *bool   `json:"qname_case_mismatch,omitempty" enrich:"case"`
+	SrcCountry            *string `json:"src_country,omitempty" enrich:"geoip"`
+	SrcAsn                *uint32 `json:"src_asn,omitempty" enrich:"geoip"`
+	SrcAsOrg              *string `json:"src_as_org,omitempty" enrich:"geoip"`
+	DstCountry            *string `json:"dst_country,omitempty" enrich:"geoip"`
+	DstAsn                *uint32 `json:"dst_asn,omitempty" enrich:"geoip"`
+	DstAsOrg              *string `json:"dst_as_org,omitempty" enrich:"geoip"`
+	EcsClientCountry      *string `json:"ecs_client_country,omitempty" enrich:"geoip"`
+	EcsClientAsn          *uint32 `json:"ecs_client_asn,omitempty" enrich:"geoip"`
+	EcsClientAsOrg        *string `json:"ecs_client_as_org,omitempty" enrich:"geoip"`
+	RdataCountry          *string `json:"rdata_country,omitempty" enrich:"geoip"`
+	RdataAsn              *uint32 `json:"rdata_asn,omitempty" enrich:"geoip"`
+	RdataAsOrg            *string `json:"rdata_as_org,omitempty" enrich:"geoip"`
+	TunnelScore           *uint8  `json:"tunnel_score,omitempty" enrich:"tunnel"`
+	DgaScore              *uint8  `json:"dga_score,omitempty" enrich:"dga"`
+	FirstSeenEver         *bool   `json:"first_seen_ever,omitempty" enrich:"nod"`
+
+	// RrIndex is the position of the RR within its section, or -1 for
+	// records without an RR. It isn't output but identifies the record
+	// within its packet together with Sha256 and the section.
+	RrIndex int `json:"-"`
+	// Time is the capture time of the packet, which is only output with
+	// second precision as Timestamp.
+	Time time.Time `json:"-"`
+	// Msg is the decoded DNS message the record was parsed from. It gives
+	// formats that describe whole messages access to the header fields
+	// that aren't part of the schema.
+	Msg *dns.Msg `json:"-"`
+	// Size is the length of the DNS message in bytes.
+	Size int `json:"-"`
+	// Summary holds the record that is output in place of this one by
+	// aggregations that write records of their own through the formats.
+	// This record then only carries the fields outputs route records by.
+	Summary interface{} `json:"-"`
 }
 
 var (
 	Initializers = make(map[string]func())
 	Marshalers   = make(map[string]func(*DnsSchema))
 	Closers      = make(map[string]func())
+	// Flushers write out the records a format holds in memory, so they can
+	// be seen before the format is closed.
+	Flushers = make(map[string]func())
+
+	// Stages are run in order on every record before it is marshaled. A
+	// stage may modify the record and returns false to drop it.
+	Stages []func(*DnsSchema) bool
 )
 
 func Initialize(format string) {
 	if init, ok := Initializers[format]; ok {
 		init()
 	}
+	initializeOutput(format)
+	initializeAggregator(format)
+	initializeAlerts()
 }
 
 func Close(format string) {
+	closeAggregator()
+	closeAlerts()
+	flushFirstSeen()
+	closeOutput()
 	if closer, ok := Closers[format]; ok {
 		closer()
 	}
@@ -86,5 +149,19 @@ func (d DnsSchema) Marshal(rr *dns.RR, section int, format string) {
 		}
 	}
 
+	for _, stage := range Stages {
+		if !stage(&d) {
+			return
+		}
+	}
+
+	if aggregator != nil {
+		aggregator.Add(&d)
+		return
+	}
+	if output != nil {
+		writeOutput(&d, format)
+		return
+	}
 	Marshalers[format](&d)
 }
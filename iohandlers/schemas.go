@@ -0,0 +1,169 @@
+package iohandlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+const avroNamespace = "org.hamba.avro"
+
+type avroFieldSchema struct {
+	Name    string          `json:"name"`
+	Type    interface{}     `json:"type"`
+	Default json.RawMessage `json:"default,omitempty"`
+}
+
+type avroRecordSchema struct {
+	Type      string            `json:"type"`
+	Name      string            `json:"name"`
+	Namespace string            `json:"namespace"`
+	Fields    []avroFieldSchema `json:"fields"`
+}
+
+// avroType returns the Avro primitive for a Go type. Avro has no unsigned
+// integers, so unsigned types are widened to a signed type that can hold
+// all of their values.
+func avroType(t reflect.Type) string {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return "boolean"
+	case reflect.String:
+		return "string"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+		reflect.Uint8, reflect.Uint16:
+		return "int"
+	case reflect.Int64, reflect.Uint, reflect.Uint32, reflect.Uint64:
+		return "long"
+	}
+	panic("iohandlers: no Avro type for " + t.String())
+}
+
+// avroNullable reports whether a field is written as a union with null.
+// Besides pointers, empty omitempty fields are written as null.
+func avroNullable(f schemaField) bool {
+	return f.nullable || f.omitEmpty
+}
+
+// AvroSchema returns the Avro schema for records with the selected fields.
+func AvroSchema() string {
+	record := avroRecordSchema{
+		Type:      "record",
+		Name:      recordType.Name(),
+		Namespace: avroNamespace,
+		Fields:    make([]avroFieldSchema, 0, len(Fields)),
+	}
+
+	for _, f := range Fields {
+		field := avroFieldSchema{Name: f.name, Type: avroType(recordType.Field(f.index).Type)}
+		if avroNullable(f) {
+			field.Type = []string{"null", field.Type.(string)}
+			field.Default = json.RawMessage("null")
+		}
+		record.Fields = append(record.Fields, field)
+	}
+
+	schema, err := json.MarshalIndent(record, "", "  ")
+	if err != nil {
+		panic(err)
+	}
+	return string(schema)
+}
+
+// avroValue converts a field to the Go type the encoder expects for its
+// Avro type, or nil if the field is null.
+func avroValue(v reflect.Value, f schemaField, typ string) interface{} {
+	fv := v.Field(f.index)
+	if f.nullable {
+		if fv.IsNil() {
+			return nil
+		}
+		fv = fv.Elem()
+	} else if f.omitEmpty && fv.IsZero() {
+		return nil
+	}
+
+	switch typ {
+	case "boolean":
+		return fv.Bool()
+	case "string":
+		return fv.String()
+	case "int":
+		if fv.CanInt() {
+			return int(fv.Int())
+		}
+		return int(fv.Uint())
+	default:
+		if fv.CanInt() {
+			return fv.Int()
+		}
+		return int64(fv.Uint())
+	}
+}
+
+// JsonSchema returns a JSON Schema describing the records written by the
+// json format with the selected fields.
+func JsonSchema() string {
+	properties := make(map[string]interface{}, len(Fields))
+	required := make([]string, 0, len(Fields))
+
+	for _, f := range Fields {
+		ft := recordType.Field(f.index).Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+
+		property := make(map[string]interface{})
+		switch ft.Kind() {
+		case reflect.Bool:
+			property["type"] = "boolean"
+		case reflect.String:
+			property["type"] = "string"
+		default:
+			property["type"] = "integer"
+			if ft.Kind() >= reflect.Uint && ft.Kind() <= reflect.Uint64 {
+				property["minimum"] = 0
+				if ft.Bits() < 64 {
+					property["maximum"] = uint64(1)<<ft.Bits() - 1
+				}
+			}
+		}
+		if f.nullable {
+			property["type"] = []interface{}{property["type"], "null"}
+		}
+		properties[f.name] = property
+
+		if !f.omitEmpty {
+			required = append(required, f.name)
+		}
+	}
+
+	schema, err := json.MarshalIndent(map[string]interface{}{
+		"$schema":    "https://json-schema.org/draft/2020-12/schema",
+		"title":      recordType.Name(),
+		"type":       "object",
+		"properties": properties,
+		"required":   required,
+	}, "", "  ")
+	if err != nil {
+		panic(err)
+	}
+	return string(schema)
+}
+
+// ClickHouseSchema returns a CREATE TABLE statement for a table that can hold
+// the records written by the json and rowbinary formats with the selected
+// fields.
+func ClickHouseSchema(table string) string {
+	columns := make([]string, len(Fields))
+	for i, f := range Fields {
+		columns[i] = fmt.Sprintf("    `%s` %s", f.name, clickHouseType(recordType.Field(f.index).Type))
+	}
+	return fmt.Sprintf("CREATE TABLE %s\n(\n%s\n)\nENGINE = MergeTree\nORDER BY tuple()",
+		table, strings.Join(columns, ",\n"))
+}
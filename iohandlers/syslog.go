@@ -0,0 +1,225 @@
+package iohandlers
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+)
+
+func init() {
+	Outputs["syslog"] = newSyslogOutput
+}
+
+// A SyslogConfig holds the options for the syslog output.
+type SyslogConfig struct {
+	// Address is where messages are sent, given as udp://host:port,
+	// tcp://host:port, tls://host:port or unix:///path/to/socket.
+	Address string
+	// Facility is the name or number of the syslog facility.
+	Facility string
+	// AppName identifies the application in every message.
+	AppName string
+}
+
+var (
+	Syslog = SyslogConfig{
+		Address:  "unix:///dev/log",
+		Facility: "local0",
+		AppName:  "rickybobby",
+	}
+
+	SyslogFacilities = map[string]int{
+		"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5,
+		"lpr": 6, "news": 7, "uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+		"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+		"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+	}
+)
+
+// Messages are sent with the informational severity
+const syslogSeverity = 6
+
+// syslogOutput sends each record as an RFC 5424 message. Records in the sd
+// format become the STRUCTURED-DATA of the message, while records in other
+// formats become the MSG.
+type syslogOutput struct {
+	network  string
+	address  string
+	tls      bool
+	stream   bool
+	conn     net.Conn
+	priority int
+	hostname string
+	appName  string
+	procId   string
+	sd       bool
+	// The sensor of the last record and its HOSTNAME
+	sensor         string
+	sensorHostname string
+}
+
+// syslogHeaderField returns a value as it may appear in a field of the RFC
+// 5424 header: at most maxLength printable ASCII characters other than the
+// space, with the others replaced by underscores, or the nil value "-" if
+// it is empty.
+func syslogHeaderField(s string, maxLength int) string {
+	if s == "" {
+		return "-"
+	}
+	b := []byte(s)
+	if len(b) > maxLength {
+		b = b[:maxLength]
+	}
+	for i, c := range b {
+		if c < 33 || c > 126 {
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
+
+// syslogFacility parses a facility name or number.
+func syslogFacility(facility string) (int, error) {
+	if f, ok := SyslogFacilities[facility]; ok {
+		return f, nil
+	}
+	if f, err := strconv.Atoi(facility); err == nil && f >= 0 && f <= 23 {
+		return f, nil
+	}
+	return 0, fmt.Errorf("unknown syslog facility %q", facility)
+}
+
+func newSyslogOutput(format string) (Output, error) {
+	switch format {
+	case "sd", "cef", "leef", "json":
+	default:
+		return nil, fmt.Errorf("the %s format can not be sent to syslog, use sd, cef, leef or json", format)
+	}
+
+	facility, err := syslogFacility(Syslog.Facility)
+	if err != nil {
+		return nil, err
+	}
+
+	u, err := url.Parse(Syslog.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	o := &syslogOutput{
+		priority: facility*8 + syslogSeverity,
+		appName:  syslogHeaderField(Syslog.AppName, 48),
+		procId:   strconv.Itoa(os.Getpid()),
+		sd:       format == "sd",
+	}
+	switch u.Scheme {
+	case "udp":
+		o.network, o.address = "udp", u.Host
+	case "tcp":
+		o.network, o.address, o.stream = "tcp", u.Host, true
+	case "tls":
+		o.network, o.address, o.stream, o.tls = "tcp", u.Host, true, true
+	case "unix":
+		o.network, o.address = "unixgram", u.Path
+	default:
+		return nil, fmt.Errorf("unknown syslog transport %q", u.Scheme)
+	}
+
+	hostname, _ := os.Hostname()
+	o.hostname = syslogHeaderField(hostname, 255)
+
+	if err := o.connect(); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+func (o *syslogOutput) connect() error {
+	switch {
+	case o.tls:
+		host, _, _ := net.SplitHostPort(o.address)
+		conn, err := tls.Dial(o.network, o.address, &tls.Config{ServerName: host})
+		if err != nil {
+			return err
+		}
+		o.conn = conn
+	case o.network == "unixgram":
+		// Fall back to a stream socket for syslog daemons that use one
+		conn, err := net.Dial("unixgram", o.address)
+		if err != nil {
+			if conn, err = net.Dial("unix", o.address); err != nil {
+				return err
+			}
+			o.stream = true
+		}
+		o.conn = conn
+	default:
+		conn, err := net.Dial(o.network, o.address)
+		if err != nil {
+			return err
+		}
+		o.conn = conn
+	}
+	return nil
+}
+
+// header returns the RFC 5424 header of a message for a record. The sensor
+// is reported as the host the traffic was seen on when it is set.
+func (o *syslogOutput) header(d *DnsSchema) string {
+	hostname := o.hostname
+	if d.Sensor != "" {
+		if d.Sensor != o.sensor {
+			o.sensor, o.sensorHostname = d.Sensor, syslogHeaderField(d.Sensor, 255)
+		}
+		hostname = o.sensorHostname
+	}
+	timestamp := time.Unix(d.Timestamp, 0).UTC().Format(time.RFC3339)
+	return fmt.Sprintf("<%d>1 %s %s %s %s dns ",
+		o.priority, timestamp, hostname, o.appName, o.procId)
+}
+
+func (o *syslogOutput) message(d *DnsSchema, record []byte) []byte {
+	msg := []byte(o.header(d))
+	if o.sd {
+		msg = append(msg, record...)
+	} else {
+		msg = append(msg, "- "...)
+		msg = append(msg, record...)
+	}
+
+	// Stream transports use octet counting to delimit messages (RFC 6587)
+	if o.stream {
+		msg = append([]byte(strconv.Itoa(len(msg))+" "), msg...)
+	}
+	return msg
+}
+
+// Write sends a message for the record, reconnecting once if the connection
+// was lost.
+func (o *syslogOutput) Write(d *DnsSchema, record []byte) error {
+	msg := o.message(d, record)
+	if o.conn != nil {
+		if _, err := o.conn.Write(msg); err == nil {
+			return nil
+		}
+		o.conn.Close()
+		o.conn = nil
+	}
+
+	if err := o.connect(); err != nil {
+		return err
+	}
+	_, err := o.conn.Write(msg)
+	return err
+}
+
+func (o *syslogOutput) Close() error {
+	if o.conn == nil {
+		return nil
+	}
+	return o.conn.Close()
+}
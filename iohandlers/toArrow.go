@@ -0,0 +1,209 @@
+package iohandlers
+
+import (
+	"bufio"
+	"os"
+	"reflect"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/ipc"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+	"github.com/rs/zerolog/log"
+)
+
+func init() {
+	Initializers["arrow"] = toArrowInitializer
+	Marshalers["arrow"] = toArrow
+	Closers["arrow"] = toArrowCloser
+	Flushers["arrow"] = toArrowFlusher
+}
+
+// The arrow format writes an Arrow IPC stream, with a column per selected
+// field. Records are collected into batches of up to BatchSize rows. Pointer
+// fields and empty omitempty fields are null, as in the avro format. The
+// columns holding names and sensors are dictionary-encoded, and each batch
+// comes with the dictionaries of its own values.
+
+// An ArrowConfig holds the options for the arrow format.
+type ArrowConfig struct {
+	// BatchSize is the maximum number of rows in a record batch.
+	BatchSize int
+}
+
+var (
+	Arrow = ArrowConfig{
+		BatchSize: 65536,
+	}
+
+	// arrowDictionaryFields are the fields whose values repeat often
+	// enough to be worth dictionary-encoding.
+	arrowDictionaryFields = map[string]bool{
+		"qname":  true,
+		"rname":  true,
+		"sensor": true,
+		"source": true,
+	}
+
+	arrowOutput  *bufio.Writer
+	arrowWriter  *ipc.Writer
+	arrowBuilder *array.RecordBuilder
+	arrowColumns []arrowColumn
+	arrowRows    int
+)
+
+// An arrowColumn appends the values of a field to the builder of its column.
+type arrowColumn struct {
+	field   schemaField
+	builder array.Builder
+	append  func(v reflect.Value)
+}
+
+// arrowType returns the Arrow type of a field.
+func arrowType(f schemaField, t reflect.Type) arrow.DataType {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return arrow.FixedWidthTypes.Boolean
+	case reflect.String:
+		if arrowDictionaryFields[f.name] {
+			return &arrow.DictionaryType{IndexType: arrow.PrimitiveTypes.Int32, ValueType: arrow.BinaryTypes.String}
+		}
+		return arrow.BinaryTypes.String
+	case reflect.Int8:
+		return arrow.PrimitiveTypes.Int8
+	case reflect.Int16:
+		return arrow.PrimitiveTypes.Int16
+	case reflect.Int32:
+		return arrow.PrimitiveTypes.Int32
+	case reflect.Int, reflect.Int64:
+		return arrow.PrimitiveTypes.Int64
+	case reflect.Uint8:
+		return arrow.PrimitiveTypes.Uint8
+	case reflect.Uint16:
+		return arrow.PrimitiveTypes.Uint16
+	case reflect.Uint32:
+		return arrow.PrimitiveTypes.Uint32
+	case reflect.Uint, reflect.Uint64:
+		return arrow.PrimitiveTypes.Uint64
+	}
+	panic("iohandlers: no Arrow type for " + t.String())
+}
+
+// arrowAppender returns the function appending a non-null value to a
+// builder.
+func arrowAppender(b array.Builder) func(reflect.Value) {
+	switch b := b.(type) {
+	case *array.BooleanBuilder:
+		return func(v reflect.Value) { b.Append(v.Bool()) }
+	case *array.StringBuilder:
+		return func(v reflect.Value) { b.Append(v.String()) }
+	case *array.BinaryDictionaryBuilder:
+		return func(v reflect.Value) {
+			if err := b.AppendString(v.String()); err != nil {
+				log.Warn().Msgf("Error appending to Arrow dictionary: %v", err)
+			}
+		}
+	case *array.Int8Builder:
+		return func(v reflect.Value) { b.Append(int8(v.Int())) }
+	case *array.Int16Builder:
+		return func(v reflect.Value) { b.Append(int16(v.Int())) }
+	case *array.Int32Builder:
+		return func(v reflect.Value) { b.Append(int32(v.Int())) }
+	case *array.Int64Builder:
+		return func(v reflect.Value) { b.Append(v.Int()) }
+	case *array.Uint8Builder:
+		return func(v reflect.Value) { b.Append(uint8(v.Uint())) }
+	case *array.Uint16Builder:
+		return func(v reflect.Value) { b.Append(uint16(v.Uint())) }
+	case *array.Uint32Builder:
+		return func(v reflect.Value) { b.Append(uint32(v.Uint())) }
+	case *array.Uint64Builder:
+		return func(v reflect.Value) { b.Append(v.Uint()) }
+	}
+	panic("iohandlers: no Arrow appender for " + b.Type().String())
+}
+
+func toArrowInitializer() {
+	fields := make([]arrow.Field, len(Fields))
+	for i, f := range Fields {
+		fields[i] = arrow.Field{
+			Name:     f.name,
+			Type:     arrowType(f, recordType.Field(f.index).Type),
+			Nullable: f.nullable || f.omitEmpty,
+		}
+	}
+	schema := arrow.NewSchema(fields, nil)
+
+	arrowBuilder = array.NewRecordBuilder(memory.DefaultAllocator, schema)
+	arrowColumns = make([]arrowColumn, len(Fields))
+	for i, f := range Fields {
+		b := arrowBuilder.Field(i)
+		arrowColumns[i] = arrowColumn{field: f, builder: b, append: arrowAppender(b)}
+	}
+	arrowRows = 0
+
+	arrowOutput = bufio.NewWriter(os.Stdout)
+	arrowWriter = ipc.NewWriter(arrowOutput, ipc.WithSchema(schema))
+}
+
+// arrowFlush writes the collected rows as a record batch. The dictionaries
+// are emptied after every batch, so each batch only carries its own values.
+func arrowFlush() {
+	if arrowRows == 0 {
+		return
+	}
+
+	record := arrowBuilder.NewRecord()
+	defer record.Release()
+	if err := arrowWriter.Write(record); err != nil {
+		log.Warn().Msgf("Error writing Arrow record batch: %v", err)
+	}
+
+	for _, c := range arrowColumns {
+		if b, ok := c.builder.(array.DictionaryBuilder); ok {
+			b.ResetFull()
+		}
+	}
+	arrowRows = 0
+}
+
+func toArrow(d *DnsSchema) {
+	v := recordValue(d)
+	for _, c := range arrowColumns {
+		fv := v.Field(c.field.index)
+		switch {
+		case c.field.nullable && fv.IsNil(), c.field.omitEmpty && fv.IsZero():
+			c.builder.AppendNull()
+		default:
+			c.append(reflect.Indirect(fv))
+		}
+	}
+	arrowRows++
+	if arrowRows >= Arrow.BatchSize {
+		arrowFlush()
+	}
+}
+
+// toArrowFlusher writes the collected rows as a record batch, which may
+// then hold fewer than BatchSize rows.
+func toArrowFlusher() {
+	arrowFlush()
+	if err := arrowOutput.Flush(); err != nil {
+		log.Warn().Msgf("%v", err)
+	}
+}
+
+func toArrowCloser() {
+	arrowFlush()
+	if err := arrowWriter.Close(); err != nil {
+		log.Warn().Msgf("%v", err)
+	}
+	arrowBuilder.Release()
+	if err := arrowOutput.Flush(); err != nil {
+		log.Warn().Msgf("%v", err)
+	}
+}
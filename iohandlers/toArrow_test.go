@@ -0,0 +1,148 @@
+package iohandlers
+
+import (
+	"os"
+	"testing"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/ipc"
+)
+
+func arrowTestRecords() []*DnsSchema {
+	ttl := uint32(300)
+	zeroTtl := uint32(0)
+	rname := "www.example.com."
+	cname := "web.example.com."
+	rtype := uint16(5)
+	rdata := "web.example.com."
+	ecsSource := uint8(24)
+
+	return []*DnsSchema{
+		{
+			Timestamp:     1714564800,
+			SourceAddress: "192.0.2.1",
+			SourcePort:    53,
+			Id:            1,
+			Response:      true,
+			Qname:         "www.example.com.",
+			Qtype:         1,
+			Ttl:           &ttl,
+			Rname:         &rname,
+			Rtype:         &rtype,
+			Rdata:         &rdata,
+			EcsSource:     &ecsSource,
+			Sensor:        "sensor1",
+		},
+		// Repeats the qname of the first row in the same batch
+		{
+			Timestamp: 1714564801,
+			Rcode:     -1,
+			Qname:     "www.example.com.",
+			Qtype:     28,
+			Ttl:       &zeroTtl,
+			Sensor:    "sensor1",
+		},
+		// Replaces the dictionaries of the first batch
+		{
+			Timestamp: 1714564802,
+			Rcode:     3,
+			Truncated: true,
+			Qname:     "missing.example.org.",
+			Rname:     &cname,
+			Source:    "capture.pcap",
+		},
+		{
+			Timestamp: 1714564803,
+			Qname:     "bücher.example.",
+			Qtype:     16,
+			Source:    "capture.pcap",
+			Sensor:    "sensor2",
+		},
+		{
+			Timestamp: 1714564804,
+			Qname:     "www.example.com.",
+		},
+	}
+}
+
+// writeArrowTest writes records in the arrow format with the given batch
+// size and returns the stream.
+func writeArrowTest(t *testing.T, records []*DnsSchema, batchSize int) *os.File {
+	t.Helper()
+	out, err := os.CreateTemp(t.TempDir(), "arrow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { out.Close() })
+
+	stdout, size := os.Stdout, Arrow.BatchSize
+	os.Stdout, Arrow.BatchSize = out, batchSize
+	defer func() { os.Stdout, Arrow.BatchSize = stdout, size }()
+
+	toArrowInitializer()
+	for _, d := range records {
+		toArrow(d)
+	}
+	toArrowCloser()
+
+	if _, err := out.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestArrowStream(t *testing.T) {
+	records := arrowTestRecords()
+
+	for _, batchSize := range []int{1, 2, 100} {
+		r, err := ipc.NewReader(writeArrowTest(t, records, batchSize))
+		if err != nil {
+			t.Fatalf("batch size %d: %v", batchSize, err)
+		}
+
+		schema := r.Schema()
+		if schema.NumFields() != len(Fields) {
+			t.Fatalf("batch size %d: %d fields, want %d", batchSize, schema.NumFields(), len(Fields))
+		}
+		for i, f := range Fields {
+			field := schema.Field(i)
+			if field.Name != f.name {
+				t.Errorf("batch size %d: field %d is %q, want %q", batchSize, i, field.Name, f.name)
+			}
+			if _, ok := field.Type.(*arrow.DictionaryType); ok != arrowDictionaryFields[f.name] {
+				t.Errorf("batch size %d: field %q has type %v", batchSize, f.name, field.Type)
+			}
+		}
+
+		row := 0
+		for r.Next() {
+			batch := r.Record()
+			for i := 0; i < int(batch.NumRows()); i, row = i+1, row+1 {
+				if row >= len(records) {
+					t.Fatalf("batch size %d: more than %d rows", batchSize, len(records))
+				}
+				v := recordValue(records[row])
+				for j, f := range Fields {
+					column := batch.Column(j)
+					want, ok := formatField(v, f)
+					if f.omitEmpty && v.Field(f.index).IsZero() {
+						ok = false
+					}
+
+					if column.IsNull(i) != !ok {
+						t.Errorf("batch size %d, row %d: %s is null %v, want %v", batchSize, row, f.name, column.IsNull(i), !ok)
+					} else if got := column.ValueStr(i); ok && got != want {
+						t.Errorf("batch size %d, row %d: %s is %q, want %q", batchSize, row, f.name, got, want)
+					}
+				}
+			}
+		}
+		if err := r.Err(); err != nil {
+			t.Fatalf("batch size %d: %v", batchSize, err)
+		}
+		if row != len(records) {
+			t.Errorf("batch size %d: read %d rows, want %d", batchSize, row, len(records))
+		}
+		r.Release()
+	}
+}
@@ -0,0 +1,144 @@
+package iohandlers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/hamba/avro/v2"
+	"github.com/rs/zerolog/log"
+)
+
+func init() {
+	Initializers["avro-registry"] = toAvroRegistryInitializer
+	Marshalers["avro-registry"] = toAvroRegistry
+	Closers["avro-registry"] = toAvroRegistryCloser
+	Flushers["avro-registry"] = toAvroRegistryCloser
+	Encoders["avro-registry"] = avroRegistryRecord
+}
+
+// The avro-registry format writes each record in the Confluent Schema
+// Registry wire format: a zero magic byte and the big-endian schema ID,
+// followed by the Avro binary encoding of the record. Since the records are
+// written to a stream, each one is prefixed with its big-endian length.
+const registryMagicByte = 0
+
+var (
+	// RegistryUrl is the base URL of the schema registry used to register
+	// the schema when no RegistrySchemaId is given.
+	RegistryUrl = ""
+	// RegistrySubject is the subject the schema is registered under.
+	RegistrySubject = "rickybobby-value"
+	// RegistrySchemaId is a schema ID assigned ahead of time.
+	RegistrySchemaId = 0
+	// RegistryClient is used for requests to the schema registry.
+	RegistryClient = http.DefaultClient
+
+	registrySchema avro.Schema
+	registryHeader [5]byte
+	registryWriter *bufio.Writer
+)
+
+// registerSchema registers a schema with the registry and returns its ID.
+// Registering a schema that already exists returns the existing ID.
+func registerSchema(registryUrl, subject, schema string) (int, error) {
+	body, err := json.Marshal(map[string]string{"schema": schema})
+	if err != nil {
+		return 0, err
+	}
+
+	endpoint := strings.TrimSuffix(registryUrl, "/") + "/subjects/" + url.PathEscape(subject) + "/versions"
+	resp, err := RegistryClient.Post(endpoint, "application/vnd.schemaregistry.v1+json", bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Id        int    `json:"id"`
+		ErrorCode int    `json:"error_code"`
+		Message   string `json:"message"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return 0, fmt.Errorf("schema registry returned %s: %v", resp.Status, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("schema registry returned %s: %s (error code %d)",
+			resp.Status, result.Message, result.ErrorCode)
+	}
+	return result.Id, nil
+}
+
+// initAvroRegistry parses the schema and resolves the schema ID that is
+// written in front of every record.
+func initAvroRegistry() {
+	initAvroData()
+
+	schema := AvroSchema()
+	var err error
+	registrySchema, err = avro.Parse(schema)
+	if err != nil {
+		log.Fatal().Msgf("Error parsing Avro schema: %v", err)
+	}
+
+	schemaId := RegistrySchemaId
+	if schemaId == 0 {
+		if RegistryUrl == "" {
+			log.Fatal().Msg("Either a schema registry URL or a schema ID is required")
+		}
+		schemaId, err = registerSchema(RegistryUrl, RegistrySubject, schema)
+		if err != nil {
+			log.Fatal().Msgf("Error registering Avro schema: %v", err)
+		}
+		log.Info().Msgf("Registered Avro schema for subject %s with ID %d", RegistrySubject, schemaId)
+	}
+
+	registryHeader[0] = registryMagicByte
+	binary.BigEndian.PutUint32(registryHeader[1:], uint32(schemaId))
+}
+
+// avroRegistryRecord returns a record in the schema registry wire format,
+// without the length prefix used when writing to a stream.
+func avroRegistryRecord(d *DnsSchema) ([]byte, error) {
+	fillAvroData(d)
+	data, err := avro.Marshal(registrySchema, avroData)
+	if err != nil {
+		return nil, err
+	}
+	return append(registryHeader[:], data...), nil
+}
+
+func toAvroRegistryInitializer() {
+	initAvroRegistry()
+	registryWriter = bufio.NewWriter(os.Stdout)
+}
+
+func toAvroRegistry(d *DnsSchema) {
+	record, err := avroRegistryRecord(d)
+	if err != nil {
+		log.Warn().Msgf("Error encoding Avro: %v", err)
+		return
+	}
+
+	var length [4]byte
+	binary.BigEndian.PutUint32(length[:], uint32(len(record)))
+	if _, err := registryWriter.Write(length[:]); err != nil {
+		log.Warn().Msgf("Error writing Avro: %v", err)
+		return
+	}
+	if _, err := registryWriter.Write(record); err != nil {
+		log.Warn().Msgf("Error writing Avro: %v", err)
+	}
+}
+
+func toAvroRegistryCloser() {
+	if err := registryWriter.Flush(); err != nil {
+		log.Warn().Msgf("%v", err)
+	}
+}
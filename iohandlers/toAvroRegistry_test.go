@@ -0,0 +1,221 @@
+package iohandlers
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/hamba/avro/v2"
+)
+
+// A registryRequest is a request received by the mock schema registry.
+type registryRequest struct {
+	path        string
+	contentType string
+	schema      string
+}
+
+// mockRegistry starts a schema registry answering every request with the
+// given status and body, and records the requests it receives.
+func mockRegistry(t *testing.T, status int, body string) (*httptest.Server, *[]registryRequest) {
+	t.Helper()
+	var requests []registryRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			Schema string `json:"schema"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			t.Errorf("decoding request: %v", err)
+		}
+		requests = append(requests, registryRequest{
+			path:        r.URL.EscapedPath(),
+			contentType: r.Header.Get("Content-Type"),
+			schema:      request.Schema,
+		})
+		w.WriteHeader(status)
+		io.WriteString(w, body)
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func TestRegisterSchema(t *testing.T) {
+	tests := []struct {
+		name    string
+		subject string
+		url     string
+		status  int
+		body    string
+		id      int
+		path    string
+		err     string
+	}{
+		{
+			name:    "registered",
+			subject: "rickybobby-value",
+			status:  http.StatusOK,
+			body:    `{"id":42}`,
+			id:      42,
+			path:    "/subjects/rickybobby-value/versions",
+		},
+		{
+			name:    "escaped subject",
+			subject: "dns/events-value",
+			url:     "/",
+			status:  http.StatusOK,
+			body:    `{"id":7}`,
+			id:      7,
+			path:    "/subjects/dns%2Fevents-value/versions",
+		},
+		{
+			name:    "conflict",
+			subject: "rickybobby-value",
+			status:  http.StatusConflict,
+			body:    `{"error_code":409,"message":"Schema being registered is incompatible with an earlier schema"}`,
+			path:    "/subjects/rickybobby-value/versions",
+			err:     "409 Conflict: Schema being registered is incompatible with an earlier schema (error code 409)",
+		},
+		{
+			name:    "invalid schema",
+			subject: "rickybobby-value",
+			status:  http.StatusUnprocessableEntity,
+			body:    `{"error_code":42201,"message":"Invalid schema"}`,
+			path:    "/subjects/rickybobby-value/versions",
+			err:     "422 Unprocessable Entity: Invalid schema (error code 42201)",
+		},
+		{
+			name:    "not json",
+			subject: "rickybobby-value",
+			status:  http.StatusBadGateway,
+			body:    "<html>Bad Gateway</html>",
+			path:    "/subjects/rickybobby-value/versions",
+			err:     "schema registry returned 502 Bad Gateway",
+		},
+	}
+
+	for _, tt := range tests {
+		server, requests := mockRegistry(t, tt.status, tt.body)
+		id, err := registerSchema(server.URL+tt.url, tt.subject, `"string"`)
+		switch {
+		case tt.err == "" && err != nil:
+			t.Errorf("%s: %v", tt.name, err)
+		case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+			t.Errorf("%s: error %v, want %q", tt.name, err, tt.err)
+		case id != tt.id:
+			t.Errorf("%s: ID %d, want %d", tt.name, id, tt.id)
+		}
+
+		if len(*requests) != 1 {
+			t.Fatalf("%s: %d requests, want 1", tt.name, len(*requests))
+		}
+		r := (*requests)[0]
+		if r.path != tt.path {
+			t.Errorf("%s: request for %s, want %s", tt.name, r.path, tt.path)
+		}
+		if r.contentType != "application/vnd.schemaregistry.v1+json" {
+			t.Errorf("%s: content type %s", tt.name, r.contentType)
+		}
+		if r.schema != `"string"` {
+			t.Errorf("%s: registered schema %s", tt.name, r.schema)
+		}
+	}
+}
+
+// avroRegistryValue formats a value decoded from Avro to compare with
+// formatField. Nullable unions decode to a map from the branch type to the
+// value.
+func avroRegistryValue(v interface{}) (string, bool) {
+	if union, ok := v.(map[string]interface{}); ok {
+		for _, value := range union {
+			v = value
+		}
+	}
+	if v == nil {
+		return "", false
+	}
+	return fmt.Sprint(v), true
+}
+
+func TestAvroRegistryRecord(t *testing.T) {
+	url, subject, id := RegistryUrl, RegistrySubject, RegistrySchemaId
+	defer func() { RegistryUrl, RegistrySubject, RegistrySchemaId = url, subject, id }()
+
+	server, requests := mockRegistry(t, http.StatusOK, `{"id":258}`)
+	RegistryUrl, RegistrySubject, RegistrySchemaId = server.URL, "dns-value", 0
+
+	out, err := os.CreateTemp(t.TempDir(), "avro-registry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	stdout := os.Stdout
+	os.Stdout = out
+	defer func() { os.Stdout = stdout }()
+
+	records := arrowTestRecords()
+	toAvroRegistryInitializer()
+	for _, d := range records {
+		toAvroRegistry(d)
+	}
+	toAvroRegistryCloser()
+
+	if len(*requests) != 1 {
+		t.Fatalf("%d requests, want 1", len(*requests))
+	}
+	if r := (*requests)[0]; r.path != "/subjects/dns-value/versions" || r.schema != AvroSchema() {
+		t.Errorf("registered %s under %s, want the Avro schema under dns-value", r.schema, r.path)
+	}
+
+	schema := avro.MustParse(AvroSchema())
+	b, err := os.ReadFile(out.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream := bytes.NewReader(b)
+	for i, d := range records {
+		var length [4]byte
+		if _, err := io.ReadFull(stream, length[:]); err != nil {
+			t.Fatalf("record %d: reading length: %v", i, err)
+		}
+		record := make([]byte, binary.BigEndian.Uint32(length[:]))
+		if _, err := io.ReadFull(stream, record); err != nil {
+			t.Fatalf("record %d: reading record: %v", i, err)
+		}
+
+		if record[0] != 0 {
+			t.Errorf("record %d: magic byte %d, want 0", i, record[0])
+		}
+		if got := binary.BigEndian.Uint32(record[1:5]); got != 258 {
+			t.Errorf("record %d: schema ID %d, want 258", i, got)
+		}
+
+		var decoded map[string]interface{}
+		if err := avro.Unmarshal(schema, record[5:], &decoded); err != nil {
+			t.Fatalf("record %d: %v", i, err)
+		}
+		if len(decoded) != len(Fields) {
+			t.Errorf("record %d: %d fields, want %d", i, len(decoded), len(Fields))
+		}
+		v := recordValue(d)
+		for _, f := range Fields {
+			want, ok := formatField(v, f)
+			if f.omitEmpty && v.Field(f.index).IsZero() {
+				ok = false
+			}
+			got, gotOk := avroRegistryValue(decoded[f.name])
+			if gotOk != ok || got != want {
+				t.Errorf("record %d: %s = %q (%v), want %q (%v)", i, f.name, got, gotOk, want, ok)
+			}
+		}
+	}
+	if stream.Len() != 0 {
+		t.Errorf("%d bytes left after the records", stream.Len())
+	}
+}
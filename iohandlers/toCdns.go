@@ -0,0 +1,103 @@
+package iohandlers
+
+import (
+	"net/netip"
+	"os"
+
+	"github.com/chazlever/rickybobby/cdns"
+	"github.com/rs/zerolog/log"
+)
+
+func init() {
+	Initializers["cdns"] = toCdnsInitializer
+	Marshalers["cdns"] = toCdns
+	Closers["cdns"] = toCdnsCloser
+}
+
+// The cdns format writes a C-DNS file (RFC 8618), which stores queries and
+// their responses as whole messages. Like the zeek format, the records of a
+// message are collected and queries are joined with their responses.
+
+// A CdnsConfig holds the options for the cdns format.
+type CdnsConfig struct {
+	// BlockSize is the maximum number of query/response items in a block.
+	BlockSize int
+	// HostId names the host the traffic was collected on in the file.
+	HostId string
+	// AnonymizationMethod is recorded in the file when addresses were
+	// anonymized.
+	AnonymizationMethod string
+}
+
+var (
+	Cdns = CdnsConfig{
+		BlockSize: 5000,
+	}
+
+	cdnsWriter *cdns.Writer
+	cdnsJoiner *transactionJoiner
+)
+
+func toCdnsInitializer() {
+	var err error
+	cdnsWriter, err = cdns.NewWriter(os.Stdout, cdns.Options{
+		BlockSize:           Cdns.BlockSize,
+		QueryTimeout:        queryTimeout,
+		GeneratorId:         "rickybobby " + Version,
+		HostId:              Cdns.HostId,
+		AnonymizationMethod: Cdns.AnonymizationMethod,
+	})
+	if err != nil {
+		log.Fatal().Msgf("Error creating C-DNS writer: %v", err)
+	}
+	cdnsJoiner = newTransactionJoiner(queryTimeout, cdnsWriteTransaction)
+}
+
+func toCdns(d *DnsSchema) {
+	cdnsJoiner.add(d)
+}
+
+func cdnsWriteTransaction(query, response *dnsMessage) {
+	first := query
+	if first == nil {
+		first = response
+	}
+	key := first.key()
+
+	qr := &cdns.QueryResponse{
+		Time:       first.time(),
+		ClientPort: key.clientPort,
+		ServerPort: key.serverPort,
+		Tcp:        !first.header().Udp,
+	}
+	var err error
+	if qr.ClientAddress, err = netip.ParseAddr(key.client); err != nil {
+		log.Warn().Msgf("Error writing C-DNS: %v", err)
+		return
+	}
+	if qr.ServerAddress, err = netip.ParseAddr(key.server); err != nil {
+		log.Warn().Msgf("Error writing C-DNS: %v", err)
+		return
+	}
+
+	if query != nil {
+		qr.Query = query.msg()
+	}
+	if response != nil {
+		qr.Response = response.msg()
+		if query != nil {
+			qr.ResponseDelay = response.time().Sub(query.time())
+		}
+	}
+
+	if err := cdnsWriter.Write(qr); err != nil {
+		log.Warn().Msgf("Error writing C-DNS: %v", err)
+	}
+}
+
+func toCdnsCloser() {
+	cdnsJoiner.close()
+	if err := cdnsWriter.Close(); err != nil {
+		log.Warn().Msgf("%v", err)
+	}
+}
@@ -0,0 +1,125 @@
+package iohandlers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"os"
+	"reflect"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/rs/zerolog/log"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+func init() {
+	for name, encoder := range map[string]func(*DnsSchema) ([]byte, error){
+		"msgpack": msgpackRecord,
+		"cbor":    cborRecord,
+	} {
+		encoder := encoder
+		Initializers[name] = toCompactInitializer
+		Marshalers[name] = func(d *DnsSchema) { toCompact(d, encoder) }
+		Closers[name] = toCompactCloser
+		Flushers[name] = toCompactCloser
+		Encoders[name] = encoder
+	}
+}
+
+// The msgpack and cbor formats write each record as a MessagePack or CBOR
+// map, holding the same keys and values as the json format: empty omitempty
+// fields are left out and null fields are nil. Unlike JSON the records can be
+// decoded without a schema at little cost. On standard output every record
+// is preceded by its length as a 4-byte big-endian integer, so consumers can
+// split the stream without decoding it.
+
+var (
+	compactWriter *bufio.Writer
+
+	// cborMode writes the keys of records in the order of the core
+	// deterministic encoding of RFC 8949, using the preferred serialization
+	// of every value.
+	cborMode, _ = cbor.EncOptions{Sort: cbor.SortCoreDeterministic}.EncMode()
+)
+
+// compactFields calls add with the name and value of every selected field of
+// a record that isn't an empty omitempty field, in order. Null fields have a
+// nil value.
+func compactFields(d *DnsSchema, add func(name string, value interface{}) error) error {
+	v := recordValue(d)
+	for _, f := range Fields {
+		fv := v.Field(f.index)
+		if f.omitEmpty && fv.IsZero() {
+			continue
+		}
+		var value interface{}
+		if !f.nullable || !fv.IsNil() {
+			value = reflect.Indirect(fv).Interface()
+		}
+		if err := add(f.name, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// msgpackRecord encodes a record as a MessagePack map with the keys in the
+// order of the fields. Integers are written in their smallest form.
+func msgpackRecord(d *DnsSchema) ([]byte, error) {
+	n := 0
+	compactFields(d, func(string, interface{}) error {
+		n++
+		return nil
+	})
+
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.UseCompactInts(true)
+	if err := enc.EncodeMapLen(n); err != nil {
+		return nil, err
+	}
+	err := compactFields(d, func(name string, value interface{}) error {
+		if err := enc.EncodeString(name); err != nil {
+			return err
+		}
+		return enc.Encode(value)
+	})
+	return buf.Bytes(), err
+}
+
+// cborRecord encodes a record as a CBOR map.
+func cborRecord(d *DnsSchema) ([]byte, error) {
+	record := make(map[string]interface{}, len(Fields))
+	compactFields(d, func(name string, value interface{}) error {
+		record[name] = value
+		return nil
+	})
+	return cborMode.Marshal(record)
+}
+
+func toCompactInitializer() {
+	compactWriter = bufio.NewWriter(os.Stdout)
+}
+
+func toCompact(d *DnsSchema, encoder func(*DnsSchema) ([]byte, error)) {
+	record, err := encoder(d)
+	if err != nil {
+		log.Warn().Msgf("Error encoding record: %v", err)
+		return
+	}
+	var length [4]byte
+	binary.BigEndian.PutUint32(length[:], uint32(len(record)))
+	if _, err := compactWriter.Write(length[:]); err != nil {
+		log.Warn().Msgf("Error writing record: %v", err)
+		return
+	}
+	if _, err := compactWriter.Write(record); err != nil {
+		log.Warn().Msgf("Error writing record: %v", err)
+	}
+}
+
+func toCompactCloser() {
+	if err := compactWriter.Flush(); err != nil {
+		log.Warn().Msgf("%v", err)
+	}
+}
@@ -0,0 +1,199 @@
+package iohandlers
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"testing"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// compactTestRecords returns records whose integers need every width, with
+// null and empty omitempty fields.
+func compactTestRecords() []*DnsSchema {
+	signed := []int64{0, 23, 24, 127, 128, 255, 256, 65535, 65536, math.MaxUint32, math.MaxUint32 + 1, math.MaxInt64,
+		-1, -24, -25, -32, -33, -128, -129, -32768, -32769, math.MinInt32, math.MinInt32 - 1, math.MinInt64}
+	unsigned := []uint32{0, 127, 128, 255, 256, 65535, 65536, math.MaxUint32}
+
+	var records []*DnsSchema
+	for i, n := range signed {
+		ttl := unsigned[i%len(unsigned)]
+		rname := "www.example.com."
+		d := &DnsSchema{
+			Timestamp:  n,
+			Rcode:      int(n),
+			SourcePort: uint16(ttl),
+			Response:   i%2 == 0,
+			Qname:      "www.example.com.",
+			Qtype:      uint16(i),
+			Ttl:        &ttl,
+			Sensor:     "sensor1",
+		}
+		if i%3 == 0 {
+			// Leaves the nullable rname null and the omitempty sensor out
+			d.Sensor = ""
+		} else {
+			d.Rname = &rname
+		}
+		records = append(records, d)
+	}
+	return records
+}
+
+// jsonValues returns the fields of a record as written by the json format,
+// formatted to compare with decoded values.
+func jsonValues(t *testing.T, d *DnsSchema) map[string]string {
+	t.Helper()
+	b, err := json.Marshal(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var record map[string]interface{}
+	if err := dec.Decode(&record); err != nil {
+		t.Fatal(err)
+	}
+	return compactValues(record)
+}
+
+func compactValues(record map[string]interface{}) map[string]string {
+	values := make(map[string]string, len(record))
+	for k, v := range record {
+		values[k] = fmt.Sprint(v)
+	}
+	return values
+}
+
+func compareCompact(t *testing.T, format string, i int, got map[string]interface{}, want map[string]string) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Errorf("%s record %d: %d keys, want %d: %v", format, i, len(got), len(want), got)
+	}
+	for k, v := range compactValues(got) {
+		if w, ok := want[k]; !ok || v != w {
+			t.Errorf("%s record %d: %s = %s, want %s", format, i, k, v, w)
+		}
+	}
+}
+
+// writeCompactTest writes records in a compact format and returns the
+// stream.
+func writeCompactTest(t *testing.T, format string, records []*DnsSchema) []byte {
+	t.Helper()
+	out, err := os.CreateTemp(t.TempDir(), format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+
+	stdout := os.Stdout
+	os.Stdout = out
+	defer func() { os.Stdout = stdout }()
+
+	Initializers[format]()
+	for _, d := range records {
+		Marshalers[format](d)
+	}
+	Closers[format]()
+
+	b, err := os.ReadFile(out.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestCompactRoundTrip(t *testing.T) {
+	records := compactTestRecords()
+	decoders := map[string]func([]byte, *map[string]interface{}) error{
+		"msgpack": func(b []byte, v *map[string]interface{}) error { return msgpack.Unmarshal(b, v) },
+		"cbor":    func(b []byte, v *map[string]interface{}) error { return cbor.Unmarshal(b, v) },
+	}
+
+	for format, decode := range decoders {
+		stream := bytes.NewReader(writeCompactTest(t, format, records))
+		for i, d := range records {
+			var length [4]byte
+			if _, err := io.ReadFull(stream, length[:]); err != nil {
+				t.Fatalf("%s record %d: reading length: %v", format, i, err)
+			}
+			b := make([]byte, binary.BigEndian.Uint32(length[:]))
+			if _, err := io.ReadFull(stream, b); err != nil {
+				t.Fatalf("%s record %d: reading record: %v", format, i, err)
+			}
+
+			var record map[string]interface{}
+			if err := decode(b, &record); err != nil {
+				t.Fatalf("%s record %d: %v", format, i, err)
+			}
+			compareCompact(t, format, i, record, jsonValues(t, d))
+		}
+		if stream.Len() != 0 {
+			t.Errorf("%s: %d bytes left after the records", format, stream.Len())
+		}
+	}
+}
+
+func TestCompactIntegerWidths(t *testing.T) {
+	// Integers take the smallest form their value fits in
+	tests := []struct {
+		n       int64
+		msgpack int
+		cbor    int
+	}{
+		{0, 1, 1},
+		{23, 1, 1},
+		{24, 1, 2},
+		{127, 1, 2},
+		{128, 2, 2},
+		{255, 2, 2},
+		{256, 3, 3},
+		{65535, 3, 3},
+		{65536, 5, 5},
+		{math.MaxUint32, 5, 5},
+		{math.MaxUint32 + 1, 9, 9},
+		{-1, 1, 1},
+		{-24, 1, 1},
+		{-25, 1, 2},
+		{-32, 1, 2},
+		{-33, 2, 2},
+		{-128, 2, 2},
+		{-129, 3, 2},
+		{-32768, 3, 3},
+		{-32769, 5, 3},
+		{math.MinInt64, 9, 9},
+	}
+
+	fields, selected := Fields, fieldsSelected
+	defer func() { Fields, fieldsSelected = fields, selected }()
+	if err := SelectFields([]string{"timestamp"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The map header, the key and the value
+	key := len("timestamp") + 2
+	for _, tt := range tests {
+		d := &DnsSchema{Timestamp: tt.n}
+		b, err := msgpackRecord(d)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := len(b) - key; got != tt.msgpack {
+			t.Errorf("msgpack %d takes %d bytes, want %d", tt.n, got, tt.msgpack)
+		}
+		b, err = cborRecord(d)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := len(b) - key; got != tt.cbor {
+			t.Errorf("cbor %d takes %d bytes, want %d", tt.n, got, tt.cbor)
+		}
+	}
+}
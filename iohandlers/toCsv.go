@@ -0,0 +1,110 @@
+package iohandlers
+
+import (
+	"bufio"
+	"encoding/csv"
+	"os"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+func init() {
+	Initializers["csv"] = toCsvInitializer
+	Marshalers["csv"] = toCsv
+	Closers["csv"] = toCsvCloser
+	Flushers["csv"] = toCsvCloser
+
+	Initializers["tsv"] = toTsvInitializer
+	Marshalers["tsv"] = toTsv
+	Closers["tsv"] = toTsvCloser
+	Flushers["tsv"] = toTsvCloser
+}
+
+var (
+	// NullValue is written in place of null fields in delimited formats.
+	NullValue = ""
+
+	csvWriter *csv.Writer
+	tsvWriter *bufio.Writer
+	row       []string
+
+	// TSV can't quote values, so the delimiters are escaped instead
+	tsvEscaper = strings.NewReplacer(`\`, `\\`, "\t", `\t`, "\n", `\n`, "\r", `\r`)
+)
+
+func header() []string {
+	names := make([]string, len(Fields))
+	for i, f := range Fields {
+		names[i] = f.name
+	}
+	return names
+}
+
+// fillRow formats the selected fields of a record into row. The escape
+// function is applied to every value except for the null representation.
+func fillRow(d *DnsSchema, escape func(string) string) {
+	v := recordValue(d)
+	row = row[:0]
+	for _, f := range Fields {
+		s, ok := formatField(v, f)
+		if !ok {
+			row = append(row, NullValue)
+			continue
+		}
+		row = append(row, escape(s))
+	}
+}
+
+func noEscape(s string) string {
+	return s
+}
+
+func toCsvInitializer() {
+	csvWriter = csv.NewWriter(os.Stdout)
+	if err := csvWriter.Write(header()); err != nil {
+		log.Fatal().Msgf("Error writing CSV header: %v", err)
+	}
+}
+
+func toCsv(d *DnsSchema) {
+	fillRow(d, noEscape)
+	if err := csvWriter.Write(row); err != nil {
+		log.Warn().Msgf("Error writing CSV: %v", err)
+	}
+}
+
+func toCsvCloser() {
+	csvWriter.Flush()
+	if err := csvWriter.Error(); err != nil {
+		log.Warn().Msgf("%v", err)
+	}
+}
+
+func toTsvInitializer() {
+	tsvWriter = bufio.NewWriter(os.Stdout)
+	writeTsvRow(header())
+}
+
+func writeTsvRow(fields []string) {
+	for i, s := range fields {
+		if i > 0 {
+			tsvWriter.WriteByte('\t')
+		}
+		tsvWriter.WriteString(s)
+	}
+	if err := tsvWriter.WriteByte('\n'); err != nil {
+		log.Warn().Msgf("Error writing TSV: %v", err)
+	}
+}
+
+func toTsv(d *DnsSchema) {
+	fillRow(d, tsvEscaper.Replace)
+	writeTsvRow(row)
+}
+
+func toTsvCloser() {
+	if err := tsvWriter.Flush(); err != nil {
+		log.Warn().Msgf("%v", err)
+	}
+}
@@ -1,6 +1,7 @@
 package iohandlers
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 
@@ -9,12 +10,46 @@ import (
 
 func init() {
 	Marshalers["json"] = toJson
+	Encoders["json"] = jsonRecord
+}
+
+// jsonRecord encodes the selected fields of a record as a JSON object.
+func jsonRecord(d *DnsSchema) ([]byte, error) {
+	if !fieldsSelected {
+		if d.Summary != nil {
+			return json.Marshal(d.Summary)
+		}
+		return json.Marshal(d)
+	}
+
+	// Only encode the selected fields, keeping them in the order given
+	var buf bytes.Buffer
+	v := recordValue(d)
+	buf.WriteByte('{')
+	for _, f := range Fields {
+		fv := v.Field(f.index)
+		if f.omitEmpty && fv.IsZero() {
+			continue
+		}
+		if buf.Len() > 1 {
+			buf.WriteByte(',')
+		}
+		fmt.Fprintf(&buf, "%q:", f.name)
+		jsonData, err := json.Marshal(fv.Interface())
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(jsonData)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
 }
 
 func toJson(d *DnsSchema) {
-	jsonData, err := json.Marshal(d)
+	jsonData, err := jsonRecord(d)
 	if err != nil {
 		log.Warn().Msgf("Error converting to JSON: %v", err)
+		return
 	}
 	fmt.Printf("%s\n", jsonData)
 }
@@ -0,0 +1,181 @@
+package iohandlers
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/rs/zerolog/log"
+)
+
+func init() {
+	for name, encoder := range map[string]func(*DnsSchema) ([]byte, error){
+		"sd":   sdRecord,
+		"cef":  cefRecord,
+		"leef": leefRecord,
+	} {
+		encoder := encoder
+		Initializers[name] = toKeyValueInitializer
+		Marshalers[name] = func(d *DnsSchema) { toKeyValue(d, encoder) }
+		Closers[name] = toKeyValueCloser
+		Flushers[name] = toKeyValueCloser
+		Encoders[name] = encoder
+	}
+}
+
+// The sd, cef and leef formats write one line per record with the selected
+// fields as key-value pairs, as expected by SIEMs that can't parse JSON:
+//
+//   - sd is an RFC 5424 structured data element, which the syslog output
+//     places in the STRUCTURED-DATA part of the message.
+//   - cef is the ArcSight Common Event Format.
+//   - leef is the IBM QRadar Log Event Extended Format.
+//
+// Null fields are left out. The CEF and LEEF formats use the standard keys
+// for the addresses, ports and time of a record and the JSON names for all
+// other fields.
+
+const (
+	// sdId is the SD-ID of the structured data element. 32473 is the
+	// private enterprise number reserved for documentation by RFC 5612.
+	sdId = "dns@32473"
+
+	vendor  = "rickybobby"
+	product = "rickybobby"
+)
+
+var (
+	// Version is the version of the application reported in CEF and LEEF
+	// headers.
+	Version = ""
+
+	keyValueWriter *bufio.Writer
+
+	sdEscaper   = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+	cefHeader   = strings.NewReplacer(`\`, `\\`, `|`, `\|`)
+	cefEscaper  = strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\n", `\n`, "\r", `\r`)
+	leefEscaper = strings.NewReplacer(`\`, `\\`, "\t", `\t`, "\n", `\n`, "\r", `\r`)
+
+	cefKeys = map[string]string{
+		"src_address": "src",
+		"src_port":    "spt",
+		"dst_address": "dst",
+		"dst_port":    "dpt",
+		"sensor":      "dvchost",
+	}
+	leefKeys = map[string]string{
+		"src_address": "src",
+		"src_port":    "srcPort",
+		"dst_address": "dst",
+		"dst_port":    "dstPort",
+		"sensor":      "identHostName",
+	}
+)
+
+// eachField calls fn with the name and value of every selected field that
+// isn't null or an omitted empty value.
+func eachField(d *DnsSchema, fn func(name, value string)) {
+	v := recordValue(d)
+	for _, f := range Fields {
+		if f.omitEmpty && v.Field(f.index).IsZero() {
+			continue
+		}
+		if s, ok := formatField(v, f); ok {
+			fn(f.name, s)
+		}
+	}
+}
+
+func sdRecord(d *DnsSchema) ([]byte, error) {
+	var b strings.Builder
+	b.WriteString("[" + sdId)
+	eachField(d, func(name, value string) {
+		fmt.Fprintf(&b, ` %s="%s"`, name, sdEscaper.Replace(value))
+	})
+	b.WriteString("]")
+	return []byte(b.String()), nil
+}
+
+// eventName describes a record for the CEF and LEEF headers.
+func eventName(d *DnsSchema) (string, string) {
+	switch {
+	case d.Rtype != nil:
+		return "dns-" + strings.ToLower(dns.TypeToString[*d.Rtype]), "DNS " + dns.TypeToString[*d.Rtype] + " record"
+	case d.Response:
+		return "dns-response", "DNS " + dns.RcodeToString[d.Rcode] + " response"
+	}
+	return "dns-query", "DNS " + dns.TypeToString[d.Qtype] + " query"
+}
+
+func cefRecord(d *DnsSchema) ([]byte, error) {
+	var b strings.Builder
+	id, name := eventName(d)
+	fmt.Fprintf(&b, "CEF:0|%s|%s|%s|%s|%s|1|",
+		vendor, product, cefHeader.Replace(Version), id, cefHeader.Replace(name))
+
+	first := true
+	eachField(d, func(name, value string) {
+		if !first {
+			b.WriteByte(' ')
+		}
+		first = false
+
+		switch {
+		case name == "timestamp":
+			// CEF receipt time is in milliseconds
+			name = "rt"
+			value += "000"
+		case cefKeys[name] != "":
+			name = cefKeys[name]
+		}
+		b.WriteString(name + "=" + cefEscaper.Replace(value))
+	})
+	return []byte(b.String()), nil
+}
+
+func leefRecord(d *DnsSchema) ([]byte, error) {
+	var b strings.Builder
+	id, _ := eventName(d)
+	fmt.Fprintf(&b, "LEEF:1.0|%s|%s|%s|%s|", vendor, product, Version, id)
+
+	first := true
+	eachField(d, func(name, value string) {
+		if !first {
+			b.WriteByte('\t')
+		}
+		first = false
+
+		switch {
+		case name == "timestamp":
+			seconds, _ := strconv.ParseInt(value, 10, 64)
+			name = "devTime"
+			value = time.Unix(seconds, 0).UTC().Format("Jan 02 2006 15:04:05")
+		case leefKeys[name] != "":
+			name = leefKeys[name]
+		}
+		b.WriteString(name + "=" + leefEscaper.Replace(value))
+	})
+	return []byte(b.String()), nil
+}
+
+func toKeyValueInitializer() {
+	keyValueWriter = bufio.NewWriter(os.Stdout)
+}
+
+func toKeyValue(d *DnsSchema, encode func(*DnsSchema) ([]byte, error)) {
+	record, _ := encode(d)
+	keyValueWriter.Write(record)
+	if err := keyValueWriter.WriteByte('\n'); err != nil {
+		log.Warn().Msgf("Error writing record: %v", err)
+	}
+}
+
+func toKeyValueCloser() {
+	if err := keyValueWriter.Flush(); err != nil {
+		log.Warn().Msgf("%v", err)
+	}
+}
@@ -0,0 +1,131 @@
+package iohandlers
+
+import (
+	"bufio"
+	"encoding/binary"
+	"os"
+	"reflect"
+
+	"github.com/rs/zerolog/log"
+)
+
+func init() {
+	Initializers["rowbinary"] = toRowBinaryInitializer
+	Marshalers["rowbinary"] = toRowBinary
+	Closers["rowbinary"] = toRowBinaryCloser
+	Flushers["rowbinary"] = toRowBinaryCloser
+	Encoders["rowbinary"] = rowBinaryRecord
+}
+
+// The rowbinary format is the ClickHouse RowBinary format, where the columns
+// of a row are written one after the other without any delimiters. The
+// column types are given by ClickHouseSchema.
+
+var rowBinaryWriter *bufio.Writer
+
+// clickHouseType returns the ClickHouse column type for a field.
+func clickHouseType(t reflect.Type) string {
+	nullable := t.Kind() == reflect.Ptr
+	if nullable {
+		t = t.Elem()
+	}
+
+	var typ string
+	switch t.Kind() {
+	case reflect.Bool:
+		typ = "Bool"
+	case reflect.String:
+		typ = "String"
+	case reflect.Int8:
+		typ = "Int8"
+	case reflect.Int16:
+		typ = "Int16"
+	case reflect.Int32:
+		typ = "Int32"
+	case reflect.Int, reflect.Int64:
+		typ = "Int64"
+	case reflect.Uint8:
+		typ = "UInt8"
+	case reflect.Uint16:
+		typ = "UInt16"
+	case reflect.Uint32:
+		typ = "UInt32"
+	case reflect.Uint, reflect.Uint64:
+		typ = "UInt64"
+	default:
+		panic("iohandlers: no ClickHouse type for " + t.String())
+	}
+
+	if nullable {
+		return "Nullable(" + typ + ")"
+	}
+	return typ
+}
+
+// appendRowBinary appends the RowBinary encoding of a value. Integers are
+// little-endian, booleans a single byte and strings are prefixed with their
+// length as an unsigned varint.
+func appendRowBinary(buf []byte, v reflect.Value) []byte {
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			return append(buf, 1)
+		}
+		return append(buf, 0)
+	case reflect.String:
+		buf = binary.AppendUvarint(buf, uint64(v.Len()))
+		return append(buf, v.String()...)
+	case reflect.Int8:
+		return append(buf, byte(v.Int()))
+	case reflect.Int16:
+		return binary.LittleEndian.AppendUint16(buf, uint16(v.Int()))
+	case reflect.Int32:
+		return binary.LittleEndian.AppendUint32(buf, uint32(v.Int()))
+	case reflect.Int, reflect.Int64:
+		return binary.LittleEndian.AppendUint64(buf, uint64(v.Int()))
+	case reflect.Uint8:
+		return append(buf, byte(v.Uint()))
+	case reflect.Uint16:
+		return binary.LittleEndian.AppendUint16(buf, uint16(v.Uint()))
+	case reflect.Uint32:
+		return binary.LittleEndian.AppendUint32(buf, uint32(v.Uint()))
+	default:
+		return binary.LittleEndian.AppendUint64(buf, v.Uint())
+	}
+}
+
+// rowBinaryRecord encodes the selected fields of a record as a single row.
+func rowBinaryRecord(d *DnsSchema) ([]byte, error) {
+	v := recordValue(d)
+	buf := make([]byte, 0, 256)
+	for _, f := range Fields {
+		fv := v.Field(f.index)
+		if f.nullable {
+			if fv.IsNil() {
+				buf = append(buf, 1)
+				continue
+			}
+			buf = append(buf, 0)
+			fv = fv.Elem()
+		}
+		buf = appendRowBinary(buf, fv)
+	}
+	return buf, nil
+}
+
+func toRowBinaryInitializer() {
+	rowBinaryWriter = bufio.NewWriter(os.Stdout)
+}
+
+func toRowBinary(d *DnsSchema) {
+	row, _ := rowBinaryRecord(d)
+	if _, err := rowBinaryWriter.Write(row); err != nil {
+		log.Warn().Msgf("Error writing RowBinary: %v", err)
+	}
+}
+
+func toRowBinaryCloser() {
+	if err := rowBinaryWriter.Flush(); err != nil {
+		log.Warn().Msgf("%v", err)
+	}
+}
@@ -0,0 +1,467 @@
+package iohandlers
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/rs/zerolog/log"
+)
+
+func init() {
+	Initializers["zeek"] = toZeekInitializer
+	Marshalers["zeek"] = toZeek
+	Closers["zeek"] = toZeekCloser
+
+	Initializers["zeek-json"] = toZeekJsonInitializer
+	Marshalers["zeek-json"] = toZeek
+	Closers["zeek-json"] = toZeekCloser
+}
+
+// The zeek and zeek-json formats write the dns.log of Zeek in its TSV and
+// JSON variants. Like Zeek, a row describes a whole transaction: the records
+// of a packet are collected into one row, and a query is joined with its
+// response when both are seen.
+
+var zeekFields = []struct{ name, typ string }{
+	{"ts", "time"},
+	{"uid", "string"},
+	{"id.orig_h", "addr"},
+	{"id.orig_p", "port"},
+	{"id.resp_h", "addr"},
+	{"id.resp_p", "port"},
+	{"proto", "enum"},
+	{"trans_id", "count"},
+	{"rtt", "interval"},
+	{"query", "string"},
+	{"qclass", "count"},
+	{"qclass_name", "string"},
+	{"qtype", "count"},
+	{"qtype_name", "string"},
+	{"rcode", "count"},
+	{"rcode_name", "string"},
+	{"AA", "bool"},
+	{"TC", "bool"},
+	{"RD", "bool"},
+	{"RA", "bool"},
+	{"Z", "count"},
+	{"answers", "vector[string]"},
+	{"TTLs", "vector[interval]"},
+	{"rejected", "bool"},
+}
+
+var zeekClasses = map[uint16]string{
+	1:   "C_INTERNET",
+	2:   "C_CSNET",
+	3:   "C_CHAOS",
+	4:   "C_HESIOD",
+	254: "C_NONE",
+	255: "C_ANY",
+}
+
+// A zeekTransaction is a single row of dns.log.
+type zeekTransaction struct {
+	key transactionKey
+	ts  time.Time
+	udp bool
+
+	rtt    time.Duration
+	hasRtt bool
+
+	query          string
+	qclass, qtype  uint16
+	rcode          int
+	hasRcode       bool
+	aa, tc, rd, ra bool
+	z              uint8
+	answers        []string
+	ttls           []uint32
+	rejected       bool
+}
+
+var (
+	zeekWriter *bufio.Writer
+	zeekJson   bool
+	zeekJoiner *transactionJoiner
+)
+
+func zeekInitialize(asJson bool) {
+	zeekWriter = bufio.NewWriter(os.Stdout)
+	zeekJson = asJson
+	zeekJoiner = newTransactionJoiner(queryTimeout, zeekWriteTransaction)
+}
+
+// toZeekInitializer writes the header block describing the columns.
+func toZeekInitializer() {
+	zeekInitialize(false)
+
+	names := make([]string, len(zeekFields))
+	types := make([]string, len(zeekFields))
+	for i, f := range zeekFields {
+		names[i] = f.name
+		types[i] = f.typ
+	}
+	fmt.Fprintf(zeekWriter, "#separator \\x09\n")
+	fmt.Fprintf(zeekWriter, "#set_separator\t,\n")
+	fmt.Fprintf(zeekWriter, "#empty_field\t(empty)\n")
+	fmt.Fprintf(zeekWriter, "#unset_field\t-\n")
+	fmt.Fprintf(zeekWriter, "#path\tdns\n")
+	fmt.Fprintf(zeekWriter, "#open\t%s\n", time.Now().Format("2006-01-02-15-04-05"))
+	fmt.Fprintf(zeekWriter, "#fields\t%s\n", strings.Join(names, "\t"))
+	fmt.Fprintf(zeekWriter, "#types\t%s\n", strings.Join(types, "\t"))
+}
+
+func toZeekJsonInitializer() {
+	zeekInitialize(true)
+}
+
+// setMessage fills in the fields of the transaction that come from the header
+// of a message. The response overrides the flags of the query.
+func (t *zeekTransaction) setMessage(m *dnsMessage) {
+	d := m.header()
+	t.tc = d.Truncated
+	t.rd = d.RecursionDesired
+	if d.Response {
+		t.rcode, t.hasRcode = d.Rcode, true
+		t.rejected = d.Rdata == nil
+	}
+
+	if msg := d.Msg; msg != nil {
+		t.aa = msg.Authoritative
+		t.ra = msg.RecursionAvailable
+		// Zeek reports the Z, AD and CD bits together
+		t.z = 0
+		if msg.Zero {
+			t.z |= 4
+		}
+		if msg.AuthenticatedData {
+			t.z |= 2
+		}
+		if msg.CheckingDisabled {
+			t.z |= 1
+		}
+		// A response is rejected when it carries no RRs at all
+		t.rejected = d.Response && len(msg.Answer)+len(msg.Ns)+len(msg.Extra) == 0
+	}
+}
+
+// newZeekTransaction creates the row for a query and its response, either of
+// which may be missing.
+func newZeekTransaction(query, response *dnsMessage) *zeekTransaction {
+	first := query
+	if first == nil {
+		first = response
+	}
+	d := first.header()
+
+	t := &zeekTransaction{
+		key:    first.key(),
+		ts:     first.time(),
+		udp:    d.Udp,
+		query:  d.Qname,
+		qclass: dns.ClassINET,
+		qtype:  d.Qtype,
+	}
+	if msg := d.Msg; msg != nil && len(msg.Question) > 0 {
+		t.qclass = msg.Question[len(msg.Question)-1].Qclass
+	}
+
+	if query != nil {
+		t.setMessage(query)
+	}
+	if response != nil {
+		t.setMessage(response)
+		if query != nil {
+			t.rtt, t.hasRtt = response.time().Sub(query.time()), true
+		}
+		for i := range response.records {
+			if r := &response.records[i]; r.Answer && r.Rdata != nil {
+				t.answers = append(t.answers, zeekAnswer(*r.Rtype, *r.Rdata))
+				t.ttls = append(t.ttls, *r.Ttl)
+			}
+		}
+	}
+	return t
+}
+
+// zeekAnswer returns an answer the way Zeek logs it, which is the address
+// or name it points to for the common types.
+func zeekAnswer(rtype uint16, rdata string) string {
+	f := strings.Fields(rdata)
+	if len(f) == 0 {
+		return rdata
+	}
+
+	switch rtype {
+	case dns.TypeCNAME, dns.TypeNS, dns.TypePTR, dns.TypeDNAME:
+		return zeekName(f[0])
+	case dns.TypeMX, dns.TypeSRV:
+		return zeekName(f[len(f)-1])
+	case dns.TypeSOA:
+		return zeekName(f[0])
+	case dns.TypeTXT, dns.TypeSPF:
+		text := make([]string, 0, 1)
+		for _, s := range strings.Split(strings.TrimSpace(rdata), `" "`) {
+			text = append(text, strings.Trim(s, `"`))
+		}
+		joined := strings.Join(text, " ")
+		return fmt.Sprintf("TXT %d %s", len(joined), joined)
+	}
+	return rdata
+}
+
+// zeekName removes the trailing dot Zeek doesn't log from a name.
+func zeekName(name string) string {
+	if name == "." {
+		return name
+	}
+	return strings.TrimSuffix(name, ".")
+}
+
+// zeekUid derives a connection identifier in the style of Zeek from the
+// connection endpoints, so all transactions of a connection share it.
+func zeekUid(t *zeekTransaction) string {
+	h := sha256.New()
+	proto := "udp"
+	if !t.udp {
+		proto = "tcp"
+	}
+	fmt.Fprintf(h, "%s|%s|%d|%s|%d", proto, t.key.client, t.key.clientPort, t.key.server, t.key.serverPort)
+	sum := h.Sum(nil)
+	return "C" + new(big.Int).SetBytes(sum[:12]).Text(62)
+}
+
+func toZeek(d *DnsSchema) {
+	zeekJoiner.add(d)
+}
+
+func zeekWriteTransaction(query, response *dnsMessage) {
+	zeekWrite(newZeekTransaction(query, response))
+}
+
+func zeekWrite(t *zeekTransaction) {
+	var err error
+	if zeekJson {
+		err = zeekWriteJson(t)
+	} else {
+		err = zeekWriteTsv(t)
+	}
+	if err != nil {
+		log.Warn().Msgf("Error writing Zeek log: %v", err)
+	}
+}
+
+// zeekEscape escapes a value for the TSV log, including the set separator
+// when the value is an element of a set or vector.
+func zeekEscape(s string, inSet bool) string {
+	if s == "" {
+		return "(empty)"
+	}
+	if s == "-" {
+		return `\x2d`
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c < 0x20 || c >= 0x7f || c == '\\' || (inSet && c == ',') {
+			fmt.Fprintf(&b, `\x%02x`, c)
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+func zeekTime(t time.Time) string {
+	return fmt.Sprintf("%d.%06d", t.Unix(), t.Nanosecond()/1000)
+}
+
+func zeekInterval(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', 6, 64)
+}
+
+func zeekBool(b bool) string {
+	if b {
+		return "T"
+	}
+	return "F"
+}
+
+func zeekQtypeName(qtype uint16) string {
+	if name, ok := dns.TypeToString[qtype]; ok {
+		return name
+	}
+	return fmt.Sprintf("query-%d", qtype)
+}
+
+func zeekQclassName(qclass uint16) string {
+	if name, ok := zeekClasses[qclass]; ok {
+		return name
+	}
+	return fmt.Sprintf("qclass-%d", qclass)
+}
+
+func zeekRcodeName(rcode int) string {
+	if name, ok := dns.RcodeToString[rcode]; ok {
+		return name
+	}
+	return fmt.Sprintf("rcode-%d", rcode)
+}
+
+func zeekProto(t *zeekTransaction) string {
+	if t.udp {
+		return "udp"
+	}
+	return "tcp"
+}
+
+func zeekWriteTsv(t *zeekTransaction) error {
+	row := make([]string, 0, len(zeekFields))
+	row = append(row,
+		zeekTime(t.ts),
+		zeekUid(t),
+		t.key.client,
+		strconv.Itoa(int(t.key.clientPort)),
+		t.key.server,
+		strconv.Itoa(int(t.key.serverPort)),
+		zeekProto(t),
+		strconv.Itoa(int(t.key.id)),
+	)
+
+	if t.hasRtt {
+		row = append(row, zeekInterval(t.rtt))
+	} else {
+		row = append(row, "-")
+	}
+
+	row = append(row,
+		zeekEscape(zeekName(t.query), false),
+		strconv.Itoa(int(t.qclass)),
+		zeekQclassName(t.qclass),
+		strconv.Itoa(int(t.qtype)),
+		zeekQtypeName(t.qtype),
+	)
+
+	if t.hasRcode {
+		row = append(row, strconv.Itoa(t.rcode), zeekRcodeName(t.rcode))
+	} else {
+		row = append(row, "-", "-")
+	}
+
+	row = append(row,
+		zeekBool(t.aa),
+		zeekBool(t.tc),
+		zeekBool(t.rd),
+		zeekBool(t.ra),
+		strconv.Itoa(int(t.z)),
+	)
+
+	if len(t.answers) > 0 {
+		answers := make([]string, len(t.answers))
+		ttls := make([]string, len(t.ttls))
+		for i := range t.answers {
+			answers[i] = zeekEscape(t.answers[i], true)
+			ttls[i] = zeekInterval(time.Duration(t.ttls[i]) * time.Second)
+		}
+		row = append(row, strings.Join(answers, ","), strings.Join(ttls, ","))
+	} else {
+		row = append(row, "-", "-")
+	}
+
+	row = append(row, zeekBool(t.rejected))
+
+	_, err := zeekWriter.WriteString(strings.Join(row, "\t") + "\n")
+	return err
+}
+
+// zeekJsonRow is a row of dns.log as written by Zeek with JSON logs enabled,
+// which leaves out fields that are unset.
+type zeekJsonRow struct {
+	Ts         float64   `json:"ts"`
+	Uid        string    `json:"uid"`
+	OrigH      string    `json:"id.orig_h"`
+	OrigP      uint16    `json:"id.orig_p"`
+	RespH      string    `json:"id.resp_h"`
+	RespP      uint16    `json:"id.resp_p"`
+	Proto      string    `json:"proto"`
+	TransId    uint16    `json:"trans_id"`
+	Rtt        *float64  `json:"rtt,omitempty"`
+	Query      string    `json:"query"`
+	Qclass     uint16    `json:"qclass"`
+	QclassName string    `json:"qclass_name"`
+	Qtype      uint16    `json:"qtype"`
+	QtypeName  string    `json:"qtype_name"`
+	Rcode      *int      `json:"rcode,omitempty"`
+	RcodeName  string    `json:"rcode_name,omitempty"`
+	AA         bool      `json:"AA"`
+	TC         bool      `json:"TC"`
+	RD         bool      `json:"RD"`
+	RA         bool      `json:"RA"`
+	Z          uint8     `json:"Z"`
+	Answers    []string  `json:"answers,omitempty"`
+	TTLs       []float64 `json:"TTLs,omitempty"`
+	Rejected   bool      `json:"rejected"`
+}
+
+func zeekWriteJson(t *zeekTransaction) error {
+	row := zeekJsonRow{
+		Ts:         float64(t.ts.UnixMicro()) / 1e6,
+		Uid:        zeekUid(t),
+		OrigH:      t.key.client,
+		OrigP:      t.key.clientPort,
+		RespH:      t.key.server,
+		RespP:      t.key.serverPort,
+		Proto:      zeekProto(t),
+		TransId:    t.key.id,
+		Query:      zeekName(t.query),
+		Qclass:     t.qclass,
+		QclassName: zeekQclassName(t.qclass),
+		Qtype:      t.qtype,
+		QtypeName:  zeekQtypeName(t.qtype),
+		AA:         t.aa,
+		TC:         t.tc,
+		RD:         t.rd,
+		RA:         t.ra,
+		Z:          t.z,
+		Answers:    t.answers,
+		Rejected:   t.rejected,
+	}
+	if t.hasRtt {
+		rtt := t.rtt.Seconds()
+		row.Rtt = &rtt
+	}
+	if t.hasRcode {
+		rcode := t.rcode
+		row.Rcode = &rcode
+		row.RcodeName = zeekRcodeName(t.rcode)
+	}
+	for _, ttl := range t.ttls {
+		row.TTLs = append(row.TTLs, float64(ttl))
+	}
+
+	jsonData, err := json.Marshal(&row)
+	if err != nil {
+		return err
+	}
+	zeekWriter.Write(jsonData)
+	return zeekWriter.WriteByte('\n')
+}
+
+func toZeekCloser() {
+	zeekJoiner.close()
+
+	if !zeekJson {
+		fmt.Fprintf(zeekWriter, "#close\t%s\n", time.Now().Format("2006-01-02-15-04-05"))
+	}
+	if err := zeekWriter.Flush(); err != nil {
+		log.Warn().Msgf("%v", err)
+	}
+}
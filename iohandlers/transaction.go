@@ -0,0 +1,231 @@
+package iohandlers
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// A dnsMessage is a DNS message put back together from the records that were
+// marshaled for it.
+type dnsMessage struct {
+	records []DnsSchema
+}
+
+// header returns the first record of the message, which holds the fields
+// shared by all of its records.
+func (m *dnsMessage) header() *DnsSchema {
+	return &m.records[0]
+}
+
+// time returns the capture time of the message.
+func (m *dnsMessage) time() time.Time {
+	if d := m.header(); !d.Time.IsZero() {
+		return d.Time
+	}
+	return time.Unix(m.header().Timestamp, 0)
+}
+
+// msg returns the message with the RRs of its records. Changes the stages
+// made to the records, such as anonymized addresses, are carried over and
+// the RRs of dropped records are left out.
+func (m *dnsMessage) msg() *dns.Msg {
+	d := m.header()
+	msg := &dns.Msg{}
+	if d.Msg != nil {
+		msg.MsgHdr = d.Msg.MsgHdr
+		msg.Question = d.Msg.Question
+	} else {
+		msg.MsgHdr = dns.MsgHdr{
+			Id:               d.Id,
+			Response:         d.Response,
+			Rcode:            d.Rcode,
+			Truncated:        d.Truncated,
+			RecursionDesired: d.RecursionDesired,
+		}
+		msg.Question = []dns.Question{{Name: d.Qname, Qtype: d.Qtype, Qclass: dns.ClassINET}}
+	}
+
+	for i := range m.records {
+		r := &m.records[i]
+		if r.Rtype == nil {
+			continue
+		}
+		rr := recordRR(r)
+		if rr == nil {
+			continue
+		}
+		switch {
+		case r.Answer:
+			msg.Answer = append(msg.Answer, rr)
+		case r.Authority:
+			msg.Ns = append(msg.Ns, rr)
+		case r.Additional:
+			msg.Extra = append(msg.Extra, rr)
+		}
+	}
+
+	// OPT RRs aren't marshaled as records
+	if d.Msg != nil {
+		if opt := d.Msg.IsEdns0(); opt != nil {
+			msg.Extra = append(msg.Extra, recordOpt(d, opt))
+		}
+	}
+	return msg
+}
+
+// recordRR returns the RR of a record, parsing the RDATA of the record again
+// if it no longer matches the RR it came from.
+func recordRR(d *DnsSchema) dns.RR {
+	var section []dns.RR
+	if d.Msg != nil {
+		switch {
+		case d.Answer:
+			section = d.Msg.Answer
+		case d.Authority:
+			section = d.Msg.Ns
+		case d.Additional:
+			section = d.Msg.Extra
+		}
+	}
+
+	if d.RrIndex >= 0 && d.RrIndex < len(section) {
+		rr := section[d.RrIndex]
+		if strings.TrimPrefix(rr.String(), rr.Header().String()) == *d.Rdata {
+			return rr
+		}
+		if parsed, err := dns.NewRR(rr.Header().String() + *d.Rdata); err == nil {
+			return parsed
+		}
+		return nil
+	}
+
+	rr, err := dns.NewRR(fmt.Sprintf("%s %d %s %s",
+		*d.Rname, *d.Ttl, dns.TypeToString[*d.Rtype], *d.Rdata))
+	if err != nil {
+		return nil
+	}
+	return rr
+}
+
+// recordOpt returns the OPT RR of a message with the client subnet of the
+// record, which may have been anonymized.
+func recordOpt(d *DnsSchema, opt *dns.OPT) *dns.OPT {
+	copied := &dns.OPT{Hdr: opt.Hdr}
+	for _, o := range opt.Option {
+		if subnet, ok := o.(*dns.EDNS0_SUBNET); ok && d.EcsClient != nil {
+			if addr := net.ParseIP(*d.EcsClient); addr != nil && !addr.Equal(subnet.Address) {
+				changed := *subnet
+				changed.Address = addr
+				o = &changed
+			}
+		}
+		copied.Option = append(copied.Option, o)
+	}
+	return copied
+}
+
+// A transactionKey identifies the transaction a message belongs to.
+type transactionKey struct {
+	client, server         string
+	clientPort, serverPort uint16
+	id                     uint16
+}
+
+func (m *dnsMessage) key() transactionKey {
+	d := m.header()
+	if d.Response {
+		return transactionKey{d.DestinationAddress, d.SourceAddress, d.DestinationPort, d.SourcePort, d.Id}
+	}
+	return transactionKey{d.SourceAddress, d.DestinationAddress, d.SourcePort, d.DestinationPort, d.Id}
+}
+
+// Queries without a response are given up on once a message this much later
+// has been seen.
+const queryTimeout = 30 * time.Second
+
+// A transactionJoiner is used by formats that describe transactions rather
+// than single records. It collects the records of each message and joins
+// queries with their responses, handing every transaction to emit once it
+// is complete. Either message may be nil when only one of them was seen.
+type transactionJoiner struct {
+	// Queries without a response are emitted on their own once a message
+	// this much later has been seen.
+	timeout time.Duration
+	emit    func(query, response *dnsMessage)
+
+	// The message whose records are being collected
+	message *dnsMessage
+	// Queries waiting for their response, in the order they were seen
+	pending map[transactionKey]*dnsMessage
+	queue   []*dnsMessage
+}
+
+func newTransactionJoiner(timeout time.Duration, emit func(query, response *dnsMessage)) *transactionJoiner {
+	return &transactionJoiner{
+		timeout: timeout,
+		emit:    emit,
+		pending: make(map[transactionKey]*dnsMessage),
+	}
+}
+
+// add collects a record. The records of a message arrive one after the
+// other, so a record from a different packet completes the previous message.
+func (j *transactionJoiner) add(d *DnsSchema) {
+	if j.message != nil && j.message.header().Sha256 != d.Sha256 {
+		j.finish(j.message)
+		j.message = nil
+	}
+	if j.message == nil {
+		j.message = &dnsMessage{}
+	}
+	j.message.records = append(j.message.records, *d)
+}
+
+// finish handles a complete message. A query waits for its response, while a
+// response completes the pending query or is emitted on its own.
+func (j *transactionJoiner) finish(m *dnsMessage) {
+	key := m.key()
+	if !m.header().Response {
+		if q, ok := j.pending[key]; ok {
+			j.emit(q, nil)
+		}
+		j.pending[key] = m
+		j.queue = append(j.queue, m)
+	} else if q, ok := j.pending[key]; ok {
+		delete(j.pending, key)
+		j.emit(q, m)
+	} else {
+		j.emit(nil, m)
+	}
+
+	// Emit the queries that timed out, skipping those already answered
+	deadline := m.time().Add(-j.timeout)
+	for len(j.queue) > 0 && j.queue[0].time().Before(deadline) {
+		q := j.queue[0]
+		j.queue[0] = nil
+		j.queue = j.queue[1:]
+		if j.pending[q.key()] == q {
+			delete(j.pending, q.key())
+			j.emit(q, nil)
+		}
+	}
+}
+
+// close completes the last message and emits the queries still waiting for
+// a response.
+func (j *transactionJoiner) close() {
+	if j.message != nil {
+		j.finish(j.message)
+		j.message = nil
+	}
+	for _, q := range j.queue {
+		if j.pending[q.key()] == q {
+			j.emit(q, nil)
+		}
+	}
+	j.pending, j.queue = nil, nil
+}
@@ -0,0 +1,211 @@
+package iohandlers
+
+import (
+	"math"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func init() {
+	Enrichments["tunnel"] = enrichTunnel
+}
+
+// The tunnel enrichment scores how much the traffic to each registered domain
+// looks like DNS tunneling or exfiltration. Over tumbling windows it tracks
+// for each domain the length and entropy of the subdomains queried, the
+// number of distinct subdomains, the share of TXT, NULL and CNAME queries and
+// the size of the responses. Every record gets the score of its domain so
+// far in the window, and an alert is raised the first time in a window the
+// domain crosses a threshold.
+
+// tunnelMinMessages is the number of messages a domain needs in a window
+// before its score can raise an alert.
+const tunnelMinMessages = 10
+
+// A TunnelConfig holds the options for the tunnel enrichment.
+type TunnelConfig struct {
+	// Window is the length of the tumbling windows, aligned to the epoch.
+	Window time.Duration
+	// Score is the score from which a domain raises an alert.
+	Score uint8
+	// Subdomains is the number of distinct subdomains in a window from
+	// which a domain raises an alert.
+	Subdomains uint64
+}
+
+var Tunnel = TunnelConfig{
+	Window:     time.Minute,
+	Score:      70,
+	Subdomains: 200,
+}
+
+type tunnelDomain struct {
+	messages uint64
+	// Sums over the messages of the longest label and the entropy of the
+	// subdomain
+	labelLength uint64
+	entropy     float64
+	subdomains  distinctCounter
+	// Queries for types used to carry data
+	dataTypes uint64
+	responses uint64
+	bytes     uint64
+	score     uint8
+	// Whether the score and subdomains alerts were raised in the window
+	scoreAlerted, subdomainsAlerted bool
+}
+
+var (
+	tunnelDomains = make(map[string]*tunnelDomain)
+	// The start of the current window
+	tunnelWindow time.Time
+	// The message whose records are being enriched
+	tunnelSha256 string
+)
+
+// shannonEntropy returns the entropy of the characters of a string in bits
+// per character.
+func shannonEntropy(s string) float64 {
+	if s == "" {
+		return 0
+	}
+	var counts [256]int
+	for i := 0; i < len(s); i++ {
+		counts[s[i]]++
+	}
+	entropy := 0.0
+	for _, n := range counts {
+		if n > 0 {
+			p := float64(n) / float64(len(s))
+			entropy -= p * math.Log2(p)
+		}
+	}
+	return entropy
+}
+
+// longestLabel returns the length of the longest label of a name.
+func longestLabel(name string) int {
+	longest := 0
+	for _, label := range strings.Split(name, ".") {
+		if len(label) > longest {
+			longest = len(label)
+		}
+	}
+	return longest
+}
+
+// clamp limits a value to between 0 and 1.
+func clamp(x float64) float64 {
+	return math.Max(0, math.Min(1, x))
+}
+
+// tunnelScore combines the measurements of a domain into a score from 0 to
+// 100. Long, random-looking subdomains that are all different, queried for
+// types that carry data and answered with large responses score highest.
+func (t *tunnelDomain) tunnelScore() uint8 {
+	n := float64(t.messages)
+	length := clamp(float64(t.labelLength) / n / 52)
+	entropy := clamp((t.entropy/n - 2.5) / 2)
+	subdomains := clamp(float64(t.subdomains.count()) / float64(Tunnel.Subdomains))
+	dataTypes := float64(t.dataTypes) / n
+	size := 0.0
+	if t.responses > 0 {
+		size = clamp((float64(t.bytes)/float64(t.responses) - 128) / 384)
+	}
+
+	score := 0.2*length + 0.25*entropy + 0.3*subdomains + 0.1*dataTypes + 0.15*size
+	return uint8(math.Round(100 * score))
+}
+
+func (t *tunnelDomain) details() map[string]interface{} {
+	n := float64(t.messages)
+	details := map[string]interface{}{
+		"window":             int64(Tunnel.Window / time.Second),
+		"messages":           t.messages,
+		"unique_subdomains":  t.subdomains.count(),
+		"avg_longest_label":  math.Round(float64(t.labelLength)/n*10) / 10,
+		"avg_entropy":        math.Round(t.entropy/n*100) / 100,
+		"data_type_messages": t.dataTypes,
+	}
+	if t.responses > 0 {
+		details["avg_response_size"] = t.bytes / t.responses
+	}
+	return details
+}
+
+// enrichTunnel adds the tunnel score of the registered domain of the qname.
+// The records of a message arrive one after the other, so only the first of
+// them is accounted for.
+func enrichTunnel(d *DnsSchema) bool {
+	t := d.Time
+	if t.IsZero() {
+		t = time.Unix(d.Timestamp, 0)
+	}
+	if w := t.Truncate(Tunnel.Window); w.After(tunnelWindow) {
+		tunnelDomains = make(map[string]*tunnelDomain)
+		tunnelWindow = w
+	}
+
+	suffix, domain, subdomain := PublicSuffixes.Split(d.Qname)
+	if domain == "" {
+		domain = suffix
+	}
+	td, ok := tunnelDomains[domain]
+	if !ok {
+		td = &tunnelDomain{}
+		tunnelDomains[domain] = td
+	}
+
+	if d.Sha256 != tunnelSha256 || d.Sha256 == "" {
+		tunnelSha256 = d.Sha256
+		td.messages++
+		td.labelLength += uint64(longestLabel(subdomain))
+		td.entropy += shannonEntropy(strings.ReplaceAll(subdomain, ".", ""))
+		if subdomain != "" {
+			td.subdomains.add(subdomain)
+		}
+		switch d.Qtype {
+		case dns.TypeTXT, dns.TypeNULL, dns.TypeCNAME:
+			td.dataTypes++
+		}
+		if d.Response {
+			td.responses++
+			td.bytes += uint64(d.Size)
+		}
+		td.score = td.tunnelScore()
+		tunnelAlert(d, domain, td)
+	}
+
+	score := td.score
+	d.TunnelScore = &score
+	return true
+}
+
+// tunnelAlert raises the alerts of a domain crossing a threshold for the
+// first time in the window.
+func tunnelAlert(d *DnsSchema, domain string, td *tunnelDomain) {
+	if !td.scoreAlerted && td.messages >= tunnelMinMessages && td.score >= Tunnel.Score {
+		td.scoreAlerted = true
+		emitAlert(d, &Alert{
+			Type:    "tunnel",
+			Domain:  domain,
+			Qname:   d.Qname,
+			Score:   td.score,
+			Reason:  "Tunnel score above threshold",
+			Details: td.details(),
+		})
+	}
+	if !td.subdomainsAlerted && td.subdomains.count() >= Tunnel.Subdomains {
+		td.subdomainsAlerted = true
+		emitAlert(d, &Alert{
+			Type:    "tunnel",
+			Domain:  domain,
+			Qname:   d.Qname,
+			Score:   td.score,
+			Reason:  "Unique subdomains above threshold",
+			Details: td.details(),
+		})
+	}
+}
@@ -0,0 +1,232 @@
+package iohandlers
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// A tunnelTest runs the tunnel enrichment afresh with the given options,
+// writing alerts to a file that is read back by alerts.
+type tunnelTest struct {
+	t    *testing.T
+	path string
+	// The start of the first window
+	start time.Time
+	// Messages sent so far, to give each its own hash
+	messages int
+}
+
+func newTunnelTest(t *testing.T, config TunnelConfig) *tunnelTest {
+	saved, savedFile := Tunnel, AlertFile
+	t.Cleanup(func() {
+		closeAlerts()
+		Tunnel, AlertFile = saved, savedFile
+		tunnelDomains = make(map[string]*tunnelDomain)
+		tunnelWindow = time.Time{}
+		tunnelSha256 = ""
+	})
+
+	Tunnel = config
+	tunnelDomains = make(map[string]*tunnelDomain)
+	tunnelWindow = time.Time{}
+	tunnelSha256 = ""
+
+	tt := &tunnelTest{
+		t:     t,
+		path:  filepath.Join(t.TempDir(), "alerts.json"),
+		start: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+	}
+	AlertFile = tt.path
+	initializeAlerts()
+	return tt
+}
+
+// message enriches the records of a response for a name, the given time
+// into the first window, and returns the score of its last record.
+func (tt *tunnelTest) message(after time.Duration, qname string, qtype uint16, size, records int) uint8 {
+	tt.messages++
+	var score uint8
+	for i := 0; i < records; i++ {
+		d := &DnsSchema{
+			Timestamp: tt.start.Add(after).Unix(),
+			Time:      tt.start.Add(after),
+			Sha256:    fmt.Sprint(tt.messages),
+			Response:  true,
+			Qname:     qname,
+			Qtype:     qtype,
+			Size:      size,
+			RrIndex:   i,
+		}
+		if !enrichTunnel(d) {
+			tt.t.Fatal("record dropped")
+		}
+		if d.TunnelScore == nil {
+			tt.t.Fatal("tunnel score not set")
+		}
+		score = *d.TunnelScore
+	}
+	return score
+}
+
+// alerts closes the alert file and returns the alerts written to it.
+func (tt *tunnelTest) alerts() []Alert {
+	tt.t.Helper()
+	closeAlerts()
+	f, err := os.Open(tt.path)
+	if err != nil {
+		tt.t.Fatal(err)
+	}
+	defer f.Close()
+
+	var alerts []Alert
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var a Alert
+		if err := json.Unmarshal(scanner.Bytes(), &a); err != nil {
+			tt.t.Fatalf("%v: %s", err, scanner.Bytes())
+		}
+		alerts = append(alerts, a)
+	}
+	return alerts
+}
+
+// encodedLabel returns a label that looks like encoded data, which differs
+// for each n.
+func encodedLabel(n int) string {
+	sum := sha256.Sum256([]byte(fmt.Sprint(n)))
+	return strings.ToLower(base32.StdEncoding.EncodeToString(sum[:]))[:52]
+}
+
+func TestShannonEntropy(t *testing.T) {
+	tests := []struct {
+		s    string
+		want float64
+	}{
+		{"", 0},
+		{"aaaa", 0},
+		{"abab", 1},
+		{"abcd", 2},
+		{"0123456789abcdef", 4},
+	}
+	for _, tt := range tests {
+		if got := shannonEntropy(tt.s); got != tt.want {
+			t.Errorf("shannonEntropy(%q) = %v, want %v", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestTunnelScore(t *testing.T) {
+	tt := newTunnelTest(t, TunnelConfig{Window: time.Minute, Score: 70, Subdomains: 200})
+
+	// Ordinary lookups of a few names
+	var benign uint8
+	for i := 0; i < 30; i++ {
+		name := []string{"www.example.com.", "mail.example.com.", "example.com."}[i%3]
+		benign = tt.message(time.Second, name, dns.TypeA, 80, 1)
+	}
+	if benign > 10 {
+		t.Errorf("ordinary lookups scored %d", benign)
+	}
+
+	// Data encoded in the subdomains of TXT queries with large responses
+	var tunnel uint8
+	for i := 0; i < 30; i++ {
+		tunnel = tt.message(time.Second, encodedLabel(i)+".t.example.net.", dns.TypeTXT, 512, 1)
+	}
+	if tunnel < 70 {
+		t.Errorf("tunneling scored %d", tunnel)
+	}
+}
+
+func TestTunnelWindow(t *testing.T) {
+	tt := newTunnelTest(t, TunnelConfig{Window: time.Minute, Score: 70, Subdomains: 200})
+
+	// The records of a message are accounted for once
+	tt.message(0, encodedLabel(0)+".example.net.", dns.TypeTXT, 512, 3)
+	tt.message(10*time.Second, encodedLabel(1)+".example.net.", dns.TypeTXT, 512, 2)
+	if got := tunnelDomains["example.net"].messages; got != 2 {
+		t.Errorf("got %d messages, want 2", got)
+	}
+	if got := tunnelDomains["example.net"].subdomains.count(); got != 2 {
+		t.Errorf("got %d subdomains, want 2", got)
+	}
+	windowScore := tunnelDomains["example.net"].score
+
+	// Packets arriving slightly out of order are accounted for in the
+	// current window
+	tt.message(-time.Second, encodedLabel(2)+".example.net.", dns.TypeTXT, 512, 1)
+	if got := tunnelDomains["example.net"].messages; got != 3 {
+		t.Errorf("got %d messages after a late packet, want 3", got)
+	}
+
+	// The next window starts afresh
+	score := tt.message(time.Minute, "www.example.net.", dns.TypeA, 80, 1)
+	if got := tunnelDomains["example.net"].messages; got != 1 {
+		t.Errorf("got %d messages in the next window, want 1", got)
+	}
+	if score >= windowScore {
+		t.Errorf("next window scored %d, want less than %d", score, windowScore)
+	}
+	if !tunnelWindow.Equal(tt.start.Add(time.Minute)) {
+		t.Errorf("window starts at %v, want %v", tunnelWindow, tt.start.Add(time.Minute))
+	}
+}
+
+func TestTunnelAlerts(t *testing.T) {
+	tt := newTunnelTest(t, TunnelConfig{Window: time.Minute, Score: 60, Subdomains: 5})
+
+	// A domain crossing the score needs enough messages to raise an alert,
+	// and only raises it once in the window
+	for i := 0; i < tunnelMinMessages+5; i++ {
+		tt.message(time.Second, encodedLabel(i)+".t.example.net.", dns.TypeTXT, 512, 2)
+	}
+	// It raises it again in the next window
+	for i := 0; i < tunnelMinMessages; i++ {
+		tt.message(time.Minute, encodedLabel(100+i)+".t.example.net.", dns.TypeTXT, 512, 1)
+	}
+	// Many short subdomains raise an alert without crossing the score
+	for i := 0; i < 10; i++ {
+		tt.message(time.Minute, fmt.Sprintf("host%d.example.org.", i), dns.TypeA, 80, 1)
+	}
+
+	type alert struct {
+		domain, reason string
+		timestamp      int64
+		messages       float64
+	}
+	start := tt.start.Unix()
+	want := []alert{
+		{"example.net", "Unique subdomains above threshold", start + 1, 5},
+		{"example.net", "Tunnel score above threshold", start + 1, tunnelMinMessages},
+		{"example.net", "Unique subdomains above threshold", start + 60, 5},
+		{"example.net", "Tunnel score above threshold", start + 60, tunnelMinMessages},
+		{"example.org", "Unique subdomains above threshold", start + 60, 5},
+	}
+
+	alerts := tt.alerts()
+	if len(alerts) != len(want) {
+		t.Fatalf("got %d alerts, want %d: %+v", len(alerts), len(want), alerts)
+	}
+	for i, a := range alerts {
+		got := alert{a.Domain, a.Reason, a.Timestamp, a.Details["messages"].(float64)}
+		if got != want[i] {
+			t.Errorf("alert %d: got %+v, want %+v", i, got, want[i])
+		}
+		if a.Type != "tunnel" {
+			t.Errorf("alert %d: got type %q", i, a.Type)
+		}
+		if a.Reason == "Tunnel score above threshold" && a.Score < 60 {
+			t.Errorf("alert %d: got score %d", i, a.Score)
+		}
+	}
+}
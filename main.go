@@ -4,10 +4,19 @@ import (
 	"fmt"
 	"github.com/rs/zerolog"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
+	"github.com/chazlever/rickybobby/anonymize"
+	"github.com/chazlever/rickybobby/domainlist"
+	"github.com/chazlever/rickybobby/filter"
+	"github.com/chazlever/rickybobby/firstseen"
+	"github.com/chazlever/rickybobby/geoip"
 	"github.com/chazlever/rickybobby/iohandlers"
 	"github.com/chazlever/rickybobby/parser"
+	"github.com/chazlever/rickybobby/psl"
 	"github.com/pkg/profile"
 	"github.com/rs/zerolog/log"
 	"gopkg.in/urfave/cli.v1"
@@ -15,6 +24,10 @@ import (
 
 var logLevels = []string{"debug", "info", "warn", "error"}
 
+// Reloaders are called when a SIGHUP is received while reading from a live
+// interface.
+var reloaders []func() error
+
 func isValidLogLevel(level string) bool {
 	if level == "" {
 		return true
@@ -36,6 +49,42 @@ func getOutputFormats() []string {
 	return marshalers
 }
 
+func getOutputs() []string {
+	outputs := []string{"stdout"}
+	for o := range iohandlers.Outputs {
+		outputs = append(outputs, o)
+	}
+
+	return outputs
+}
+
+func getEncoders() []string {
+	encoders := make([]string, 0, len(iohandlers.Encoders))
+	for e := range iohandlers.Encoders {
+		encoders = append(encoders, e)
+	}
+
+	return encoders
+}
+
+func getAggregations() []string {
+	aggregations := make([]string, 0, len(iohandlers.Aggregators))
+	for a := range iohandlers.Aggregators {
+		aggregations = append(aggregations, a)
+	}
+
+	return aggregations
+}
+
+func getEnrichments() []string {
+	enrichments := make([]string, 0, len(iohandlers.Enrichments))
+	for e := range iohandlers.Enrichments {
+		enrichments = append(enrichments, e)
+	}
+
+	return enrichments
+}
+
 func loadGlobalOptions(c *cli.Context) error {
 	parser.BpfFilter = c.GlobalString("bpf-filter")
 	parser.DoParseQuestions = c.GlobalBool("questions")
@@ -80,9 +129,327 @@ func loadGlobalOptions(c *cli.Context) error {
 			1)
 	}
 
+	outputName := c.GlobalString("output")
+	if _, ok := iohandlers.Outputs[outputName]; !ok && outputName != "stdout" {
+		return cli.NewExitError(
+			fmt.Sprintf("ERROR: Invalid output: \"%s\" not in %v",
+				outputName,
+				getOutputs()),
+			1)
+	}
+	if _, ok := iohandlers.Encoders[outputFormat]; !ok && outputName != "stdout" {
+		return cli.NewExitError(
+			fmt.Sprintf("ERROR: Output format \"%s\" can not be used with the %s output, use one of %v",
+				outputFormat,
+				outputName,
+				getEncoders()),
+			1)
+	}
+	iohandlers.OutputName = outputName
+
+	aggregateName := c.GlobalString("aggregate")
+	if _, ok := iohandlers.Aggregators[aggregateName]; !ok && aggregateName != "" {
+		return cli.NewExitError(
+			fmt.Sprintf("ERROR: Invalid aggregation: \"%s\" not in %v",
+				aggregateName,
+				getAggregations()),
+			1)
+	}
+	iohandlers.SetAggregation(aggregateName)
+	for _, format := range iohandlers.MessageFormats {
+		if aggregateName != "" && outputFormat == format {
+			return cli.NewExitError(
+				fmt.Sprintf("ERROR: Output format \"%s\" describes DNS messages and can not be used with the %s aggregation",
+					outputFormat,
+					aggregateName),
+				1)
+		}
+	}
+
+	if aggregateName == "pdns" {
+		iohandlers.Pdns.Window = c.GlobalDuration("pdns-window")
+		iohandlers.Pdns.MaxMemory = c.GlobalInt("pdns-max-memory") << 20
+		if iohandlers.Pdns.MaxMemory < 1 {
+			return cli.NewExitError("ERROR: --pdns-max-memory must be at least 1", 1)
+		}
+	}
+
+	if aggregateName == "rollup" {
+		iohandlers.Rollup.Keys = nil
+		for _, key := range strings.Split(c.GlobalString("rollup-keys"), ",") {
+			key = strings.TrimSpace(key)
+			valid := false
+			for _, k := range iohandlers.RollupKeys {
+				valid = valid || k == key
+			}
+			if !valid {
+				return cli.NewExitError(
+					fmt.Sprintf("ERROR: Invalid rollup key: \"%s\" not in %v",
+						key,
+						iohandlers.RollupKeys),
+					1)
+			}
+			iohandlers.Rollup.Keys = append(iohandlers.Rollup.Keys, key)
+		}
+		iohandlers.Rollup.Window = c.GlobalDuration("rollup-window")
+		if iohandlers.Rollup.Window < time.Second {
+			return cli.NewExitError("ERROR: --rollup-window must be at least 1s", 1)
+		}
+	}
+
+	if outputName == "kafka" {
+		if brokers := c.GlobalString("kafka-brokers"); brokers != "" {
+			iohandlers.Kafka.Brokers = strings.Split(brokers, ",")
+		}
+		iohandlers.Kafka.Topic = c.GlobalString("kafka-topic")
+		iohandlers.Kafka.PartitionBy = c.GlobalString("kafka-partition-by")
+		iohandlers.Kafka.BatchBytes = c.GlobalInt("kafka-batch-bytes")
+		iohandlers.Kafka.Linger = c.GlobalDuration("kafka-linger")
+		iohandlers.Kafka.Compression = c.GlobalString("kafka-compression")
+		iohandlers.Kafka.Acks = c.GlobalString("kafka-acks")
+		if len(iohandlers.Kafka.Brokers) == 0 {
+			return cli.NewExitError("ERROR: kafka output requires --kafka-brokers", 1)
+		}
+	}
+
+	if outputName == "http" || outputName == "elasticsearch" {
+		iohandlers.Http.Url = c.GlobalString("http-url")
+		iohandlers.Http.BatchSize = c.GlobalInt("http-batch-size")
+		iohandlers.Http.BatchBytes = c.GlobalInt("http-batch-bytes")
+		iohandlers.Http.FlushInterval = c.GlobalDuration("http-flush-interval")
+		iohandlers.Http.MaxPending = c.GlobalInt("http-max-pending")
+		iohandlers.Http.MaxRetries = c.GlobalInt("http-max-retries")
+		if outputName == "http" && iohandlers.Http.Url == "" {
+			return cli.NewExitError("ERROR: http output requires --http-url", 1)
+		}
+	}
+
+	if outputName == "syslog" {
+		iohandlers.Syslog.Address = c.GlobalString("syslog-address")
+		iohandlers.Syslog.Facility = c.GlobalString("syslog-facility")
+		iohandlers.Syslog.AppName = c.GlobalString("syslog-app-name")
+	}
+
+	if outputName == "elasticsearch" {
+		iohandlers.Elasticsearch.Url = c.GlobalString("elasticsearch-url")
+		iohandlers.Elasticsearch.Index = c.GlobalString("elasticsearch-index")
+		if iohandlers.Elasticsearch.Url == "" {
+			return cli.NewExitError("ERROR: elasticsearch output requires --elasticsearch-url", 1)
+		}
+	}
+
+	iohandlers.RegistryUrl = c.GlobalString("registry-url")
+	iohandlers.RegistrySubject = c.GlobalString("registry-subject")
+	iohandlers.RegistrySchemaId = c.GlobalInt("registry-schema-id")
+	if outputFormat == "avro-registry" && iohandlers.RegistryUrl == "" && iohandlers.RegistrySchemaId == 0 {
+		return cli.NewExitError("ERROR: avro-registry format requires --registry-url or --registry-schema-id", 1)
+	}
+
+	iohandlers.Version = c.App.Version
+	iohandlers.Cdns.BlockSize = c.GlobalInt("cdns-block-size")
+	iohandlers.Cdns.HostId = parser.Sensor
+	iohandlers.Cdns.AnonymizationMethod = c.GlobalString("anonymize")
+	if outputFormat == "cdns" && iohandlers.Cdns.BlockSize < 1 {
+		return cli.NewExitError("ERROR: --cdns-block-size must be at least 1", 1)
+	}
+	iohandlers.Arrow.BatchSize = c.GlobalInt("arrow-batch-size")
+	if outputFormat == "arrow" && iohandlers.Arrow.BatchSize < 1 {
+		return cli.NewExitError("ERROR: --arrow-batch-size must be at least 1", 1)
+	}
+	iohandlers.NullValue = c.GlobalString("null")
+
+	if path := c.GlobalString("psl-file"); path != "" {
+		list, err := psl.Load(path)
+		if err != nil {
+			return cli.NewExitError(
+				fmt.Sprintf("ERROR: Could not load public suffix list: %v", err),
+				1)
+		}
+		iohandlers.PublicSuffixes = list
+		reloaders = append(reloaders, list.Reload)
+	}
+
+	if paths := c.GlobalString("geoip-db"); paths != "" {
+		dbs, err := geoip.Open(strings.Split(paths, ",")...)
+		if err != nil {
+			return cli.NewExitError(
+				fmt.Sprintf("ERROR: Could not load GeoIP database: %v", err),
+				1)
+		}
+		iohandlers.GeoIP = dbs
+		reloaders = append(reloaders, dbs.Reload)
+	}
+
+	if path := c.GlobalString("nod-db"); path != "" {
+		store, err := firstseen.Open(path, c.GlobalDuration("nod-expiry"))
+		if err != nil {
+			return cli.NewExitError(
+				fmt.Sprintf("ERROR: Could not open seen domains database: %v", err),
+				1)
+		}
+		iohandlers.FirstSeen = store
+	}
+
+	iohandlers.AlertFile = c.GlobalString("alert-file")
+	iohandlers.Tunnel.Window = c.GlobalDuration("tunnel-window")
+	if iohandlers.Tunnel.Window < time.Second {
+		return cli.NewExitError("ERROR: --tunnel-window must be at least 1s", 1)
+	}
+	score := c.GlobalInt("tunnel-score")
+	if score < 1 || score > 100 {
+		return cli.NewExitError("ERROR: --tunnel-score must be between 1 and 100", 1)
+	}
+	iohandlers.Tunnel.Score = uint8(score)
+	subdomains := c.GlobalInt("tunnel-subdomains")
+	if subdomains < 1 {
+		return cli.NewExitError("ERROR: --tunnel-subdomains must be at least 1", 1)
+	}
+	iohandlers.Tunnel.Subdomains = uint64(subdomains)
+
+	// Enrich before selecting fields, which may be those the enrichments
+	// add, and before filtering so the added fields can be filtered on
+	if names := c.GlobalString("enrich"); names != "" {
+		for _, name := range strings.Split(names, ",") {
+			enrich, ok := iohandlers.Enrichments[strings.TrimSpace(name)]
+			if !ok {
+				return cli.NewExitError(
+					fmt.Sprintf("ERROR: Invalid enrichment: \"%s\" not in %v",
+						name,
+						getEnrichments()),
+					1)
+			}
+			if strings.TrimSpace(name) == "geoip" && iohandlers.GeoIP == nil {
+				return cli.NewExitError("ERROR: The geoip enrichment requires --geoip-db", 1)
+			}
+			if strings.TrimSpace(name) == "nod" && iohandlers.FirstSeen == nil {
+				return cli.NewExitError("ERROR: The nod enrichment requires --nod-db", 1)
+			}
+			if strings.TrimSpace(name) == "case" && !parser.DoParseQuestions {
+				log.Warn().Msg("Without --questions the case enrichment only compares responses to their answers")
+			}
+			iohandlers.EnableEnrichment(strings.TrimSpace(name))
+			iohandlers.Stages = append(iohandlers.Stages, enrich)
+		}
+	}
+
+	if fields := c.GlobalString("fields"); fields != "" {
+		if err := iohandlers.SelectFields(strings.Split(fields, ",")); err != nil {
+			return cli.NewExitError(
+				fmt.Sprintf("ERROR: Invalid fields: %v", err),
+				1)
+		}
+	} else if aggregateName == "rollup" {
+		// Only output the fields the messages are grouped by
+		iohandlers.SelectFields(iohandlers.RollupFields())
+	}
+
+	if expr := c.GlobalString("filter"); expr != "" {
+		f, err := filter.Compile(expr)
+		if err != nil {
+			return cli.NewExitError(
+				fmt.Sprintf("ERROR: Invalid filter expression: %v", err),
+				1)
+		}
+		iohandlers.Stages = append(iohandlers.Stages, f.Match)
+	}
+
+	if path := c.GlobalString("include-domains"); path != "" {
+		include, err := domainlist.Load(path)
+		if err != nil {
+			return cli.NewExitError(
+				fmt.Sprintf("ERROR: Could not load domain list: %v", err),
+				1)
+		}
+		iohandlers.Stages = append(iohandlers.Stages, include.MatchRecord)
+		reloaders = append(reloaders, include.Reload)
+	}
+
+	if path := c.GlobalString("exclude-domains"); path != "" {
+		exclude, err := domainlist.Load(path)
+		if err != nil {
+			return cli.NewExitError(
+				fmt.Sprintf("ERROR: Could not load domain list: %v", err),
+				1)
+		}
+		iohandlers.Stages = append(iohandlers.Stages, func(d *iohandlers.DnsSchema) bool {
+			return !exclude.MatchRecord(d)
+		})
+		reloaders = append(reloaders, exclude.Reload)
+	}
+
+	// Anonymize last so filters still operate on the real addresses
+	if mode := c.GlobalString("anonymize"); mode != "" {
+		var key []byte
+		if path := c.GlobalString("anonymize-key"); path != "" {
+			var err error
+			if key, err = anonymize.ReadKey(path); err != nil {
+				return cli.NewExitError(
+					fmt.Sprintf("ERROR: Could not read anonymization key: %v", err),
+					1)
+			}
+		}
+
+		anonymizer, err := anonymize.New(mode, key)
+		if err != nil {
+			return cli.NewExitError(
+				fmt.Sprintf("ERROR: Invalid anonymization options: %v", err),
+				1)
+		}
+		anonymizer.AnonymizeRdata = c.GlobalBool("anonymize-rdata")
+		iohandlers.Stages = append(iohandlers.Stages, anonymizer.Apply)
+	}
+
 	return nil
 }
 
+// reloadOnHangup calls all reloaders whenever the process receives a SIGHUP.
+func reloadOnHangup() {
+	if len(reloaders) == 0 {
+		return
+	}
+
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	go func() {
+		for range hangup {
+			log.Info().Msg("Received SIGHUP, reloading")
+			for _, reload := range reloaders {
+				if err := reload(); err != nil {
+					log.Error().Msgf("Error reloading: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// closeOnInterrupt closes the outputs and the seen domains database and exits
+// when the process is interrupted or terminated, so the records and updates
+// held in memory aren't lost. A second signal exits right away.
+func closeOnInterrupt() {
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-interrupt
+		signal.Stop(interrupt)
+		log.Info().Msgf("Received %v, exiting", sig)
+		parser.Stop()
+		iohandlers.Close(parser.OutputFormat)
+		closeFirstSeen()
+		os.Exit(0)
+	}()
+}
+
+// closeFirstSeen writes the updates held in memory to the seen domains
+// database and closes it.
+func closeFirstSeen() {
+	if iohandlers.FirstSeen == nil {
+		return
+	}
+	if err := iohandlers.FirstSeen.Close(); err != nil {
+		log.Error().Msgf("Error closing seen domains database: %v", err)
+	}
+}
+
 func pcapCommand(c *cli.Context) error {
 	if c.NArg() < 1 {
 		return cli.NewExitError("ERROR: must provide at least one filename", 1)
@@ -96,6 +463,8 @@ func pcapCommand(c *cli.Context) error {
 		return err
 	}
 
+	defer closeFirstSeen()
+
 	for _, f := range c.Args() {
 		parser.ParseFile(f)
 	}
@@ -119,10 +488,58 @@ func liveCommand(c *cli.Context) error {
 	snapshotLen := int32(c.Int("snaplen"))
 	promiscuous := c.Bool("promiscuous")
 
+	defer closeFirstSeen()
+	reloadOnHangup()
+	closeOnInterrupt()
+
 	parser.ParseDevice(c.Args().First(), snapshotLen, promiscuous)
 	return nil
 }
 
+func cdnsCommand(c *cli.Context) error {
+	if c.NArg() < 1 {
+		return cli.NewExitError("ERROR: must provide at least one filename", 1)
+	}
+
+	if c.GlobalBool("profile") {
+		defer profile.Start().Stop()
+	}
+
+	if err := loadGlobalOptions(c); err != nil {
+		return err
+	}
+
+	defer closeFirstSeen()
+
+	for _, f := range c.Args() {
+		parser.ParseCdnsFile(f)
+	}
+	return nil
+}
+
+func schemaCommand(c *cli.Context) error {
+	if err := loadGlobalOptions(c); err != nil {
+		return err
+	}
+	defer closeFirstSeen()
+
+	switch c.Args().First() {
+	case "", "avro":
+		fmt.Println(iohandlers.AvroSchema())
+	case "json":
+		fmt.Println(iohandlers.JsonSchema())
+	case "clickhouse":
+		fmt.Println(iohandlers.ClickHouseSchema("dns"))
+	default:
+		return cli.NewExitError(
+			fmt.Sprintf("ERROR: Invalid schema type: \"%s\" not in %v",
+				c.Args().First(),
+				[]string{"avro", "json", "clickhouse"}),
+			1)
+	}
+	return nil
+}
+
 func main() {
 	app := cli.NewApp()
 	app.Name = "rickybobby"
@@ -161,6 +578,18 @@ func main() {
 				},
 			},
 		},
+		{
+			Name:      "cdns",
+			Usage:     "read messages from a C-DNS file",
+			Action:    cdnsCommand,
+			ArgsUsage: "[file...]",
+		},
+		{
+			Name:      "schema",
+			Usage:     "print the Avro, JSON or ClickHouse schema of the output records",
+			Action:    schemaCommand,
+			ArgsUsage: "[avro|json|clickhouse]",
+		},
 	}
 
 	app.Flags = []cli.Flag{
@@ -168,6 +597,69 @@ func main() {
 			Name:  "bpf-filter",
 			Usage: "specify a BPF filter to use for filtering packets",
 		},
+		cli.StringFlag{
+			Name:  "enrich",
+			Usage: fmt.Sprintf("comma-separated list of enrichments adding fields to records %+q", getEnrichments()),
+		},
+		cli.StringFlag{
+			Name:  "psl-file",
+			Usage: "read the public suffix list from `FILE` instead of using the built-in list",
+		},
+		cli.StringFlag{
+			Name:  "geoip-db",
+			Usage: "comma-separated list of MaxMind DB `FILES` (e.g. GeoLite2 City and ASN) for the geoip enrichment",
+		},
+		cli.StringFlag{
+			Name:  "nod-db",
+			Usage: "keep the registered domains seen before for the nod enrichment in the database `FILE`",
+		},
+		cli.DurationFlag{
+			Name:  "nod-expiry",
+			Usage: "how long domains not seen again are remembered by the nod enrichment, or forever if 0",
+		},
+		cli.StringFlag{
+			Name:  "alert-file",
+			Usage: "append alerts raised by enrichments to `FILE` as JSON lines instead of logging them",
+		},
+		cli.DurationFlag{
+			Name:  "tunnel-window",
+			Usage: "length of the windows the tunnel enrichment scores domains over",
+			Value: iohandlers.Tunnel.Window,
+		},
+		cli.IntFlag{
+			Name:  "tunnel-score",
+			Usage: "tunnel score from which a domain raises an alert",
+			Value: int(iohandlers.Tunnel.Score),
+		},
+		cli.IntFlag{
+			Name:  "tunnel-subdomains",
+			Usage: "number of unique subdomains in a window from which a domain raises an alert",
+			Value: int(iohandlers.Tunnel.Subdomains),
+		},
+		cli.StringFlag{
+			Name:  "filter",
+			Usage: "specify an expression on DNS fields for filtering records (e.g. 'qname endswith example.com and rcode == NXDOMAIN')",
+		},
+		cli.StringFlag{
+			Name:  "include-domains",
+			Usage: "only output records whose qname or rname is in the domains listed in `FILE`",
+		},
+		cli.StringFlag{
+			Name:  "exclude-domains",
+			Usage: "drop records whose qname or rname is in the domains listed in `FILE`",
+		},
+		cli.StringFlag{
+			Name:  "anonymize",
+			Usage: fmt.Sprintf("anonymize IP addresses using the given mode %+q", anonymize.Modes),
+		},
+		cli.StringFlag{
+			Name:  "anonymize-key",
+			Usage: "read the anonymization key from `FILE` (32 bytes for cryptopan)",
+		},
+		cli.BoolFlag{
+			Name:  "anonymize-rdata",
+			Usage: "also anonymize addresses in A and AAAA records",
+		},
 		cli.BoolFlag{
 			Name:  "questions",
 			Usage: "parse questions in addition to responses",
@@ -193,6 +685,152 @@ func main() {
 			Usage: fmt.Sprintf("specify the output formatter to use %+q", getOutputFormats()),
 			Value: "json",
 		},
+		cli.StringFlag{
+			Name:  "fields",
+			Usage: fmt.Sprintf("comma-separated list of fields to output %+q and those added by --enrich", iohandlers.FieldNames()),
+		},
+		cli.StringFlag{
+			Name:  "null",
+			Usage: "string to output for null fields in the csv and tsv formats",
+		},
+		cli.StringFlag{
+			Name:  "output",
+			Usage: fmt.Sprintf("specify where records are written %+q", getOutputs()),
+			Value: "stdout",
+		},
+		cli.StringFlag{
+			Name:  "aggregate",
+			Usage: fmt.Sprintf("summarize records instead of writing each of them %+q, in any format except %+q", getAggregations(), iohandlers.MessageFormats),
+		},
+		cli.StringFlag{
+			Name:  "kafka-brokers",
+			Usage: "comma-separated list of seed brokers for the kafka output",
+		},
+		cli.StringFlag{
+			Name:  "kafka-topic",
+			Usage: "topic for the kafka output; {sensor} and {source} are replaced with the record fields",
+			Value: iohandlers.Kafka.Topic,
+		},
+		cli.StringFlag{
+			Name:  "kafka-partition-by",
+			Usage: fmt.Sprintf("partition records of the kafka output by %+q instead of spreading them evenly", iohandlers.KafkaPartitioners),
+		},
+		cli.IntFlag{
+			Name:  "kafka-batch-bytes",
+			Usage: "maximum size of a batch for the kafka output",
+			Value: iohandlers.Kafka.BatchBytes,
+		},
+		cli.DurationFlag{
+			Name:  "kafka-linger",
+			Usage: "time to wait for batches of the kafka output to fill",
+			Value: iohandlers.Kafka.Linger,
+		},
+		cli.StringFlag{
+			Name:  "kafka-compression",
+			Usage: fmt.Sprintf("compression for the kafka output %+q", iohandlers.KafkaCompressions),
+			Value: iohandlers.Kafka.Compression,
+		},
+		cli.StringFlag{
+			Name:  "kafka-acks",
+			Usage: fmt.Sprintf("acknowledgements required for the kafka output %+q", iohandlers.KafkaAcks),
+			Value: iohandlers.Kafka.Acks,
+		},
+		cli.StringFlag{
+			Name:  "http-url",
+			Usage: "URL the http output POSTs batches of records to",
+		},
+		cli.IntFlag{
+			Name:  "http-batch-size",
+			Usage: "maximum number of records in a batch for the http and elasticsearch outputs",
+			Value: iohandlers.Http.BatchSize,
+		},
+		cli.IntFlag{
+			Name:  "http-batch-bytes",
+			Usage: "maximum size of a batch for the http and elasticsearch outputs, or 0 for no limit",
+			Value: iohandlers.Http.BatchBytes,
+		},
+		cli.DurationFlag{
+			Name:  "http-flush-interval",
+			Usage: "longest time a record waits before being sent by the http and elasticsearch outputs",
+			Value: iohandlers.Http.FlushInterval,
+		},
+		cli.IntFlag{
+			Name:  "http-max-pending",
+			Usage: "number of full batches the http and elasticsearch outputs buffer in memory before parsing blocks",
+			Value: iohandlers.Http.MaxPending,
+		},
+		cli.IntFlag{
+			Name:  "http-max-retries",
+			Usage: "number of times the http and elasticsearch outputs retry a failed batch",
+			Value: iohandlers.Http.MaxRetries,
+		},
+		cli.StringFlag{
+			Name:  "elasticsearch-url",
+			Usage: "base URL of the Elasticsearch or OpenSearch cluster for the elasticsearch output",
+		},
+		cli.StringFlag{
+			Name:  "elasticsearch-index",
+			Usage: "index name for the elasticsearch output; %Y, %m, %d and %H are replaced with the record date",
+			Value: iohandlers.Elasticsearch.Index,
+		},
+		cli.StringFlag{
+			Name:  "syslog-address",
+			Usage: "address for the syslog output as udp://host:port, tcp://host:port, tls://host:port or unix:///path",
+			Value: iohandlers.Syslog.Address,
+		},
+		cli.StringFlag{
+			Name:  "syslog-facility",
+			Usage: "facility name or number for the syslog output",
+			Value: iohandlers.Syslog.Facility,
+		},
+		cli.StringFlag{
+			Name:  "syslog-app-name",
+			Usage: "APP-NAME for the syslog output",
+			Value: iohandlers.Syslog.AppName,
+		},
+		cli.StringFlag{
+			Name:  "registry-url",
+			Usage: "URL of the schema registry to register the schema with for the avro-registry format",
+		},
+		cli.StringFlag{
+			Name:  "registry-subject",
+			Usage: "subject to register the schema under for the avro-registry format",
+			Value: "rickybobby-value",
+		},
+		cli.IntFlag{
+			Name:  "registry-schema-id",
+			Usage: "use a pre-assigned schema ID instead of registering the schema for the avro-registry format",
+		},
+		cli.IntFlag{
+			Name:  "cdns-block-size",
+			Usage: "maximum number of query/response items in a block of the cdns format",
+			Value: iohandlers.Cdns.BlockSize,
+		},
+		cli.IntFlag{
+			Name:  "arrow-batch-size",
+			Usage: "maximum number of rows in a record batch of the arrow format",
+			Value: iohandlers.Arrow.BatchSize,
+		},
+		cli.DurationFlag{
+			Name:  "pdns-window",
+			Usage: "length of the windows passive DNS records are counted over, or 0 to count until exiting",
+			Value: iohandlers.Pdns.Window,
+		},
+		cli.IntFlag{
+			Name:  "pdns-max-memory",
+			Usage: "megabytes the passive DNS table may use before it is flushed early",
+			Value: iohandlers.Pdns.MaxMemory >> 20,
+		},
+		cli.StringFlag{
+			Name:  "rollup-keys",
+			Usage: fmt.Sprintf("comma-separated list of fields the rollup aggregation groups messages by %+q", iohandlers.RollupKeys),
+			Value: strings.Join(iohandlers.Rollup.Keys, ","),
+		},
+		cli.DurationFlag{
+			Name:  "rollup-window",
+			Usage: "length of the windows the rollup aggregation counts messages over",
+			Value: iohandlers.Rollup.Window,
+		},
 		cli.StringFlag{
 			Name:  "log-level",
 			Usage: fmt.Sprintf("specify the log level to use %+q", logLevels),
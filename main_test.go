@@ -0,0 +1,53 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/chazlever/rickybobby/domainlist"
+)
+
+func TestReloadOnHangup(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "domains.txt")
+	if err := os.WriteFile(path, []byte("example.com\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	list, err := domainlist.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded := make(chan error, 1)
+	saved := reloaders
+	defer func() { reloaders = saved }()
+	reloaders = []func() error{func() error {
+		err := list.Reload()
+		reloaded <- err
+		return err
+	}}
+	reloadOnHangup()
+
+	if err := os.WriteFile(path, []byte("example.org\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if !list.Match("www.example.com") {
+		t.Fatal("list changed before the SIGHUP")
+	}
+	if err := syscall.Kill(os.Getpid(), syscall.SIGHUP); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-reloaded:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("list not reloaded after SIGHUP")
+	}
+	if list.Match("www.example.com") || !list.Match("www.example.org") {
+		t.Error("SIGHUP didn't replace the list")
+	}
+}
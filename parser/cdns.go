@@ -0,0 +1,116 @@
+package parser
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/chazlever/rickybobby/cdns"
+	"github.com/chazlever/rickybobby/iohandlers"
+	"github.com/miekg/dns"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// ParseCdnsFile outputs the records of the messages stored in a C-DNS file,
+// as if they had been read from a PCAP file.
+func ParseCdnsFile(fname string) {
+	var in io.Reader = os.Stdin
+	if "-" != fname {
+		f, err := os.Open(fname)
+		if err != nil {
+			log.Fatal().Msgf("Could not open C-DNS file: %v", err)
+		}
+		defer f.Close()
+		in = f
+	}
+
+	reader, err := cdns.NewReader(in)
+	if err != nil {
+		log.Fatal().Msgf("Could not read C-DNS file: %v", err)
+	}
+
+	var (
+		schema iohandlers.DnsSchema
+		stats  Statistics
+	)
+
+	// The sensor recorded in the file is used unless one was given
+	schema.Sensor = Sensor
+	if schema.Sensor == "" {
+		schema.Sensor = reader.HostId()
+	}
+	schema.Source = Source
+
+	iohandlers.Initialize(OutputFormat)
+
+	for {
+		qr, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Error().Msgf("Error reading C-DNS file: %v", err)
+			stats.PacketErrors += 1
+			var itemErr *cdns.ItemError
+			if errors.As(err, &itemErr) {
+				continue
+			}
+			break
+		}
+
+		client, server := qr.ClientAddress.Unmap(), qr.ServerAddress.Unmap()
+		for _, msg := range []*dns.Msg{qr.Query, qr.Response} {
+			if msg == nil {
+				continue
+			}
+			stats.PacketTotal += 1
+			stats.PacketDns += 1
+
+			schema.Ipv4 = client.Is4()
+			if schema.Ipv4 {
+				stats.PacketIPv4 += 1
+			} else {
+				stats.PacketIPv6 += 1
+			}
+			schema.Udp = !qr.Tcp
+			if schema.Udp {
+				stats.PacketUdp += 1
+			} else {
+				stats.PacketTcp += 1
+			}
+
+			timestamp := qr.Time
+			if msg.Response {
+				if qr.Query != nil {
+					timestamp = timestamp.Add(qr.ResponseDelay)
+				}
+				schema.SourceAddress, schema.SourcePort = server.String(), qr.ServerPort
+				schema.DestinationAddress, schema.DestinationPort = client.String(), qr.ClientPort
+			} else {
+				schema.SourceAddress, schema.SourcePort = client.String(), qr.ClientPort
+				schema.DestinationAddress, schema.DestinationPort = server.String(), qr.ServerPort
+			}
+
+			// Hash and salt the message for grouping related records. The
+			// packet itself isn't stored, so this differs from the hash of
+			// the packet the message was read from.
+			tsSalt, _ := timestamp.MarshalBinary()
+			wire, err := msg.Pack()
+			if err != nil {
+				wire = []byte(msg.String())
+			}
+			schema.Sha256 = fmt.Sprintf("%x", sha256.Sum256(append(tsSalt, wire...)))
+			schema.Size = len(wire)
+
+			marshalMsg(&schema, msg, timestamp)
+		}
+	}
+
+	iohandlers.Close(OutputFormat)
+	stats.DeliveryErrors = uint(iohandlers.DeliveryErrors())
+
+	log.WithLevel(zerolog.NoLevel).Str("level", "stats").Object("packetCounts", stats).Msg("Summary of packet counts")
+}
@@ -12,6 +12,8 @@ import (
 	"github.com/rs/zerolog/log"
 	"io"
 	"os"
+	"sync"
+	"time"
 )
 
 var (
@@ -21,8 +23,18 @@ var (
 	Source              = ""
 	Sensor              = ""
 	OutputFormat        = ""
+
+	// marshaling is held while the records of a packet are marshaled.
+	marshaling sync.Mutex
 )
 
+// Stop waits for the records of the packet being handled to be marshaled,
+// and keeps those of any later packet from being marshaled, so the outputs
+// can be closed while a capture is running.
+func Stop() {
+	marshaling.Lock()
+}
+
 func ParseFile(fname string) {
 	var (
 		handle *pcap.Handle
@@ -56,6 +68,7 @@ func ParseDevice(device string, snapshotLen int32, promiscuous bool) {
 		log.Fatal().Err(err)
 	}
 	defer handle.Close()
+	defer tickAggregation()()
 
 	// Setup BPF filter on handle
 	if BpfFilter != "" {
@@ -67,6 +80,30 @@ func ParseDevice(device string, snapshotLen int32, promiscuous bool) {
 	ParseDns(handle)
 }
 
+// tickAggregation ends the windows of the aggregation by the clock every
+// second until the returned function is called, as the packets of a live
+// capture may stop arriving at any time.
+func tickAggregation() (stop func()) {
+	ticker := time.NewTicker(time.Second)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case now := <-ticker.C:
+				marshaling.Lock()
+				iohandlers.Tick(now)
+				marshaling.Unlock()
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		ticker.Stop()
+		close(done)
+	}
+}
+
 func ParseDns(handle *pcap.Handle) {
 	var (
 		schema iohandlers.DnsSchema
@@ -168,6 +205,7 @@ PACKETLOOP:
 			schema.SourcePort = uint16(udp.SrcPort)
 			schema.DestinationPort = uint16(udp.DstPort)
 			schema.Udp = true
+			schema.Size = len(udp.Payload)
 
 			// Hash and salt packet for grouping related records
 			tsSalt, err := packet.Metadata().Timestamp.MarshalBinary()
@@ -184,87 +222,105 @@ PACKETLOOP:
 			continue PACKETLOOP
 		}
 
-		// Ignore questions unless flag set
-		if !msg.Response && !DoParseQuestions && !DoParseQuestionsEcs {
-			continue PACKETLOOP
-		}
+		marshaling.Lock()
+		marshalMsg(&schema, msg, packet.Metadata().Timestamp)
+		marshaling.Unlock()
+	}
 
-		// Fill out information from DNS headers
-		schema.Timestamp = packet.Metadata().Timestamp.Unix()
-		schema.Id = msg.Id
-		schema.Rcode = msg.Rcode
-		schema.Truncated = msg.Truncated
-		schema.Response = msg.Response
-		schema.RecursionDesired = msg.RecursionDesired
-
-		// Parse ECS information
-		schema.EcsClient = nil
-		schema.EcsSource = nil
-		schema.EcsScope = nil
-		if opt := msg.IsEdns0(); opt != nil {
-			for _, s := range opt.Option {
-				switch o := s.(type) {
-				case *dns.EDNS0_SUBNET:
-					ecsClient := o.Address.String()
-					ecsSource := o.SourceNetmask
-					ecsScope := o.SourceScope
-					schema.EcsClient = &ecsClient
-					schema.EcsSource = &ecsSource
-					schema.EcsScope = &ecsScope
-				}
-			}
-		}
+	// Cleanup IO handler for output format, keeping the aggregation from
+	// being ticked meanwhile
+	marshaling.Lock()
+	iohandlers.Close(OutputFormat)
+	marshaling.Unlock()
+	stats.DeliveryErrors = uint(iohandlers.DeliveryErrors())
 
-		// Reset RR information
-		schema.Ttl = nil
-		schema.Rname = nil
-		schema.Rdata = nil
-		schema.Rtype = nil
-
-		// Let's get QUESTION
-		// TODO: Throw error if there's more than one question
-		for _, qr := range msg.Question {
-			schema.Qname = qr.Name
-			schema.Qtype = qr.Qtype
-		}
+	//log.Info().Object("packetCounts", stats).Msg("Summary of packet counts")
+	log.WithLevel(zerolog.NoLevel).Str("level", "stats").Object("packetCounts", stats).Msg("Summary of packet counts")
+}
+
+// marshalMsg outputs the records of a decoded DNS message. The schema holds
+// the fields describing the packet the message was carried in.
+func marshalMsg(schema *iohandlers.DnsSchema, msg *dns.Msg, timestamp time.Time) {
+	// Ignore questions unless flag set
+	if !msg.Response && !DoParseQuestions && !DoParseQuestionsEcs {
+		return
+	}
 
-		// Get a count of RRs in DNS response
-		rrCount := 0
-		for _, rr := range append(append(msg.Answer, msg.Ns...), msg.Extra...) {
-			if rr.Header().Rrtype != 41 {
-				rrCount++
+	// Fill out information from DNS headers
+	schema.Time = timestamp
+	schema.Timestamp = schema.Time.Unix()
+	schema.Msg = msg
+	schema.Id = msg.Id
+	schema.Rcode = msg.Rcode
+	schema.Truncated = msg.Truncated
+	schema.Response = msg.Response
+	schema.RecursionDesired = msg.RecursionDesired
+
+	// Parse ECS information
+	schema.EcsClient = nil
+	schema.EcsSource = nil
+	schema.EcsScope = nil
+	if opt := msg.IsEdns0(); opt != nil {
+		for _, s := range opt.Option {
+			switch o := s.(type) {
+			case *dns.EDNS0_SUBNET:
+				ecsClient := o.Address.String()
+				ecsSource := o.SourceNetmask
+				ecsScope := o.SourceScope
+				schema.EcsClient = &ecsClient
+				schema.EcsSource = &ecsSource
+				schema.EcsScope = &ecsScope
 			}
 		}
+	}
 
-		// Let's output records without RRs records if:
-		//   1. Questions flag is set and record is question
-		//   2. QuestionsEcs flag is set and question record contains ECS information
-		//   4. Any response without any RRs (e.g., NXDOMAIN without SOA, REFUSED, etc.)
-		if (DoParseQuestions && !schema.Response) ||
-			(DoParseQuestionsEcs && schema.EcsClient != nil && !schema.Response) ||
-			(schema.Response && rrCount < 1) {
-			schema.Marshal(nil, -1, OutputFormat)
-		}
+	// Reset RR information
+	schema.Ttl = nil
+	schema.Rname = nil
+	schema.Rdata = nil
+	schema.Rtype = nil
+
+	// Let's get QUESTION
+	// TODO: Throw error if there's more than one question
+	for _, qr := range msg.Question {
+		schema.Qname = qr.Name
+		schema.Qtype = qr.Qtype
+	}
 
-		// Let's get ANSWERS
-		for _, rr := range msg.Answer {
-			schema.Marshal(&rr, iohandlers.DnsAnswer, OutputFormat)
+	// Get a count of RRs in DNS response
+	rrCount := 0
+	for _, rr := range append(append(msg.Answer, msg.Ns...), msg.Extra...) {
+		if rr.Header().Rrtype != 41 {
+			rrCount++
 		}
+	}
 
-		// Let's get AUTHORITATIVE information
-		for _, rr := range msg.Ns {
-			schema.Marshal(&rr, iohandlers.DnsAuthority, OutputFormat)
-		}
+	// Let's output records without RRs records if:
+	//   1. Questions flag is set and record is question
+	//   2. QuestionsEcs flag is set and question record contains ECS information
+	//   4. Any response without any RRs (e.g., NXDOMAIN without SOA, REFUSED, etc.)
+	if (DoParseQuestions && !schema.Response) ||
+		(DoParseQuestionsEcs && schema.EcsClient != nil && !schema.Response) ||
+		(schema.Response && rrCount < 1) {
+		schema.RrIndex = -1
+		schema.Marshal(nil, -1, OutputFormat)
+	}
 
-		// Let's get ADDITIONAL information
-		for _, rr := range msg.Extra {
-			schema.Marshal(&rr, iohandlers.DnsAdditional, OutputFormat)
-		}
+	// Let's get ANSWERS
+	for i, rr := range msg.Answer {
+		schema.RrIndex = i
+		schema.Marshal(&rr, iohandlers.DnsAnswer, OutputFormat)
 	}
 
-	// Cleanup IO handler for output format
-	iohandlers.Close(OutputFormat)
+	// Let's get AUTHORITATIVE information
+	for i, rr := range msg.Ns {
+		schema.RrIndex = i
+		schema.Marshal(&rr, iohandlers.DnsAuthority, OutputFormat)
+	}
 
-	//log.Info().Object("packetCounts", stats).Msg("Summary of packet counts")
-	log.WithLevel(zerolog.NoLevel).Str("level", "stats").Object("packetCounts", stats).Msg("Summary of packet counts")
+	// Let's get ADDITIONAL information
+	for i, rr := range msg.Extra {
+		schema.RrIndex = i
+		schema.Marshal(&rr, iohandlers.DnsAdditional, OutputFormat)
+	}
 }
@@ -16,6 +16,8 @@ type Statistics struct {
 	PacketUdp    uint `json:"packetUdp"`
 	PacketDns    uint `json:"packetDns"`
 	PacketErrors uint `json:"packetErrors"`
+	// Records that could not be delivered to the output
+	DeliveryErrors uint `json:"deliveryErrors"`
 }
 
 func (s Statistics) ToJson() {
@@ -33,5 +35,6 @@ func (s Statistics) MarshalZerologObject(e *zerolog.Event) {
 		Uint("TCP", s.PacketTcp).
 		Uint("UDP", s.PacketUdp).
 		Uint("DNS", s.PacketDns).
-		Uint("Failed", s.PacketErrors)
+		Uint("Failed", s.PacketErrors).
+		Uint("Undelivered", s.DeliveryErrors)
 }
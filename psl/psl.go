@@ -0,0 +1,184 @@
+// Package psl splits domain names into their public suffix, registered
+// domain and subdomain using the Public Suffix List (https://publicsuffix.org).
+//
+// A copy of the list is embedded, and an up-to-date list can be loaded from
+// a file in the same format. Names are compared case-insensitively and
+// without regard for the trailing dot, and rules holding Unicode labels
+// match the names in their ASCII (punycode) form, as they are on the wire.
+package psl
+
+import (
+	"bufio"
+	_ "embed"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync/atomic"
+
+	"golang.org/x/net/idna"
+)
+
+//go:embed public_suffix_list.dat
+var embedded string
+
+// Kinds of rules. A wildcard rule "*.ck" is stored under "ck", and an
+// exception "!www.ck" under "www.ck".
+const (
+	ruleSuffix = 1 << iota
+	ruleWildcard
+	ruleException
+)
+
+// A List is a set of public suffix rules. It is safe to call Split while the
+// list is being reloaded.
+type List struct {
+	path  string
+	rules atomic.Pointer[map[string]uint8]
+}
+
+var defaultList = mustParse()
+
+func mustParse() *List {
+	l := &List{}
+	rules, err := parse(strings.NewReader(embedded), "embedded list")
+	if err != nil {
+		panic(err)
+	}
+	l.rules.Store(&rules)
+	return l
+}
+
+// Default returns the embedded list.
+func Default() *List {
+	return defaultList
+}
+
+// Load reads the list from the given file.
+func Load(path string) (*List, error) {
+	l := &List{path: path}
+	if err := l.Reload(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Reload re-reads the list from its file. The current list is kept if the
+// file can not be read. The embedded list is never reloaded.
+func (l *List) Reload() error {
+	if l.path == "" {
+		return nil
+	}
+
+	f, err := os.Open(l.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	rules, err := parse(f, l.path)
+	if err != nil {
+		return err
+	}
+	l.rules.Store(&rules)
+	return nil
+}
+
+func parse(r io.Reader, name string) (map[string]uint8, error) {
+	rules := make(map[string]uint8)
+	scanner := bufio.NewScanner(r)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		// Rules end at the first whitespace
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "//") {
+			continue
+		}
+		rule := fields[0]
+
+		kind := uint8(ruleSuffix)
+		switch {
+		case strings.HasPrefix(rule, "!"):
+			kind, rule = ruleException, rule[1:]
+		case strings.HasPrefix(rule, "*."):
+			kind, rule = ruleWildcard, rule[2:]
+		}
+
+		ascii, err := idna.Lookup.ToASCII(rule)
+		if err != nil || ascii == "" {
+			return nil, fmt.Errorf("%s:%d: invalid rule %q", name, lineno, fields[0])
+		}
+		rules[ascii] |= kind
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return rules, nil
+}
+
+// normalize returns a name in lowercase ASCII without the trailing dot.
+func normalize(name string) string {
+	name = strings.TrimSuffix(name, ".")
+	for i := 0; i < len(name); i++ {
+		if name[i] >= 0x80 {
+			if ascii, err := idna.Lookup.ToASCII(name); err == nil {
+				return ascii
+			}
+			break
+		}
+	}
+	return strings.ToLower(name)
+}
+
+// Split returns the public suffix of a name, the registered domain below it
+// and the subdomain left of the registered domain, in lowercase ASCII
+// without trailing dots. The domain and subdomain are empty when the name is
+// a public suffix itself, and the subdomain is empty when the name is the
+// registered domain. Names under a TLD missing from the list are assumed to
+// have that TLD as their public suffix.
+func (l *List) Split(name string) (suffix, domain, subdomain string) {
+	name = normalize(name)
+	if name == "" {
+		return "", "", ""
+	}
+	rules := *l.rules.Load()
+
+	// starts holds where the suffixes of the name begin, from the TLD to
+	// the whole name
+	var starts []int
+	for i := len(name); ; {
+		j := strings.LastIndexByte(name[:i], '.')
+		starts = append(starts, j+1)
+		if j < 0 {
+			break
+		}
+		i = j
+	}
+
+	// Find the number of labels of the public suffix. The longest matching
+	// rule wins, except that exceptions take precedence, and the TLD is a
+	// public suffix even if it isn't listed.
+	n := 1
+	for k := 1; k <= len(starts); k++ {
+		kind := rules[name[starts[k-1]:]]
+		if kind&ruleException != 0 {
+			n = k - 1
+			break
+		}
+		if kind&ruleSuffix != 0 {
+			n = k
+		}
+		if kind&ruleWildcard != 0 && k < len(starts) {
+			n = k + 1
+		}
+	}
+
+	suffix = name[starts[n-1]:]
+	if n == len(starts) {
+		return suffix, "", ""
+	}
+	domain = name[starts[n]:]
+	if starts[n] > 0 {
+		subdomain = name[:starts[n]-1]
+	}
+	return suffix, domain, subdomain
+}
@@ -0,0 +1,97 @@
+package psl
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		name                      string
+		suffix, domain, subdomain string
+	}{
+		{"", "", "", ""},
+		{".", "", "", ""},
+		{"com", "com", "", ""},
+		{"example.com", "com", "example.com", ""},
+		{"www.example.com", "com", "example.com", "www"},
+		{"a.b.example.co.uk", "co.uk", "example.co.uk", "a.b"},
+
+		// Trailing dots and case
+		{"com.", "com", "", ""},
+		{"WWW.Example.COM.", "com", "example.com", "www"},
+
+		// Wildcard rules make every label below them a public suffix
+		{"ck", "ck", "", ""},
+		{"foo.ck", "foo.ck", "", ""},
+		{"bar.foo.ck", "foo.ck", "bar.foo.ck", ""},
+		{"foo.bar.kawasaki.jp", "bar.kawasaki.jp", "foo.bar.kawasaki.jp", ""},
+		{"school.kent.sch.uk", "kent.sch.uk", "school.kent.sch.uk", ""},
+
+		// Exception rules take precedence over the wildcards
+		{"www.ck", "ck", "www.ck", ""},
+		{"a.www.ck", "ck", "www.ck", "a"},
+		{"x.city.kawasaki.jp", "kawasaki.jp", "city.kawasaki.jp", "x"},
+
+		// IDN labels in Unicode and punycode
+		{"中国", "xn--fiqs8s", "", ""},
+		{"例え.中国", "xn--fiqs8s", "xn--r8jz45g.xn--fiqs8s", ""},
+		{"www.Bücher.公司.cn", "xn--55qx5d.cn", "xn--bcher-kva.xn--55qx5d.cn", "www"},
+		{"www.xn--bcher-kva.xn--55qx5d.cn.", "xn--55qx5d.cn", "xn--bcher-kva.xn--55qx5d.cn", "www"},
+
+		// TLDs missing from the list are public suffixes
+		{"unknowntld", "unknowntld", "", ""},
+		{"www.example.unknowntld.", "unknowntld", "example.unknowntld", "www"},
+	}
+
+	for _, tt := range tests {
+		suffix, domain, subdomain := Default().Split(tt.name)
+		if suffix != tt.suffix || domain != tt.domain || subdomain != tt.subdomain {
+			t.Errorf("Split(%q) = %q, %q, %q, want %q, %q, %q", tt.name,
+				suffix, domain, subdomain, tt.suffix, tt.domain, tt.subdomain)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "list.dat")
+	write := func(list string) {
+		if err := os.WriteFile(path, []byte(list), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("// comment\ncom\n*.example.com  trailing text\n!www.example.com\n")
+	l, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if suffix, domain, _ := l.Split("a.b.example.com"); suffix != "b.example.com" || domain != "a.b.example.com" {
+		t.Errorf("wildcard: got %q, %q", suffix, domain)
+	}
+	if suffix, domain, _ := l.Split("www.example.com"); suffix != "example.com" || domain != "www.example.com" {
+		t.Errorf("exception: got %q, %q", suffix, domain)
+	}
+
+	// A list that can't be parsed is an error and keeps the current one
+	write("com\n*.\n")
+	if err := l.Reload(); err == nil {
+		t.Error("reloading an invalid list succeeded")
+	}
+	if suffix, _, _ := l.Split("a.b.example.com"); suffix != "b.example.com" {
+		t.Errorf("after a failed reload: got suffix %q", suffix)
+	}
+
+	write("com\n")
+	if err := l.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if suffix, domain, _ := l.Split("a.b.example.com"); suffix != "com" || domain != "example.com" {
+		t.Errorf("after reloading: got %q, %q", suffix, domain)
+	}
+
+	if _, err := Load(filepath.Join(t.TempDir(), "missing.dat")); err == nil {
+		t.Error("loading a missing list succeeded")
+	}
+}